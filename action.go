@@ -14,6 +14,7 @@ import (
 	"github.com/qingcloudhx/core/data/expression"
 	"github.com/qingcloudhx/core/data/mapper"
 	"github.com/qingcloudhx/core/data/metadata"
+	coreevent "github.com/qingcloudhx/core/engine/event"
 	"github.com/qingcloudhx/core/support"
 	"github.com/qingcloudhx/core/support/log"
 	"github.com/qingcloudhx/flow/definition"
@@ -21,21 +22,57 @@ import (
 	"github.com/qingcloudhx/flow/model"
 	_ "github.com/qingcloudhx/flow/model/simple"
 	flowSupport "github.com/qingcloudhx/flow/support"
+	"github.com/qingcloudhx/flow/support/event"
+	"github.com/qingcloudhx/flow/support/event/sink"
+	"github.com/qingcloudhx/flow/support/metrics"
+	"github.com/qingcloudhx/flow/support/trace"
 	"github.com/qingcloudhx/flow/tester"
 )
 
 const (
 	EnvFlowRecord = "FLOGO_FLOW_RECORD"
+
+	// EnvFlowCheckpointSteps is the number of recorded steps between full snapshots of an instance
+	EnvFlowCheckpointSteps = "FLOGO_FLOW_CHECKPOINT_STEPS"
+	// EnvFlowCheckpointInterval is the maximum time between recorded full snapshots of an instance
+	EnvFlowCheckpointInterval = "FLOGO_FLOW_CHECKPOINT_INTERVAL"
+
+	// EnvFlowMetrics enables the collection of the metrics of the flows by the default collector
+	EnvFlowMetrics = "FLOGO_FLOW_METRICS"
+
+	// EnvFlowTraceFile is the file to which the spans of the flows are exported, using the OTLP JSON encoding
+	EnvFlowTraceFile = "FLOGO_FLOW_TRACE_FILE"
+
+	// EnvFlowAuditFile is the JSON-lines file to which the events of the flows are appended
+	EnvFlowAuditFile = "FLOGO_FLOW_AUDIT_FILE"
+	// EnvFlowAuditMaxSize is the size, in bytes, above which the audit file is rotated
+	EnvFlowAuditMaxSize = "FLOGO_FLOW_AUDIT_MAX_SIZE"
+	// EnvFlowAuditMaxBackups is the number of rotated audit files kept
+	EnvFlowAuditMaxBackups = "FLOGO_FLOW_AUDIT_MAX_BACKUPS"
+	// EnvFlowEventWebhook is the URL to which the events of the flows are posted
+	EnvFlowEventWebhook = "FLOGO_FLOW_EVENT_WEBHOOK"
+	// EnvFlowEventFlows is the comma separated list of the names, or name patterns, of the flows
+	// whose events are sent to the sinks
+	EnvFlowEventFlows = "FLOGO_FLOW_EVENT_FLOWS"
+	// EnvFlowEventStatuses is the comma separated list of the statuses of the events sent to the sinks
+	EnvFlowEventStatuses = "FLOGO_FLOW_EVENT_STATUSES"
+
+	// EnvFlowRepository is the directory of the flows resolved by the "flow://" URIs
+	EnvFlowRepository = "FLOGO_FLOW_REPOSITORY"
 )
 
 func init() {
 	_ = action.Register(&FlowAction{}, &ActionFactory{})
-	_ = resource.RegisterLoader(flowSupport.ResTypeFlow, &flowSupport.FlowLoader{})
+	_ = resource.RegisterLoader(flowSupport.ResTypeFlow, flowLoader)
 }
 
+var flowLoader = &flowSupport.FlowLoader{}
+
 var ep ExtensionProvider
 var idGenerator *support.Generator
 var record bool
+var checkpointPolicy *instance.CheckpointPolicy
+var metricsCollector *metrics.Collector
 var maxStepCount = 1000000
 var actionMd = action.ToMetadata(&Settings{})
 var logger log.Logger
@@ -60,6 +97,14 @@ func (f *ActionFactory) Initialize(ctx action.InitContext) error {
 		return nil
 	}
 
+	// the flow resources and the flows of the provider are verified whatever the provider
+	verifier, err := flowSupport.FlowVerifierFromEnv()
+	if err != nil {
+		return err
+	}
+	flowLoader.Verifier = verifier
+	flowLoader.IntegrityDir = os.Getenv(flowSupport.EnvFlowIntegrityDir)
+
 	if ep == nil {
 		testerEnabled := os.Getenv(tester.EnvEnabled)
 		if strings.ToLower(testerEnabled) == "true" {
@@ -71,9 +116,68 @@ func (f *ActionFactory) Initialize(ctx action.InitContext) error {
 				return err
 			}
 			record = true
+			metricsCollector = metrics.DefaultCollector()
 		} else {
-			ep = NewDefaultExtensionProvider()
+			defaultProvider := NewDefaultExtensionProvider()
+
+			remoteConfig, err := flowSupport.RemoteConfigFromEnv()
+			if err != nil {
+				return err
+			}
+
+			remoteProvider, err := flowSupport.NewRemoteFlowProvider(remoteConfig)
+			if err != nil {
+				return err
+			}
+			defaultProvider.SetFlowProvider(remoteProvider)
+
+			if repository := os.Getenv(EnvFlowRepository); len(repository) > 0 {
+				provider, err := flowSupport.NewDirectoryFlowProvider(repository, remoteProvider)
+				if err != nil {
+					return err
+				}
+				defaultProvider.SetFlowProvider(provider)
+			}
+			ep = defaultProvider
 			record = recordFlows()
+			if collectMetrics() {
+				metricsCollector = metrics.DefaultCollector()
+			}
+		}
+	}
+
+	if verifier != nil {
+		provider, ok := ep.GetFlowProvider().(flowSupport.VerifyingProvider)
+		if !ok {
+			return fmt.Errorf("flow provider %T can't verify the signatures of the flows required by %s", ep.GetFlowProvider(), flowSupport.EnvFlowPublicKeys)
+		}
+		if err := provider.SetVerifier(verifier); err != nil {
+			return err
+		}
+	}
+
+	instance.SetMetricsCollector(metricsCollector)
+
+	if traceFile := os.Getenv(EnvFlowTraceFile); len(traceFile) > 0 {
+		exporter, err := trace.NewFileExporter(traceFile, "flogo")
+		if err != nil {
+			return fmt.Errorf("unable to create trace exporter: %v", err)
+		}
+
+		instance.SetTracer(trace.NewTracer(exporter, func(err error) {
+			logger.Errorf("Unable to export spans: %v", err)
+		}))
+	}
+
+	if err := registerEventSinks(); err != nil {
+		return err
+	}
+
+	if record {
+		var err error
+		checkpointPolicy, err = getCheckpointPolicy()
+		if err != nil {
+			return err
 		}
 	}
 
@@ -89,7 +193,11 @@ func (f *ActionFactory) Initialize(ctx action.InitContext) error {
 
 	//todo flow model create
 	model.RegisterDefault(ep.GetDefaultFlowModel())
-	flowManager = flowSupport.NewFlowManager(ep.GetFlowProvider())
+	cacheConfig, err := flowSupport.CacheConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	flowManager = flowSupport.NewFlowManagerWithCache(ep.GetFlowProvider(), cacheConfig)
 	flowSupport.InitDefaultDefLookup(flowManager, ctx.ResourceManager())
 	logger.Infof("[flow] ActionFactory Initialize finished......")
 	return nil
@@ -104,6 +212,100 @@ func recordFlows() bool {
 	return b
 }
 
+func collectMetrics() bool {
+	collect, _ := strconv.ParseBool(os.Getenv(EnvFlowMetrics))
+	return collect
+}
+
+// registerEventSinks registers the audit file and webhook sinks configured by the environment
+func registerEventSinks() error {
+
+	filter := &sink.Filter{FlowNames: splitList(os.Getenv(EnvFlowEventFlows))}
+	for _, status := range splitList(os.Getenv(EnvFlowEventStatuses)) {
+		filter.Statuses = append(filter.Statuses, event.Status(status))
+	}
+
+	if auditFile := os.Getenv(EnvFlowAuditFile); len(auditFile) > 0 {
+		config := sink.FileConfig{Path: auditFile, Filter: filter}
+
+		if maxSize := os.Getenv(EnvFlowAuditMaxSize); len(maxSize) > 0 {
+			var err error
+			config.MaxSize, err = strconv.ParseInt(maxSize, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s '%s': %v", EnvFlowAuditMaxSize, maxSize, err)
+			}
+		}
+
+		if maxBackups := os.Getenv(EnvFlowAuditMaxBackups); len(maxBackups) > 0 {
+			var err error
+			config.MaxBackups, err = strconv.Atoi(maxBackups)
+			if err != nil {
+				return fmt.Errorf("invalid %s '%s': %v", EnvFlowAuditMaxBackups, maxBackups, err)
+			}
+		}
+
+		fileSink, err := sink.NewFileSink(config)
+		if err != nil {
+			return fmt.Errorf("unable to create audit file sink: %v", err)
+		}
+
+		if err := coreevent.RegisterListener("flow-audit-file", fileSink, sink.EventTypes); err != nil {
+			return err
+		}
+	}
+
+	if webhook := os.Getenv(EnvFlowEventWebhook); len(webhook) > 0 {
+		webhookSink, err := sink.NewWebhookSink(sink.WebhookConfig{URL: webhook, Filter: filter, OnError: func(err error) {
+			logger.Errorf("Unable to send events: %v", err)
+		}})
+		if err != nil {
+			return fmt.Errorf("unable to create event webhook sink: %v", err)
+		}
+
+		if err := coreevent.RegisterListener("flow-event-webhook", webhookSink, sink.EventTypes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitList splits a comma separated list, ignoring the empty elements
+func splitList(list string) []string {
+
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); len(value) > 0 {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+func getCheckpointPolicy() (*instance.CheckpointPolicy, error) {
+
+	policy := &instance.CheckpointPolicy{}
+
+	if steps := os.Getenv(EnvFlowCheckpointSteps); len(steps) > 0 {
+		var err error
+		policy.Steps, err = strconv.Atoi(steps)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %v", EnvFlowCheckpointSteps, steps, err)
+		}
+	}
+
+	if interval := os.Getenv(EnvFlowCheckpointInterval); len(interval) > 0 {
+		var err error
+		policy.Interval, err = time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %v", EnvFlowCheckpointInterval, interval, err)
+		}
+	}
+
+	return policy, nil
+}
+
 func (f *ActionFactory) New(config *action.Config) (action.Action, error) {
 	logger = log.ChildLogger(log.RootLogger(), "flow")
 	flowAction := &FlowAction{}
@@ -159,6 +361,7 @@ func (fa *FlowAction) Run(context context.Context, inputs map[string]interface{}
 	op := instance.OpStart
 	retID := false
 	var initialState *instance.IndependentInstance
+	var restartFrom *instance.RestartFrom
 	var flowURI string
 
 	runOptions, exists := inputs["_run_options"]
@@ -172,6 +375,7 @@ func (fa *FlowAction) Run(context context.Context, inputs map[string]interface{}
 			op = ro.Op
 			retID = ro.ReturnID
 			initialState = ro.InitialState
+			restartFrom = ro.RestartFrom
 			flowURI = ro.FlowURI
 			execOptions = ro.ExecOptions
 		}
@@ -196,11 +400,15 @@ func (fa *FlowAction) Run(context context.Context, inputs map[string]interface{}
 		flowDef := fa.resFlow
 
 		if flowDef == nil {
+			// the flow is acquired until the instance acquires it, so that it can't be cleaned
+			// up by an eviction from the cache in between
+			var release func()
 			var err error
-			flowDef, err = flowManager.GetFlow(flowURI)
+			flowDef, release, err = flowManager.AcquireFlow(flowURI)
 			if err != nil {
 				return err
 			}
+			defer release()
 
 			if flowDef == nil {
 				return errors.New("flow not found for URI: " + flowURI)
@@ -244,6 +452,14 @@ func (fa *FlowAction) Run(context context.Context, inputs map[string]interface{}
 				return err
 			}
 
+			if restartFrom != nil {
+				err = inst.RestartFrom(restartFrom.TaskID, restartFrom.Inputs)
+				if err != nil {
+					return err
+				}
+				logger.Debugf("Restarting Flow Instance from task '%s'", restartFrom.TaskID)
+			}
+
 			logger.Debug("Restarting Flow Instance: ", instanceID)
 		} else {
 			return errors.New("unable to restart instance, initial state not provided")
@@ -260,6 +476,8 @@ func (fa *FlowAction) Run(context context.Context, inputs map[string]interface{}
 
 	logger.Debugf("Executing Flow Instance: %s", inst.ID())
 
+	inst.SetTraceContext(context)
+
 	if op == instance.OpStart {
 
 		inst.Start(inputs)
@@ -272,9 +490,36 @@ func (fa *FlowAction) Run(context context.Context, inputs map[string]interface{}
 
 	inst.SetResultHandler(handler)
 
+	registry := instance.GetDefaultRegistry()
+	instHandle := registry.Register(inst)
+
+	if execOptions != nil && execOptions.Debug != nil {
+		logger.Debugf("Instance [%s] is in debug mode", inst.ID())
+		instHandle.EnableDebug(execOptions.Debug)
+	}
+
+	checkpointer := instance.NewCheckpointer(checkpointPolicy)
+
+	if metricsCollector != nil {
+		switch op {
+		case instance.OpStart:
+			metricsCollector.FlowStarted(inst.Name())
+		case instance.OpResume:
+			metricsCollector.FlowResumed(inst.Name())
+		case instance.OpRestart:
+			metricsCollector.FlowRestarted(inst.Name())
+		}
+	}
+
 	go func() {
 
 		defer handler.Done()
+		defer func() {
+			// an instance waiting for an async activity is still live
+			if inst.Status() >= model.FlowStatusCompleted {
+				registry.Unregister(inst.ID())
+			}
+		}()
 
 		if retID {
 
@@ -289,11 +534,10 @@ func (fa *FlowAction) Run(context context.Context, inputs map[string]interface{}
 		for hasWork && inst.Status() < model.FlowStatusCompleted && stepCount < maxStepCount {
 			stepCount++
 			logger.Debugf("Step: %d", stepCount)
-			hasWork = inst.DoStep()
+			hasWork = instHandle.DoStep()
 
 			if record {
-				ep.GetStateRecorder().RecordSnapshot(inst)
-				ep.GetStateRecorder().RecordStep(inst)
+				checkpointer.Record(ep.GetStateRecorder(), inst)
 			}
 		}
 
@@ -302,14 +546,22 @@ func (fa *FlowAction) Run(context context.Context, inputs map[string]interface{}
 			handler.HandleResult(returnData, err)
 		} else if inst.Status() == model.FlowStatusFailed {
 			handler.HandleResult(nil, inst.GetError())
+		} else if inst.Status() == model.FlowStatusCancelled {
+			handler.HandleResult(nil, fmt.Errorf("flow instance [%s] cancelled", inst.ID()))
 		}
 
 		logger.Debugf("Done Executing flow instance [%s] - Status: %d", inst.ID(), inst.Status())
 
+		if metricsCollector != nil {
+			metricsCollector.FlowFinished(inst.Name(), inst.Status(), time.Since(start), stepCount)
+		}
+
 		if inst.Status() == model.FlowStatusCompleted {
 			logger.Infof("Instance [%s] [%d] Done", inst.ID(), time.Since(start)/1e6)
 		} else if inst.Status() == model.FlowStatusFailed {
 			logger.Infof("Instance [%s] [%d] Failed", inst.ID(), time.Since(start)/1e6)
+		} else if inst.Status() == model.FlowStatusCancelled {
+			logger.Infof("Instance [%s] [%d] Cancelled", inst.ID(), time.Since(start)/1e6)
 		}
 	}()
 
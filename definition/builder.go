@@ -0,0 +1,350 @@
+package definition
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/qingcloudhx/core/activity"
+	"github.com/qingcloudhx/core/data"
+	"github.com/qingcloudhx/core/data/metadata"
+)
+
+const (
+	linkTypeExpression = "expression"
+	taskTypeIterator   = "iterator"
+)
+
+// FlowBuilder builds a flow Definition in code, ex.
+//
+//	def, err := definition.NewFlow("orders").
+//		Input("orderId", data.TypeString).
+//		Task("lookup", definition.Activity("github.com/org/lookup").Input("id", "=$flow.orderId")).
+//		Task("notify", definition.Activity("github.com/org/notify").Input("message", "=$activity[lookup].status")).
+//		Link("lookup", "notify").When("$activity[lookup].found == true").
+//		ErrorHandler(definition.NewErrorHandler().Task("log", definition.Activity("log").Input("message", "=$error.message"))).
+//		Build()
+//
+// The methods configuring a task or a link, like Iterate or When, apply to the last one added.
+// The errors are reported by Build.
+type FlowBuilder struct {
+	rep   *DefinitionRep
+	graph graphBuilder
+	eh    *ErrorHandlerBuilder
+}
+
+// NewFlow creates a FlowBuilder for the flow
+func NewFlow(name string) *FlowBuilder {
+	return &FlowBuilder{rep: &DefinitionRep{Name: name}}
+}
+
+// Model sets the model of the flow
+func (b *FlowBuilder) Model(modelID string) *FlowBuilder {
+	b.rep.ModelID = modelID
+	return b
+}
+
+// ExplicitReply makes the flow reply explicitly
+func (b *FlowBuilder) ExplicitReply() *FlowBuilder {
+	b.rep.ExplicitReply = true
+	return b
+}
+
+// Input adds an input to the flow
+func (b *FlowBuilder) Input(name string, dataType data.Type) *FlowBuilder {
+	b.metadata().Input[name] = data.NewTypedValue(dataType, nil)
+	return b
+}
+
+// Output adds an output to the flow
+func (b *FlowBuilder) Output(name string, dataType data.Type) *FlowBuilder {
+	b.metadata().Output[name] = data.NewTypedValue(dataType, nil)
+	return b
+}
+
+// Sensitive marks inputs and outputs of the flow as sensitive
+func (b *FlowBuilder) Sensitive(names ...string) *FlowBuilder {
+	b.rep.Sensitive = append(b.rep.Sensitive, names...)
+	return b
+}
+
+// Task adds a task running the activity
+func (b *FlowBuilder) Task(id string, act *ActivityBuilder) *FlowBuilder {
+	b.graph.addTask(id, act)
+	return b
+}
+
+// Iterate makes the last task an iterator task, iterating over the value, ex. "=$flow.items"
+func (b *FlowBuilder) Iterate(over interface{}) *FlowBuilder {
+	b.graph.iterate(over)
+	return b
+}
+
+// TaskSetting sets a setting of the last task
+func (b *FlowBuilder) TaskSetting(name string, value interface{}) *FlowBuilder {
+	b.graph.setTaskSetting(name, value)
+	return b
+}
+
+// Link adds a link between two tasks
+func (b *FlowBuilder) Link(from, to string) *FlowBuilder {
+	b.graph.addLink(from, to)
+	return b
+}
+
+// When makes the last link an expression link, followed if the expression is true
+func (b *FlowBuilder) When(expr string) *FlowBuilder {
+	b.graph.when(expr)
+	return b
+}
+
+// ErrorHandler sets the error handler of the flow
+func (b *FlowBuilder) ErrorHandler(eh *ErrorHandlerBuilder) *FlowBuilder {
+	b.eh = eh
+	return b
+}
+
+// Rep validates the flow and returns its DefinitionRep
+func (b *FlowBuilder) Rep() (*DefinitionRep, error) {
+
+	if b.rep.Name == "" {
+		return nil, errors.New("flow name not set")
+	}
+
+	if err := b.graph.validate(); err != nil {
+		return nil, fmt.Errorf("invalid flow '%s': %s", b.rep.Name, err.Error())
+	}
+
+	rep := *b.rep
+	rep.Tasks = b.graph.tasks
+	rep.Links = b.graph.links
+	rep.ErrorHandler = nil
+
+	if b.eh != nil {
+		if err := b.eh.graph.validate(); err != nil {
+			return nil, fmt.Errorf("invalid error handler of flow '%s': %s", b.rep.Name, err.Error())
+		}
+		rep.ErrorHandler = &ErrorHandlerRep{Tasks: b.eh.graph.tasks, Links: b.eh.graph.links}
+	}
+
+	return &rep, nil
+}
+
+// Build validates the flow and creates its Definition
+func (b *FlowBuilder) Build() (*Definition, error) {
+
+	rep, err := b.Rep()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDefinition(rep)
+}
+
+func (b *FlowBuilder) metadata() *metadata.IOMetadata {
+
+	if b.rep.Metadata == nil {
+		b.rep.Metadata = &metadata.IOMetadata{}
+	}
+	if b.rep.Metadata.Input == nil {
+		b.rep.Metadata.Input = make(map[string]data.TypedValue)
+	}
+	if b.rep.Metadata.Output == nil {
+		b.rep.Metadata.Output = make(map[string]data.TypedValue)
+	}
+
+	return b.rep.Metadata
+}
+
+// ErrorHandlerBuilder builds the error handler of a flow
+type ErrorHandlerBuilder struct {
+	graph graphBuilder
+}
+
+// NewErrorHandler creates an ErrorHandlerBuilder
+func NewErrorHandler() *ErrorHandlerBuilder {
+	return &ErrorHandlerBuilder{}
+}
+
+// Task adds a task running the activity
+func (b *ErrorHandlerBuilder) Task(id string, act *ActivityBuilder) *ErrorHandlerBuilder {
+	b.graph.addTask(id, act)
+	return b
+}
+
+// Iterate makes the last task an iterator task, iterating over the value
+func (b *ErrorHandlerBuilder) Iterate(over interface{}) *ErrorHandlerBuilder {
+	b.graph.iterate(over)
+	return b
+}
+
+// TaskSetting sets a setting of the last task
+func (b *ErrorHandlerBuilder) TaskSetting(name string, value interface{}) *ErrorHandlerBuilder {
+	b.graph.setTaskSetting(name, value)
+	return b
+}
+
+// Link adds a link between two tasks
+func (b *ErrorHandlerBuilder) Link(from, to string) *ErrorHandlerBuilder {
+	b.graph.addLink(from, to)
+	return b
+}
+
+// When makes the last link an expression link, followed if the expression is true
+func (b *ErrorHandlerBuilder) When(expr string) *ErrorHandlerBuilder {
+	b.graph.when(expr)
+	return b
+}
+
+// ActivityBuilder builds the configuration of the activity of a task
+type ActivityBuilder struct {
+	config *activity.Config
+	err    error
+}
+
+// Activity creates an ActivityBuilder for the activity with the ref
+func Activity(ref string) *ActivityBuilder {
+	return &ActivityBuilder{config: &activity.Config{Ref: ref}}
+}
+
+// ActivityFor creates an ActivityBuilder for the registered activity
+func ActivityFor(act activity.Activity) *ActivityBuilder {
+
+	ref := activity.GetRef(act)
+	if activity.Get(ref) == nil {
+		return &ActivityBuilder{config: &activity.Config{Ref: ref}, err: fmt.Errorf("activity '%s' not registered", ref)}
+	}
+
+	return Activity(ref)
+}
+
+// Setting sets a setting of the activity
+func (b *ActivityBuilder) Setting(name string, value interface{}) *ActivityBuilder {
+	if b.config.Settings == nil {
+		b.config.Settings = make(map[string]interface{})
+	}
+	b.config.Settings[name] = value
+	return b
+}
+
+// Input sets an input of the activity, a string starting with "=" is an expression
+func (b *ActivityBuilder) Input(name string, value interface{}) *ActivityBuilder {
+	if b.config.Input == nil {
+		b.config.Input = make(map[string]interface{})
+	}
+	b.config.Input[name] = value
+	return b
+}
+
+// Output sets an output mapping of the activity
+func (b *ActivityBuilder) Output(name string, value interface{}) *ActivityBuilder {
+	if b.config.Output == nil {
+		b.config.Output = make(map[string]interface{})
+	}
+	b.config.Output[name] = value
+	return b
+}
+
+// graphBuilder builds the tasks and links of a flow or an error handler, it keeps the first
+// error so the builders can be chained
+type graphBuilder struct {
+	tasks    []*TaskRep
+	links    []*LinkRep
+	taskIDs  map[string]bool
+	lastTask *TaskRep
+	lastLink *LinkRep
+	err      error
+}
+
+func (g *graphBuilder) fail(format string, args ...interface{}) {
+	if g.err == nil {
+		g.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (g *graphBuilder) addTask(id string, act *ActivityBuilder) {
+
+	switch {
+	case id == "":
+		g.fail("task id not set")
+		return
+	case g.taskIDs[id]:
+		g.fail("duplicate task '%s'", id)
+		return
+	case act == nil:
+		g.fail("activity of task '%s' not set", id)
+		return
+	case act.err != nil:
+		g.fail("task '%s': %s", id, act.err.Error())
+		return
+	}
+
+	if g.taskIDs == nil {
+		g.taskIDs = make(map[string]bool)
+	}
+	g.taskIDs[id] = true
+
+	g.lastTask = &TaskRep{ID: id, ActivityCfgRep: act.config}
+	g.tasks = append(g.tasks, g.lastTask)
+}
+
+func (g *graphBuilder) iterate(over interface{}) {
+
+	if g.lastTask == nil {
+		g.fail("iterate set before adding a task")
+		return
+	}
+
+	g.lastTask.Type = taskTypeIterator
+	g.setTaskSetting("iterate", over)
+}
+
+func (g *graphBuilder) setTaskSetting(name string, value interface{}) {
+
+	if g.lastTask == nil {
+		g.fail("setting '%s' set before adding a task", name)
+		return
+	}
+
+	if g.lastTask.Settings == nil {
+		g.lastTask.Settings = make(map[string]interface{})
+	}
+	g.lastTask.Settings[name] = value
+}
+
+func (g *graphBuilder) addLink(from, to string) {
+	g.lastLink = &LinkRep{FromID: from, ToID: to}
+	g.links = append(g.links, g.lastLink)
+}
+
+func (g *graphBuilder) when(expr string) {
+
+	if g.lastLink == nil {
+		g.fail("condition '%s' set before adding a link", expr)
+		return
+	}
+	if expr == "" {
+		g.fail("empty condition on link from '%s' to '%s'", g.lastLink.FromID, g.lastLink.ToID)
+		return
+	}
+
+	g.lastLink.Type = linkTypeExpression
+	g.lastLink.Value = expr
+}
+
+func (g *graphBuilder) validate() error {
+
+	if g.err != nil {
+		return g.err
+	}
+
+	for _, link := range g.links {
+		if !g.taskIDs[link.FromID] {
+			return fmt.Errorf("link from unknown task '%s'", link.FromID)
+		}
+		if !g.taskIDs[link.ToID] {
+			return fmt.Errorf("link to unknown task '%s'", link.ToID)
+		}
+	}
+
+	return nil
+}
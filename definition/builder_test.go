@@ -0,0 +1,90 @@
+package definition
+
+import (
+	"testing"
+
+	"github.com/qingcloudhx/core/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlowBuilder(t *testing.T) {
+
+	def, err := NewFlow("Demo Flow").
+		Input("petInfo", data.TypeString).
+		Output("result", data.TypeString).
+		Sensitive("petInfo").
+		Task("LogStart", Activity("log").Input("message", "Find Pet Flow Started!")).
+		Task("LogPets", Activity("log").Input("message", "=$flow.petInfo")).
+		Task("LogResult", Activity("log").Input("message", "=$flow.petInfo")).
+		Link("LogStart", "LogPets").
+		Link("LogPets", "LogResult").When("$flow.petInfo == 'dog'").
+		ErrorHandler(NewErrorHandler().Task("LogError", Activity("log").Input("message", "=$error.message"))).
+		Build()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "Demo Flow", def.Name())
+	assert.Contains(t, def.Metadata().Input, "petInfo")
+	assert.True(t, def.IsSensitive("petInfo"))
+
+	assert.Len(t, def.Tasks(), 3)
+	assert.Len(t, def.Links(), 2)
+	assert.Equal(t, LtExpression, def.GetTask("LogResult").FromLinks()[0].Type())
+
+	if assert.NotNil(t, def.GetErrorHandler()) {
+		assert.Len(t, def.GetErrorHandler().Tasks(), 1)
+	}
+}
+
+func TestFlowBuilder_Rep(t *testing.T) {
+
+	rep, err := NewFlow("Iterate").
+		Model("flogo-simple").
+		Task("LogPets", Activity("log").Setting("level", "INFO").Input("message", "=$iteration[value]")).
+		Iterate("=$flow.pets").
+		Rep()
+	assert.Nil(t, err)
+
+	if assert.Len(t, rep.Tasks, 1) {
+		assert.Equal(t, "iterator", rep.Tasks[0].Type)
+		assert.Equal(t, "=$flow.pets", rep.Tasks[0].Settings["iterate"])
+		assert.Equal(t, "log", rep.Tasks[0].ActivityCfgRep.Ref)
+		assert.Equal(t, "INFO", rep.Tasks[0].ActivityCfgRep.Settings["level"])
+	}
+}
+
+func TestFlowBuilder_Invalid(t *testing.T) {
+
+	_, err := NewFlow("").Build()
+	assert.NotNil(t, err)
+
+	_, err = NewFlow("flow").
+		Task("log", Activity("log")).
+		Task("log", Activity("log")).
+		Build()
+	assert.NotNil(t, err)
+
+	_, err = NewFlow("flow").
+		Task("log", Activity("log")).
+		Link("log", "missing").
+		Build()
+	assert.NotNil(t, err)
+
+	_, err = NewFlow("flow").
+		Task("log", Activity("log")).
+		When("$flow.in == 1").
+		Build()
+	assert.NotNil(t, err)
+
+	_, err = NewFlow("flow").
+		Task("unknown", Activity("github.com/unknown/activity")).
+		Build()
+	assert.NotNil(t, err)
+
+	// LogActivity is only registered by its legacy alias
+	_, err = NewFlow("flow").
+		Task("log", ActivityFor(&LogActivity{})).
+		Build()
+	assert.NotNil(t, err)
+}
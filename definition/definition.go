@@ -2,6 +2,7 @@ package definition
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/qingcloudhx/core/activity"
 	"github.com/qingcloudhx/core/data"
@@ -29,6 +30,14 @@ type Definition struct {
 	metadata *metadata.IOMetadata
 
 	errorHandler *ErrorHandler
+
+	sensitive map[string]bool
+
+	// refs counts the users of the definition, see Acquire and Dispose
+	refMu     sync.Mutex
+	refs      int
+	disposed  bool
+	cleanedUp bool
 }
 
 // Name returns the name of the definition
@@ -197,6 +206,13 @@ type Task struct {
 
 	toLinks   []*Link
 	fromLinks []*Link
+
+	sensitive map[string]bool
+}
+
+// Definition returns the flow Definition that contains the task
+func (task *Task) Definition() *Definition {
+	return task.definition
 }
 
 // ID gets the id of the task
@@ -315,6 +331,15 @@ type ErrorHandler struct {
 	tasks map[string]*Task
 }
 
+func (eh *ErrorHandler) Links() []*Link {
+
+	links := make([]*Link, 0, len(eh.links))
+	for _, link := range eh.links {
+		links = append(links, link)
+	}
+	return links
+}
+
 func (eh *ErrorHandler) Tasks() []*Task {
 
 	tasks := make([]*Task, 0, len(eh.tasks))
@@ -25,6 +25,11 @@ type DefinitionRep struct {
 	Tasks         []*TaskRep           `json:"tasks"`
 	Links         []*LinkRep           `json:"links,omitempty"`
 	ErrorHandler  *ErrorHandlerRep     `json:"errorHandler,omitempty"`
+
+	// Sensitive are the names of the flow inputs and outputs whose values are masked in events,
+	// logs and the responses of the tester, snapshots and recorded changes keep the clear values
+	// so that the instances can be restored and replayed
+	Sensitive []string `json:"sensitive,omitempty"`
 }
 
 // ErrorHandlerRep is a serializable representation of the error flow
@@ -40,6 +45,9 @@ type TaskRep struct {
 	Name           string                 `json:"name,omitempty"`
 	Settings       map[string]interface{} `json:"settings,omitempty"`
 	ActivityCfgRep *activity.Config       `json:"activity"`
+
+	// Sensitive are the names of the activity inputs and outputs whose values are masked
+	Sensitive []string `json:"sensitive,omitempty"`
 }
 
 // LinkRep is a serializable representation of a flow LinkOld
@@ -64,6 +72,7 @@ func NewDefinition(rep *DefinitionRep) (def *Definition, err error) {
 	def.modelID = rep.ModelID
 	def.metadata = rep.Metadata
 	def.explicitReply = rep.ExplicitReply
+	def.sensitive = toSet(rep.Sensitive)
 	def.tasks = make(map[string]*Task)
 	def.links = make(map[int]*Link)
 
@@ -137,6 +146,7 @@ func createTask(def *Definition, rep *TaskRep, ef expression.Factory) (*Task, er
 	task.id = rep.ID
 	task.name = rep.Name
 	task.definition = def
+	task.sensitive = toSet(rep.Sensitive)
 
 	if rep.Type != "" {
 		if !flowutil.IsValidTaskType(def.modelID, rep.Type) {
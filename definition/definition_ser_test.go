@@ -146,3 +146,32 @@ func (a *LogActivity) Eval(context activity.Context) (done bool, err error) {
 	fmt.Println("Message :", message)
 	return true, nil
 }
+
+func TestDeserializeSensitive(t *testing.T) {
+
+	defRep := &DefinitionRep{}
+	err := json.Unmarshal([]byte(`{
+	  "name": "login",
+	  "metadata": { "input": [ { "name": "user", "type": "string" }, { "name": "password", "type": "string" } ] },
+	  "sensitive": [ "password" ],
+	  "tasks": [
+	    { "id": "auth", "sensitive": [ "token" ], "activity": { "ref": "log", "input": { "message": "auth" } } },
+	    { "id": "audit", "activity": { "ref": "log", "input": { "message": "audit" } } }
+	  ]
+	}`), defRep)
+	assert.Nil(t, err)
+
+	def, err := NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	assert.True(t, def.IsSensitive("password"))
+	assert.False(t, def.IsSensitive("user"))
+	assert.Equal(t, map[string]interface{}{"user": "bob", "password": MaskedValue}, def.MaskValues(map[string]interface{}{"user": "bob", "password": "secret"}))
+
+	assert.True(t, def.GetTask("auth").IsSensitive("token"))
+	assert.False(t, def.GetTask("audit").IsSensitive("token"))
+
+	RegisterSensitiveFields(def.GetTask("audit").ActivityConfig().Ref(), "apiKey")
+	assert.True(t, def.GetTask("audit").IsSensitive("apiKey"))
+	assert.Equal(t, MaskedValue, def.GetTask("auth").Mask("apiKey", "key"))
+}
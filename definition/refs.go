@@ -0,0 +1,67 @@
+package definition
+
+import (
+	"sync"
+
+	"github.com/qingcloudhx/core/support/log"
+)
+
+// Acquire marks the definition as used, ex. by a running instance, so that its activities aren't
+// cleaned up when it is disposed.  The returned function releases it, it can be called more
+// than once.
+func (d *Definition) Acquire() (release func()) {
+
+	d.refMu.Lock()
+	d.refs++
+	d.refMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(d.release)
+	}
+}
+
+// Dispose cleans up the activities of the definition once it isn't used anymore, ex. when it is
+// evicted from a cache.  If it is used, the cleanup is done when the last user releases it.
+func (d *Definition) Dispose() {
+
+	d.refMu.Lock()
+	d.disposed = true
+	cleanup := d.refs == 0 && !d.cleanedUp
+	if cleanup {
+		d.cleanedUp = true
+	}
+	d.refMu.Unlock()
+
+	if cleanup {
+		d.cleanupActivities()
+	}
+}
+
+// InUse indicates if the definition is used
+func (d *Definition) InUse() bool {
+	d.refMu.Lock()
+	defer d.refMu.Unlock()
+	return d.refs > 0
+}
+
+func (d *Definition) release() {
+
+	d.refMu.Lock()
+	d.refs--
+	cleanup := d.refs == 0 && d.disposed && !d.cleanedUp
+	if cleanup {
+		d.cleanedUp = true
+	}
+	d.refMu.Unlock()
+
+	if cleanup {
+		d.cleanupActivities()
+	}
+}
+
+func (d *Definition) cleanupActivities() {
+	if err := d.Cleanup(); err != nil {
+		log.RootLogger().Warnf("Error cleaning up flow '%s': %v", d.Name(), err)
+	}
+}
@@ -0,0 +1,172 @@
+package definition
+
+import (
+	"strings"
+	"sync"
+)
+
+// MaskedValue replaces the values of the sensitive attributes and fields in events, logs and
+// the responses of the tester
+const MaskedValue = "********"
+
+var (
+	sensitiveFieldsMu sync.RWMutex
+	sensitiveFields   = make(map[string]map[string]bool)
+)
+
+// RegisterSensitiveFields marks inputs and outputs of the activity as sensitive in all the
+// tasks that use it
+func RegisterSensitiveFields(activityRef string, fields ...string) {
+
+	sensitiveFieldsMu.Lock()
+	defer sensitiveFieldsMu.Unlock()
+
+	set, ok := sensitiveFields[activityRef]
+	if !ok {
+		set = make(map[string]bool, len(fields))
+		sensitiveFields[activityRef] = set
+	}
+
+	for _, field := range fields {
+		set[field] = true
+	}
+}
+
+func isSensitiveField(activityRef, field string) bool {
+
+	sensitiveFieldsMu.RLock()
+	defer sensitiveFieldsMu.RUnlock()
+
+	return sensitiveFields[activityRef][field]
+}
+
+// activityAttrPrefix is the prefix of the attributes holding the outputs of the activities,
+// ex. "_A.<task>.<output>"
+const activityAttrPrefix = "_A."
+
+// IsSensitive indicates if the value of the flow input or output is sensitive, an activity
+// output attribute ("_A.<task>.<output>") is sensitive if the output of its task is
+func (d *Definition) IsSensitive(name string) bool {
+
+	if d == nil {
+		return false
+	}
+
+	if d.sensitive[name] {
+		return true
+	}
+
+	if strings.HasPrefix(name, activityAttrPrefix) {
+		parts := strings.SplitN(name[len(activityAttrPrefix):], ".", 2)
+		if len(parts) == 2 {
+			return d.findTask(parts[0]).IsSensitive(parts[1])
+		}
+	}
+
+	return false
+}
+
+// HasSensitive indicates if some flow inputs or outputs are sensitive
+func (d *Definition) HasSensitive() bool {
+	return d != nil && len(d.sensitive) > 0
+}
+
+// Mask returns MaskedValue if the flow input or output is sensitive, the value otherwise
+func (d *Definition) Mask(name string, value interface{}) interface{} {
+	if d.IsSensitive(name) {
+		return MaskedValue
+	}
+	return value
+}
+
+// MaskValues returns a copy of the values where the sensitive flow inputs and outputs are
+// masked, the values are returned as is if none is sensitive
+func (d *Definition) MaskValues(values map[string]interface{}) map[string]interface{} {
+
+	for name := range values {
+		if d.IsSensitive(name) {
+			return maskValues(values, d.IsSensitive)
+		}
+	}
+
+	return values
+}
+
+// findTask finds the task of the flow or of its error handler
+func (d *Definition) findTask(taskID string) *Task {
+
+	if task, ok := d.tasks[taskID]; ok {
+		return task
+	}
+
+	if d.errorHandler != nil {
+		return d.errorHandler.tasks[taskID]
+	}
+
+	return nil
+}
+
+// IsSensitive indicates if the value of the activity input or output is sensitive, either
+// because the task marks it or because it was registered for the activity
+func (task *Task) IsSensitive(field string) bool {
+
+	if task == nil {
+		return false
+	}
+
+	if task.sensitive[field] {
+		return true
+	}
+
+	if task.activityCfg != nil && task.activityCfg.Activity != nil {
+		return isSensitiveField(task.activityCfg.Ref(), field)
+	}
+
+	return false
+}
+
+// Mask returns MaskedValue if the activity input or output is sensitive, the value otherwise
+func (task *Task) Mask(field string, value interface{}) interface{} {
+	if task.IsSensitive(field) {
+		return MaskedValue
+	}
+	return value
+}
+
+// MaskValues returns a copy of the values where the sensitive activity inputs and outputs
+// are masked
+func (task *Task) MaskValues(values map[string]interface{}) map[string]interface{} {
+	return maskValues(values, task.IsSensitive)
+}
+
+func maskValues(values map[string]interface{}, isSensitive func(name string) bool) map[string]interface{} {
+
+	if values == nil {
+		return nil
+	}
+
+	masked := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		if isSensitive(name) {
+			masked[name] = MaskedValue
+		} else {
+			masked[name] = value
+		}
+	}
+
+	return masked
+}
+
+func toSet(values []string) map[string]bool {
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+
+	return set
+}
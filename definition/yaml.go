@@ -0,0 +1,198 @@
+package definition
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlFileExts are the extensions of the YAML flow files
+var yamlFileExts = []string{".yaml", ".yml"}
+
+// yamlContentTypes are the media types of the YAML flows
+var yamlContentTypes = []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}
+
+// IsYAMLFile returns true if the name, a path or URI, has a YAML extension, ignoring a
+// trailing .gz
+func IsYAMLFile(name string) bool {
+
+	name = strings.TrimSuffix(strings.ToLower(name), ".gz")
+	for _, ext := range yamlFileExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsYAMLContentType returns true if the content type is a YAML media type
+func IsYAMLContentType(contentType string) bool {
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	for _, yamlType := range yamlContentTypes {
+		if mediaType == yamlType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DecodeYAML decodes a YAML flow definition, it maps onto the DefinitionRep like its JSON
+// equivalent.  Multi-line strings, ex. long expressions, can use block scalars:
+//
+//	input:
+//	  message: |-
+//	    =string.concat("Order ", $flow.orderId,
+//	      " processed")
+func DecodeYAML(data []byte) (*DefinitionRep, error) {
+
+	jsonBytes, err := YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var defRep *DefinitionRep
+	if err := json.Unmarshal(jsonBytes, &defRep); err != nil {
+		return nil, err
+	}
+
+	return defRep, nil
+}
+
+// YAMLToJSON converts a YAML document to JSON, keeping the order of the keys
+func YAMLToJSON(data []byte) ([]byte, error) {
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, doc); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// JSONToYAML converts a JSON document to YAML, keeping the order of the keys, multi-line
+// strings are written as literal block scalars.  It round-trips with YAMLToJSON.
+func JSONToYAML(data []byte) ([]byte, error) {
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	doc, err := readJSON(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(doc)
+}
+
+func writeJSON(buf *bytes.Buffer, value interface{}) error {
+
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		buf.WriteByte('{')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, ok := item.Key.(string)
+			if !ok {
+				key = fmt.Sprint(item.Key)
+			}
+			keyBytes, _ := json.Marshal(key)
+			buf.Write(keyBytes)
+			buf.WriteByte(':')
+			if err := writeJSON(buf, item.Value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Errorf("unsupported number %v", v)
+		}
+		valBytes, _ := json.Marshal(v)
+		buf.Write(valBytes)
+	default:
+		valBytes, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(valBytes)
+	}
+
+	return nil
+}
+
+func readJSON(decoder *json.Decoder) (interface{}, error) {
+
+	token, err := decoder.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			obj := yaml.MapSlice{}
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := readJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				obj = append(obj, yaml.MapItem{Key: keyToken.(string), Value: value})
+			}
+			_, err = decoder.Token()
+			return obj, err
+		case '[':
+			arr := []interface{}{}
+			for decoder.More() {
+				value, err := readJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, value)
+			}
+			_, err = decoder.Token()
+			return arr, err
+		}
+		return nil, fmt.Errorf("unexpected delimiter '%s'", t)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	default:
+		return t, nil
+	}
+}
@@ -0,0 +1,88 @@
+package definition
+
+import (
+	"encoding/json"
+	"testing"
+
+	_ "github.com/qingcloudhx/core/data/expression/script"
+	"github.com/stretchr/testify/assert"
+)
+
+const defYAML = `
+name: Demo Flow
+metadata:
+  input:
+    - name: petInfo
+      type: string
+      value: blahPet
+tasks:
+  - id: LogStart
+    activity:
+      ref: log
+      input:
+        message: |-
+          =$flow.petInfo ==
+            "blahPet"
+  - id: LogResult
+    name: Log Results
+    activity:
+      ref: log
+      input:
+        message: Find Pet Flow Completed!
+links:
+  - from: LogStart
+    to: LogResult
+`
+
+func TestDecodeYAML(t *testing.T) {
+
+	defRep, err := DecodeYAML([]byte(defYAML))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "Demo Flow", defRep.Name)
+	assert.Contains(t, defRep.Metadata.Input, "petInfo")
+	if assert.Len(t, defRep.Tasks, 2) {
+		assert.Equal(t, "=$flow.petInfo ==\n  \"blahPet\"", defRep.Tasks[0].ActivityCfgRep.Input["message"])
+	}
+	if assert.Len(t, defRep.Links, 1) {
+		assert.Equal(t, "LogResult", defRep.Links[0].ToID)
+	}
+
+	_, err = NewDefinition(defRep)
+	assert.Nil(t, err)
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+
+	yamlBytes, err := JSONToYAML([]byte(defJSON))
+	assert.Nil(t, err)
+
+	jsonBytes, err := YAMLToJSON(yamlBytes)
+	assert.Nil(t, err)
+	assert.JSONEq(t, defJSON, string(jsonBytes))
+
+	// multi-line strings use block scalars
+	jsonBytes, err = YAMLToJSON([]byte(defYAML))
+	assert.Nil(t, err)
+
+	yamlBytes, err = JSONToYAML(jsonBytes)
+	assert.Nil(t, err)
+	assert.Contains(t, string(yamlBytes), "message: |-\n")
+
+	roundTripped, err := YAMLToJSON(yamlBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, string(jsonBytes), string(roundTripped))
+
+	var defRep *DefinitionRep
+	assert.Nil(t, json.Unmarshal(roundTripped, &defRep))
+	assert.Equal(t, "Demo Flow", defRep.Name)
+}
+
+func TestIsYAML(t *testing.T) {
+	assert.True(t, IsYAMLFile("file:///flows/orders.yaml"))
+	assert.True(t, IsYAMLFile("flows/orders.YML.gz"))
+	assert.False(t, IsYAMLFile("flows/orders.json"))
+
+	assert.True(t, IsYAMLContentType("application/x-yaml; charset=utf-8"))
+	assert.False(t, IsYAMLContentType("application/json"))
+}
@@ -32,12 +32,17 @@ func NewDefaultExtensionProvider() *DefaultExtensionProvider {
 func (fp *DefaultExtensionProvider) GetFlowProvider() definition.Provider {
 
 	if fp.flowProvider == nil {
-		fp.flowProvider = &support.BasicRemoteFlowProvider{}
+		fp.flowProvider = support.NewBasicRemoteFlowProvider()
 	}
 
 	return fp.flowProvider
 }
 
+// SetFlowProvider sets the provider of the flow definitions
+func (fp *DefaultExtensionProvider) SetFlowProvider(provider definition.Provider) {
+	fp.flowProvider = provider
+}
+
 func (fp *DefaultExtensionProvider) GetDefaultFlowModel() *model.FlowModel {
 
 	if fp.flowModel == nil {
@@ -0,0 +1,24 @@
+//go:build go1.16
+// +build go1.16
+
+package flow
+
+import (
+	"io/fs"
+
+	"github.com/qingcloudhx/flow/support"
+)
+
+// RegisterFS makes the flows of the FS, ex. an embed.FS, available using "embed://" URIs.  The
+// FS registered last is searched first, the URIs it does not resolve are passed to the
+// previously registered FS and finally to the flow provider that was set.
+//
+//	//go:embed flows
+//	var flows embed.FS
+//
+//	ep := flow.NewDefaultExtensionProvider()
+//	ep.RegisterFS(flows)
+//	flow.SetExtensionProvider(ep)
+func (fp *DefaultExtensionProvider) RegisterFS(fsys fs.FS) {
+	fp.flowProvider = support.NewFSFlowProvider(fsys, fp.GetFlowProvider())
+}
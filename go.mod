@@ -6,4 +6,5 @@ require (
 	github.com/julienschmidt/httprouter v1.2.0
 	github.com/qingcloudhx/core v0.9.3-0.20190625065757-9a4c5da90847
 	github.com/stretchr/testify v1.3.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,226 @@
+package instance
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/qingcloudhx/core/support"
+	"github.com/qingcloudhx/flow/model"
+)
+
+// CheckpointPolicy determines which steps of an instance are recorded with a full snapshot,
+// the changes of every step are always recorded.  When neither the number of steps nor the
+// interval is set a snapshot is recorded for every step.
+type CheckpointPolicy struct {
+	// Steps is the number of steps between snapshots
+	Steps int `json:"steps,omitempty"`
+	// Interval is the maximum time between snapshots
+	Interval time.Duration `json:"interval,omitempty"`
+}
+
+// Checkpointer records the steps of an instance according to a CheckpointPolicy
+type Checkpointer struct {
+	policy *CheckpointPolicy
+
+	snapshotStep int
+	snapshotTime time.Time
+	snapshotted  bool
+}
+
+// NewCheckpointer creates a Checkpointer for an instance, a nil policy records a
+// snapshot for every step
+func NewCheckpointer(policy *CheckpointPolicy) *Checkpointer {
+
+	if policy == nil {
+		policy = &CheckpointPolicy{}
+	}
+
+	return &Checkpointer{policy: policy}
+}
+
+// Record records the current step of the instance, preceded by a snapshot of the instance
+// if one is due
+func (c *Checkpointer) Record(recorder StateRecorder, inst *IndependentInstance) {
+
+	if c.snapshotDue(inst) {
+		recorder.RecordSnapshot(inst)
+
+		c.snapshotted = true
+		c.snapshotStep = inst.StepID()
+		c.snapshotTime = time.Now()
+	}
+
+	recorder.RecordStep(inst)
+}
+
+// snapshotDue indicates if a snapshot of the current step of the instance should be recorded,
+// the first step and the step that ends the instance are always recorded with a snapshot
+func (c *Checkpointer) snapshotDue(inst *IndependentInstance) bool {
+
+	if !c.snapshotted || inst.Status() >= model.FlowStatusCompleted {
+		return true
+	}
+
+	if c.policy.Steps <= 0 && c.policy.Interval <= 0 {
+		return true
+	}
+
+	if c.policy.Steps > 0 && inst.StepID()-c.snapshotStep >= c.policy.Steps {
+		return true
+	}
+
+	return c.policy.Interval > 0 && time.Since(c.snapshotTime) >= c.policy.Interval
+}
+
+// Reconstruct rebuilds the state of an instance by applying the changes of the steps that
+// followed its snapshot, in order.  As with a decoded snapshot, the task and link instances
+// only contain their ID and status and the instance has to be restarted to bind it to its
+// flow definition.
+func Reconstruct(snapshot *IndependentInstance, steps ...*InstanceChangeTracker) (*IndependentInstance, error) {
+
+	for i, changes := range steps {
+		if err := snapshot.applyChanges(changes); err != nil {
+			return nil, fmt.Errorf("unable to apply the changes of step %d: %v", i+1, err)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// applyChanges applies the recorded changes of a step to the state of the instance
+func (inst *IndependentInstance) applyChanges(changes *InstanceChangeTracker) error {
+
+	flowIDs := make([]int, 0, len(changes.instChanges))
+	for flowID := range changes.instChanges {
+		flowIDs = append(flowIDs, flowID)
+	}
+	sort.Ints(flowIDs)
+
+	// the sub-flows are created first, their own changes can be part of the same step
+	for _, flowID := range flowIDs {
+		sfChange := changes.instChanges[flowID].SubFlowChg
+		if sfChange == nil || sfChange.ChgType != CtAdd {
+			continue
+		}
+
+		if inst.subFlows == nil {
+			inst.subFlows = make(map[int]*Instance)
+		}
+
+		inst.subFlows[sfChange.SubFlowID] = &Instance{subFlowId: sfChange.SubFlowID, master: inst, flowURI: sfChange.FlowURI,
+			status: model.FlowStatusNotStarted, taskInsts: make(map[string]*TaskInst), linkInsts: make(map[int]*LinkInst)}
+
+		if sfChange.SubFlowID > inst.subFlowCtr {
+			inst.subFlowCtr = sfChange.SubFlowID
+		}
+	}
+
+	for _, flowID := range flowIDs {
+		flowInst := inst.Instance
+		if flowID > 0 {
+			flowInst = inst.subFlows[flowID]
+			if flowInst == nil {
+				return fmt.Errorf("unknown sub-flow %d", flowID)
+			}
+		}
+
+		flowInst.applyChanges(changes.instChanges[flowID])
+	}
+
+	wiIDs := make([]int, 0, len(changes.wiqChanges))
+	for id := range changes.wiqChanges {
+		wiIDs = append(wiIDs, id)
+	}
+	sort.Ints(wiIDs)
+
+	for _, id := range wiIDs {
+		wiChange := changes.wiqChanges[id]
+
+		switch wiChange.ChgType {
+		case CtDel:
+			removeWorkItem(inst.workItemQueue, id)
+		case CtAdd:
+			workItem := wiChange.WorkItem
+			if workItem == nil {
+				return fmt.Errorf("work item %d added without its content", id)
+			}
+
+			taskInsts := inst.taskInsts
+			if workItem.SubFlowID > 0 {
+				subFlow := inst.subFlows[workItem.SubFlowID]
+				if subFlow == nil {
+					return fmt.Errorf("work item %d references unknown sub-flow %d", id, workItem.SubFlowID)
+				}
+				taskInsts = subFlow.taskInsts
+			}
+
+			workItem.taskInst = taskInsts[workItem.TaskID]
+			inst.workItemQueue.Push(workItem)
+
+			if id > inst.wiCounter {
+				inst.wiCounter = id
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyChanges applies the recorded changes of a step to the state of the flow
+func (inst *Instance) applyChanges(ic *InstanceChange) {
+
+	if ic.Status != model.FlowStatusNotStarted {
+		inst.status = ic.Status
+	}
+
+	if len(ic.AttrChanges) > 0 && inst.attrs == nil {
+		inst.attrs = make(map[string]interface{})
+	}
+
+	for _, attrChange := range ic.AttrChanges {
+		if attrChange.ChgType == CtDel {
+			delete(inst.attrs, attrChange.Attribute.Name())
+		} else {
+			inst.attrs[attrChange.Attribute.Name()] = attrChange.Attribute.Value()
+		}
+	}
+
+	for id, tiChange := range ic.tiChanges {
+		if tiChange.ChgType == CtDel || tiChange.TaskInst == nil {
+			delete(inst.taskInsts, id)
+			continue
+		}
+
+		if taskInst, exists := inst.taskInsts[id]; exists {
+			taskInst.status = tiChange.TaskInst.status
+		} else {
+			inst.taskInsts[id] = &TaskInst{taskID: id, status: tiChange.TaskInst.status}
+		}
+	}
+
+	for id, liChange := range ic.liChanges {
+		if liChange.ChgType == CtDel || liChange.LinkInst == nil {
+			delete(inst.linkInsts, id)
+			continue
+		}
+
+		if linkInst, exists := inst.linkInsts[id]; exists {
+			linkInst.status = liChange.LinkInst.status
+		} else {
+			inst.linkInsts[id] = &LinkInst{linkID: id, status: liChange.LinkInst.status}
+		}
+	}
+}
+
+func removeWorkItem(queue *support.SyncQueue, id int) {
+
+	var next *list.Element
+	for e := queue.List.Front(); e != nil; e = next {
+		next = e.Next()
+		if workItem, ok := e.Value.(*WorkItem); ok && workItem.ID == id {
+			queue.List.Remove(e)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package instance
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/model"
+	"github.com/qingcloudhx/flow/support"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingRecorder counts the recorded snapshots and steps
+type countingRecorder struct {
+	snapshots []int
+	steps     []int
+}
+
+func (r *countingRecorder) RecordSnapshot(instance *IndependentInstance) {
+	r.snapshots = append(r.snapshots, instance.StepID())
+}
+
+func (r *countingRecorder) RecordStep(instance *IndependentInstance) {
+	r.steps = append(r.steps, instance.StepID())
+}
+
+func newCheckpointTestInstance(t *testing.T) *IndependentInstance {
+
+	manager := support.NewFlowManager(&restartTestProvider{})
+	def, err := manager.GetFlow("res://flow:restart")
+	assert.Nil(t, err)
+
+	inst, err := NewIndependentInstance("checkpoint", "res://flow:restart", def, log.RootLogger())
+	assert.Nil(t, err)
+
+	return inst
+}
+
+func TestCheckpointer_Record(t *testing.T) {
+
+	inst := newCheckpointTestInstance(t)
+	inst.Start(nil)
+
+	recorder := &countingRecorder{}
+	checkpointer := NewCheckpointer(&CheckpointPolicy{Steps: 2})
+
+	hasWork := true
+	for hasWork && inst.Status() < model.FlowStatusCompleted {
+		hasWork = inst.DoStep()
+		checkpointer.Record(recorder, inst)
+	}
+
+	// the first step, every second step and the last step
+	assert.Equal(t, []int{1, 3}, recorder.snapshots)
+	assert.Equal(t, []int{1, 2, 3}, recorder.steps)
+
+	recorder = &countingRecorder{}
+	checkpointer = NewCheckpointer(nil)
+
+	inst = newCheckpointTestInstance(t)
+	inst.Start(nil)
+	runToCompletion(inst)
+
+	checkpointer.Record(recorder, inst)
+	checkpointer.Record(recorder, inst)
+	assert.Len(t, recorder.snapshots, 2)
+}
+
+func TestReconstruct(t *testing.T) {
+
+	for _, name := range []string{CodecJSON, CodecBinary} {
+
+		codec, err := NewCodec(name, "")
+		assert.Nil(t, err)
+
+		inst := newCheckpointTestInstance(t)
+		inst.Start(nil)
+		inst.DoStep()
+
+		snapshot, err := codec.EncodeSnapshot(inst)
+		assert.Nil(t, err)
+
+		var steps [][]byte
+
+		hasWork := true
+		for hasWork && inst.Status() < model.FlowStatusCompleted {
+			hasWork = inst.DoStep()
+
+			step, err := codec.EncodeStep(inst.ChangeTracker)
+			assert.Nil(t, err)
+			steps = append(steps, step)
+		}
+		assert.Equal(t, model.FlowStatusCompleted, inst.Status())
+
+		decoded, err := codec.DecodeSnapshot(snapshot)
+		assert.Nil(t, err)
+
+		var changes []*InstanceChangeTracker
+		for _, step := range steps {
+			stepChanges, err := codec.DecodeStep(step)
+			assert.Nil(t, err)
+			changes = append(changes, stepChanges)
+		}
+
+		reconstructed, err := Reconstruct(decoded, changes...)
+		assert.Nil(t, err)
+
+		assert.Equal(t, inst.Status(), reconstructed.Status())
+		assert.Equal(t, "second", reconstructed.attrs["out"])
+		assert.Equal(t, inst.workItemQueue.List.Len(), reconstructed.workItemQueue.List.Len())
+
+		expected, err := json.Marshal(inst)
+		assert.Nil(t, err)
+		actual, err := json.Marshal(reconstructed)
+		assert.Nil(t, err)
+		assert.Equal(t, sortedJSON(t, expected), sortedJSON(t, actual))
+	}
+}
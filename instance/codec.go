@@ -0,0 +1,744 @@
+package instance
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/qingcloudhx/core/data"
+	"github.com/qingcloudhx/flow/model"
+)
+
+const (
+	// CodecJSON is the name of the JSON codec, the interchange format of snapshots and steps
+	CodecJSON = "json"
+	// CodecBinary is the name of the compact binary codec
+	CodecBinary = "binary"
+
+	// CompressionGzip compresses the binary encoding using gzip
+	CompressionGzip = "gzip"
+)
+
+// Codec encodes the snapshots and the step changes of instances recorded by a StateRecorder
+type Codec interface {
+	// Name returns the name of the codec
+	Name() string
+	// ContentType returns the content type of the encoded data
+	ContentType() string
+
+	// EncodeSnapshot encodes the complete state of the instance
+	EncodeSnapshot(inst *IndependentInstance) ([]byte, error)
+	// DecodeSnapshot decodes the state of an instance, the instance has to be restarted
+	// to bind it to its flow definition, an UnsupportedStateVersionError is returned if
+	// the snapshot can't be migrated to the current StateVersion
+	DecodeSnapshot(b []byte) (*IndependentInstance, error)
+
+	// EncodeStep encodes the changes of a step of an instance
+	EncodeStep(changes *InstanceChangeTracker) ([]byte, error)
+	// DecodeStep decodes the changes of a step, the task and link instances of the changes
+	// only contain their ID and status
+	DecodeStep(b []byte) (*InstanceChangeTracker, error)
+	// StepToJSON converts the encoded changes of a step to their JSON representation
+	StepToJSON(b []byte) ([]byte, error)
+}
+
+// NewCodec creates the codec with the specified name, compression only applies to
+// the binary codec.  The state migrations only apply to JSON snapshots, a binary snapshot
+// can only be decoded by a version of the library with the same StateVersion, so the JSON
+// codec should be used for snapshots that have to be restored after an upgrade
+func NewCodec(name, compression string) (Codec, error) {
+
+	switch name {
+	case "", CodecJSON:
+		return &jsonCodec{}, nil
+	case CodecBinary:
+		switch compression {
+		case "":
+			return &binaryCodec{}, nil
+		case CompressionGzip:
+			return &binaryCodec{compress: true}, nil
+		}
+		return nil, fmt.Errorf("unsupported compression '%s'", compression)
+	}
+
+	return nil, fmt.Errorf("unsupported codec '%s'", name)
+}
+
+// jsonCodec encodes snapshots and steps using their JSON representation
+type jsonCodec struct {
+}
+
+func (*jsonCodec) Name() string {
+	return CodecJSON
+}
+
+func (*jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (*jsonCodec) EncodeSnapshot(inst *IndependentInstance) ([]byte, error) {
+	return json.Marshal(inst)
+}
+
+func (*jsonCodec) DecodeSnapshot(b []byte) (*IndependentInstance, error) {
+	inst := &IndependentInstance{}
+	err := json.Unmarshal(b, inst)
+	if err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+func (*jsonCodec) EncodeStep(changes *InstanceChangeTracker) ([]byte, error) {
+	return json.Marshal(changes)
+}
+
+func (*jsonCodec) DecodeStep(b []byte) (*InstanceChangeTracker, error) {
+	changes := NewInstanceChangeTracker()
+	err := json.Unmarshal(b, changes)
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (*jsonCodec) StepToJSON(b []byte) ([]byte, error) {
+	return b, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Binary Codec
+//
+// The binary encoding starts with a header: the magic bytes 'F' 'I', the version of the encoding,
+// the kind of content (snapshot or step) and flags (compression).  The content follows, integers
+// are encoded as varints, strings are prefixed by their length and attribute values are tagged
+// with their type, values of other types are encoded using their JSON representation.
+
+const (
+	binaryVersion = 1
+
+	binaryKindSnapshot = 1
+	binaryKindStep     = 2
+
+	binaryFlagGzip = 1
+)
+
+const (
+	tagNil byte = iota
+	tagFalse
+	tagTrue
+	tagInt
+	tagInt64
+	tagFloat
+	tagString
+	tagBytes
+	tagArray
+	tagObject
+	tagJSON
+)
+
+var errInvalidEncoding = errors.New("invalid binary encoding")
+
+// binaryCodec encodes snapshots and steps using a compact binary encoding
+type binaryCodec struct {
+	compress bool
+}
+
+func (c *binaryCodec) Name() string {
+	return CodecBinary
+}
+
+func (c *binaryCodec) ContentType() string {
+	return "application/vnd.flogo.instance+binary"
+}
+
+func (c *binaryCodec) EncodeSnapshot(inst *IndependentInstance) ([]byte, error) {
+
+	ser := inst.toSer()
+
+	w := &binaryWriter{}
+	w.putUint(ser.Version)
+	w.putString(ser.ID)
+	w.putInt(int64(ser.Status))
+	w.putString(ser.FlowURI)
+	w.putAttrs(ser.Attrs)
+
+	w.putUint(len(ser.WorkQueue))
+	for _, workItem := range ser.WorkQueue {
+		w.putWorkItem(workItem)
+	}
+
+	w.putTaskInsts(ser.TaskInsts)
+	w.putLinkInsts(ser.LinkInsts)
+
+	w.putUint(len(ser.SubFlows))
+	for _, subFlow := range ser.SubFlows {
+		sub := subFlow.toSer()
+		w.putInt(int64(sub.SubFlowId))
+		w.putInt(int64(sub.Status))
+		w.putString(sub.FlowURI)
+		w.putAttrs(sub.Attrs)
+		w.putTaskInsts(sub.TaskInsts)
+		w.putLinkInsts(sub.LinkInsts)
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	return c.wrap(binaryKindSnapshot, w.buf.Bytes())
+}
+
+func (c *binaryCodec) DecodeSnapshot(b []byte) (*IndependentInstance, error) {
+
+	content, err := c.unwrap(binaryKindSnapshot, b)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &binaryReader{buf: bytes.NewReader(content)}
+
+	// the state migrations operate on the JSON representation, there are no migrations for
+	// binary snapshots so only the current version can be decoded
+	ser := &serIndependentInstance{}
+	ser.Version = int(r.getUint())
+	if r.err == nil && ser.Version != StateVersion {
+		return nil, &UnsupportedStateVersionError{Version: ser.Version}
+	}
+
+	ser.ID = r.getString()
+	ser.Status = model.FlowStatus(r.getInt())
+	ser.FlowURI = r.getString()
+	ser.Attrs = r.getAttrs()
+
+	n := r.getLen()
+	for i := 0; i < n; i++ {
+		ser.WorkQueue = append(ser.WorkQueue, r.getWorkItem())
+	}
+
+	ser.TaskInsts = r.getTaskInsts()
+	ser.LinkInsts = r.getLinkInsts()
+
+	n = r.getLen()
+	for i := 0; i < n; i++ {
+		sub := &serInstance{}
+		sub.SubFlowId = int(r.getInt())
+		sub.Status = model.FlowStatus(r.getInt())
+		sub.FlowURI = r.getString()
+		sub.Attrs = r.getAttrs()
+		sub.TaskInsts = r.getTaskInsts()
+		sub.LinkInsts = r.getLinkInsts()
+
+		subFlow := &Instance{}
+		subFlow.fromSer(sub)
+		ser.SubFlows = append(ser.SubFlows, subFlow)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	inst := &IndependentInstance{}
+	inst.fromSer(ser)
+
+	return inst, nil
+}
+
+func (c *binaryCodec) EncodeStep(changes *InstanceChangeTracker) ([]byte, error) {
+
+	w := &binaryWriter{}
+
+	w.putUint(len(changes.wiqChanges))
+	for _, wiChange := range changes.wiqChanges {
+		w.putInt(int64(wiChange.ChgType))
+		w.putInt(int64(wiChange.ID))
+		w.putBool(wiChange.WorkItem != nil)
+		if wiChange.WorkItem != nil {
+			w.putWorkItem(wiChange.WorkItem)
+		}
+	}
+
+	w.putUint(len(changes.instChanges))
+	for flowID, ic := range changes.instChanges {
+		w.putInt(int64(flowID))
+		w.putInt(int64(ic.Status))
+		w.putInt(int64(ic.State))
+
+		w.putUint(len(ic.AttrChanges))
+		for _, attrChange := range ic.AttrChanges {
+			w.putInt(int64(attrChange.ChgType))
+			w.putString(attrChange.Attribute.Name())
+			w.putValue(attrChange.Attribute.Value())
+		}
+
+		w.putUint(len(ic.tiChanges))
+		for _, tiChange := range ic.tiChanges {
+			w.putInt(int64(tiChange.ChgType))
+			w.putString(tiChange.ID)
+			w.putBool(tiChange.TaskInst != nil)
+			if tiChange.TaskInst != nil {
+				w.putInt(int64(tiChange.TaskInst.status))
+				var outputs interface{}
+				if tiChange.TaskInst.status == model.TaskStatusDone && tiChange.TaskInst.outputs != nil {
+					outputs = tiChange.TaskInst.outputs
+				}
+				w.putValue(outputs)
+			}
+		}
+
+		w.putUint(len(ic.liChanges))
+		for _, liChange := range ic.liChanges {
+			w.putInt(int64(liChange.ChgType))
+			w.putInt(int64(liChange.ID))
+			w.putBool(liChange.LinkInst != nil)
+			if liChange.LinkInst != nil {
+				w.putInt(int64(liChange.LinkInst.status))
+			}
+		}
+
+		w.putBool(ic.SubFlowChg != nil)
+		if ic.SubFlowChg != nil {
+			w.putInt(int64(ic.SubFlowChg.SubFlowID))
+			w.putString(ic.SubFlowChg.TaskID)
+			w.putInt(int64(ic.SubFlowChg.ChgType))
+			w.putString(ic.SubFlowChg.FlowURI)
+		}
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	return c.wrap(binaryKindStep, w.buf.Bytes())
+}
+
+func (c *binaryCodec) StepToJSON(b []byte) ([]byte, error) {
+
+	changes, err := c.DecodeStep(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(changes)
+}
+
+func (c *binaryCodec) DecodeStep(b []byte) (*InstanceChangeTracker, error) {
+
+	content, err := c.unwrap(binaryKindStep, b)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &binaryReader{buf: bytes.NewReader(content)}
+
+	changes := NewInstanceChangeTracker()
+
+	n := r.getLen()
+	for i := 0; i < n; i++ {
+		wiChange := &WorkItemQueueChange{ChgType: ChgType(r.getInt()), ID: int(r.getInt())}
+		if r.getBool() {
+			wiChange.WorkItem = r.getWorkItem()
+		}
+		changes.trackWorkItem(wiChange)
+	}
+
+	n = r.getLen()
+	for i := 0; i < n; i++ {
+		flowID := int(r.getInt())
+		ic := changes.getInstChange(flowID)
+		ic.Status = model.FlowStatus(r.getInt())
+		ic.State = int(r.getInt())
+
+		numAttrs := r.getLen()
+		for j := 0; j < numAttrs; j++ {
+			chgType := ChgType(r.getInt())
+			name := r.getString()
+			ic.AttrChanges = append(ic.AttrChanges, &AttributeChange{SubFlowID: flowID, ChgType: chgType,
+				Attribute: data.NewAttribute(name, data.TypeAny, r.getValue())})
+		}
+
+		numTasks := r.getLen()
+		for j := 0; j < numTasks; j++ {
+			tiChange := &TaskInstChange{ChgType: ChgType(r.getInt()), ID: r.getString()}
+			if r.getBool() {
+				taskInst := &TaskInst{taskID: tiChange.ID, status: model.TaskStatus(r.getInt())}
+				taskInst.outputs, _ = r.getValue().(map[string]interface{})
+				tiChange.TaskInst = taskInst
+			}
+			changes.trackTaskData(flowID, tiChange)
+		}
+
+		numLinks := r.getLen()
+		for j := 0; j < numLinks; j++ {
+			liChange := &LinkInstChange{ChgType: ChgType(r.getInt()), ID: int(r.getInt())}
+			if r.getBool() {
+				liChange.LinkInst = &LinkInst{linkID: liChange.ID, status: model.LinkStatus(r.getInt())}
+			}
+			changes.trackLinkData(flowID, liChange)
+		}
+
+		if r.getBool() {
+			ic.SubFlowChg = &SubFlowChange{SubFlowID: int(r.getInt()), TaskID: r.getString(), ChgType: ChgType(r.getInt()), FlowURI: r.getString()}
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return changes, nil
+}
+
+// wrap adds the header to the content, compressing it if needed
+func (c *binaryCodec) wrap(kind byte, content []byte) ([]byte, error) {
+
+	var flags byte
+	if c.compress {
+		flags |= binaryFlagGzip
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{'F', 'I', binaryVersion, kind, flags})
+
+	if !c.compress {
+		buf.Write(content)
+		return buf.Bytes(), nil
+	}
+
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(content); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unwrap validates the header and returns the uncompressed content, the compression
+// flag of the header is used so that any binary encoding can be decoded
+func (c *binaryCodec) unwrap(kind byte, b []byte) ([]byte, error) {
+
+	if len(b) < 5 || b[0] != 'F' || b[1] != 'I' {
+		return nil, errInvalidEncoding
+	}
+
+	if b[2] != binaryVersion {
+		return nil, fmt.Errorf("unsupported binary encoding version %d", b[2])
+	}
+
+	if b[3] != kind {
+		return nil, fmt.Errorf("unexpected binary content kind %d", b[3])
+	}
+
+	if b[4]&binaryFlagGzip == 0 {
+		return b[5:], nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(b[5:]))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return ioutil.ReadAll(zr)
+}
+
+// binaryWriter writes the values of the binary encoding, the first error is retained
+type binaryWriter struct {
+	buf bytes.Buffer
+	tmp [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (w *binaryWriter) putUint(v int) {
+	n := binary.PutUvarint(w.tmp[:], uint64(v))
+	w.buf.Write(w.tmp[:n])
+}
+
+func (w *binaryWriter) putInt(v int64) {
+	n := binary.PutVarint(w.tmp[:], v)
+	w.buf.Write(w.tmp[:n])
+}
+
+func (w *binaryWriter) putBool(v bool) {
+	if v {
+		w.buf.WriteByte(1)
+	} else {
+		w.buf.WriteByte(0)
+	}
+}
+
+func (w *binaryWriter) putString(v string) {
+	w.putUint(len(v))
+	w.buf.WriteString(v)
+}
+
+func (w *binaryWriter) putAttrs(attrs []*data.Attribute) {
+	w.putUint(len(attrs))
+	for _, attr := range attrs {
+		w.putString(attr.Name())
+		w.putValue(attr.Value())
+	}
+}
+
+func (w *binaryWriter) putWorkItem(workItem *WorkItem) {
+	w.putInt(int64(workItem.ID))
+	w.putString(workItem.TaskID)
+	w.putInt(int64(workItem.SubFlowID))
+}
+
+func (w *binaryWriter) putTaskInsts(taskInsts []*TaskInst) {
+	w.putUint(len(taskInsts))
+	for _, taskInst := range taskInsts {
+		w.putString(taskInst.taskID)
+		w.putInt(int64(taskInst.status))
+	}
+}
+
+func (w *binaryWriter) putLinkInsts(linkInsts []*LinkInst) {
+	w.putUint(len(linkInsts))
+	for _, linkInst := range linkInsts {
+		w.putInt(int64(linkInst.linkID))
+		w.putInt(int64(linkInst.status))
+	}
+}
+
+func (w *binaryWriter) putValue(value interface{}) {
+
+	switch v := value.(type) {
+	case nil:
+		w.buf.WriteByte(tagNil)
+	case bool:
+		if v {
+			w.buf.WriteByte(tagTrue)
+		} else {
+			w.buf.WriteByte(tagFalse)
+		}
+	case int:
+		w.buf.WriteByte(tagInt)
+		w.putInt(int64(v))
+	case int32:
+		w.buf.WriteByte(tagInt)
+		w.putInt(int64(v))
+	case int64:
+		w.buf.WriteByte(tagInt64)
+		w.putInt(v)
+	case float32:
+		w.putFloat(float64(v))
+	case float64:
+		w.putFloat(v)
+	case string:
+		w.buf.WriteByte(tagString)
+		w.putString(v)
+	case []byte:
+		w.buf.WriteByte(tagBytes)
+		w.putUint(len(v))
+		w.buf.Write(v)
+	case []interface{}:
+		w.buf.WriteByte(tagArray)
+		w.putUint(len(v))
+		for _, elem := range v {
+			w.putValue(elem)
+		}
+	case map[string]interface{}:
+		w.buf.WriteByte(tagObject)
+		w.putUint(len(v))
+		for key, elem := range v {
+			w.putString(key)
+			w.putValue(elem)
+		}
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			if w.err == nil {
+				w.err = err
+			}
+			w.buf.WriteByte(tagNil)
+			return
+		}
+		w.buf.WriteByte(tagJSON)
+		w.putUint(len(b))
+		w.buf.Write(b)
+	}
+}
+
+func (w *binaryWriter) putFloat(v float64) {
+	w.buf.WriteByte(tagFloat)
+	binary.LittleEndian.PutUint64(w.tmp[:8], math.Float64bits(v))
+	w.buf.Write(w.tmp[:8])
+}
+
+// binaryReader reads the values of the binary encoding, the first error is retained
+// and zero values are returned once an error occurred
+type binaryReader struct {
+	buf *bytes.Reader
+	err error
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.err = err
+	}
+}
+
+func (r *binaryReader) getUint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(r.buf)
+	if err != nil {
+		r.fail(err)
+	}
+	return v
+}
+
+// getLen reads a length, which cannot exceed the remaining content
+func (r *binaryReader) getLen() int {
+	v := r.getUint()
+	if v > uint64(r.buf.Len()) {
+		r.fail(errInvalidEncoding)
+		return 0
+	}
+	return int(v)
+}
+
+func (r *binaryReader) getInt() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(r.buf)
+	if err != nil {
+		r.fail(err)
+	}
+	return v
+}
+
+func (r *binaryReader) getByte() byte {
+	if r.err != nil {
+		return 0
+	}
+	b, err := r.buf.ReadByte()
+	if err != nil {
+		r.fail(err)
+	}
+	return b
+}
+
+func (r *binaryReader) getBool() bool {
+	return r.getByte() == 1
+}
+
+func (r *binaryReader) getBytes() []byte {
+	n := r.getLen()
+	if r.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.buf, b); err != nil {
+		r.fail(err)
+	}
+	return b
+}
+
+func (r *binaryReader) getString() string {
+	return string(r.getBytes())
+}
+
+func (r *binaryReader) getAttrs() []*data.Attribute {
+	n := r.getLen()
+	attrs := make([]*data.Attribute, 0, n)
+	for i := 0; i < n; i++ {
+		name := r.getString()
+		attrs = append(attrs, data.NewAttribute(name, data.TypeAny, r.getValue()))
+	}
+	return attrs
+}
+
+func (r *binaryReader) getWorkItem() *WorkItem {
+	return &WorkItem{ID: int(r.getInt()), TaskID: r.getString(), SubFlowID: int(r.getInt())}
+}
+
+func (r *binaryReader) getTaskInsts() []*TaskInst {
+	n := r.getLen()
+	taskInsts := make([]*TaskInst, 0, n)
+	for i := 0; i < n; i++ {
+		taskInsts = append(taskInsts, &TaskInst{taskID: r.getString(), status: model.TaskStatus(r.getInt())})
+	}
+	return taskInsts
+}
+
+func (r *binaryReader) getLinkInsts() []*LinkInst {
+	n := r.getLen()
+	linkInsts := make([]*LinkInst, 0, n)
+	for i := 0; i < n; i++ {
+		linkInsts = append(linkInsts, &LinkInst{linkID: int(r.getInt()), status: model.LinkStatus(r.getInt())})
+	}
+	return linkInsts
+}
+
+func (r *binaryReader) getValue() interface{} {
+
+	switch tag := r.getByte(); tag {
+	case tagNil:
+		return nil
+	case tagFalse:
+		return false
+	case tagTrue:
+		return true
+	case tagInt:
+		return int(r.getInt())
+	case tagInt64:
+		return r.getInt()
+	case tagFloat:
+		var b [8]byte
+		if r.err == nil {
+			if _, err := io.ReadFull(r.buf, b[:]); err != nil {
+				r.fail(err)
+			}
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+	case tagString:
+		return r.getString()
+	case tagBytes:
+		return r.getBytes()
+	case tagArray:
+		n := r.getLen()
+		values := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			values = append(values, r.getValue())
+		}
+		return values
+	case tagObject:
+		n := r.getLen()
+		values := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			key := r.getString()
+			values[key] = r.getValue()
+		}
+		return values
+	case tagJSON:
+		b := r.getBytes()
+		if r.err != nil {
+			return nil
+		}
+		var value interface{}
+		if err := json.Unmarshal(b, &value); err != nil {
+			r.fail(err)
+		}
+		return value
+	default:
+		r.fail(errInvalidEncoding)
+		return nil
+	}
+}
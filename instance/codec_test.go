@@ -0,0 +1,159 @@
+package instance
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/qingcloudhx/flow/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCodecTestInstance(t *testing.T) *IndependentInstance {
+
+	inst := newRegistryTestInstance(t, "codec")
+	inst.Start(map[string]interface{}{"count": 3, "ratio": 0.5, "name": "test",
+		"nested": map[string]interface{}{"list": []interface{}{"a", int64(2), true, nil}}})
+
+	return inst
+}
+
+func TestBinaryCodec_Snapshot(t *testing.T) {
+
+	for _, compression := range []string{"", CompressionGzip} {
+
+		codec, err := NewCodec(CodecBinary, compression)
+		assert.Nil(t, err)
+
+		inst := newCodecTestInstance(t)
+		inst.DoStep()
+
+		b, err := codec.EncodeSnapshot(inst)
+		assert.Nil(t, err)
+
+		decoded, err := codec.DecodeSnapshot(b)
+		assert.Nil(t, err)
+
+		assert.Equal(t, inst.ID(), decoded.ID())
+		assert.Equal(t, inst.Status(), decoded.Status())
+		assert.Equal(t, inst.FlowURI(), decoded.FlowURI())
+		assert.Equal(t, inst.attrs, decoded.attrs)
+		assert.Equal(t, inst.workItemQueue.List.Len(), decoded.workItemQueue.List.Len())
+		assert.Equal(t, "log2", decoded.nextTaskID())
+
+		assert.Len(t, decoded.taskInsts, len(inst.taskInsts))
+		for id, taskInst := range inst.taskInsts {
+			assert.Equal(t, taskInst.status, decoded.taskInsts[id].status)
+		}
+
+		assert.Len(t, decoded.linkInsts, len(inst.linkInsts))
+		for id, linkInst := range inst.linkInsts {
+			assert.Equal(t, linkInst.status, decoded.linkInsts[id].status)
+		}
+	}
+}
+
+func TestBinaryCodec_Step(t *testing.T) {
+
+	codec, err := NewCodec(CodecBinary, CompressionGzip)
+	assert.Nil(t, err)
+
+	inst := newCodecTestInstance(t)
+
+	for inst.Status() < model.FlowStatusCompleted && inst.DoStep() {
+
+		expected, err := json.Marshal(inst.ChangeTracker)
+		assert.Nil(t, err)
+
+		b, err := codec.EncodeStep(inst.ChangeTracker)
+		assert.Nil(t, err)
+
+		actual, err := codec.StepToJSON(b)
+		assert.Nil(t, err)
+
+		// the changes are serialized from maps, so their order is not deterministic
+		assert.Equal(t, sortedJSON(t, expected), sortedJSON(t, actual))
+	}
+}
+
+func TestBinaryCodec_Size(t *testing.T) {
+
+	codec, _ := NewCodec(CodecBinary, "")
+
+	inst := newCodecTestInstance(t)
+	inst.DoStep()
+
+	encoded, err := codec.EncodeSnapshot(inst)
+	assert.Nil(t, err)
+
+	jsonEncoded, err := json.Marshal(inst)
+	assert.Nil(t, err)
+
+	assert.True(t, len(encoded) < len(jsonEncoded)/2)
+}
+
+func TestBinaryCodec_Invalid(t *testing.T) {
+
+	codec, _ := NewCodec(CodecBinary, "")
+
+	inst := newCodecTestInstance(t)
+	b, err := codec.EncodeSnapshot(inst)
+	assert.Nil(t, err)
+
+	_, err = codec.DecodeSnapshot(b[:len(b)/2])
+	assert.NotNil(t, err)
+
+	_, err = codec.StepToJSON(b)
+	assert.NotNil(t, err)
+
+	b[2] = 99
+	_, err = codec.DecodeSnapshot(b)
+	assert.NotNil(t, err)
+
+	_, err = NewCodec("xml", "")
+	assert.NotNil(t, err)
+}
+
+func TestBinaryCodec_OtherVersion(t *testing.T) {
+
+	codec := &binaryCodec{}
+
+	// binary snapshots aren't migrated
+	b, err := codec.wrap(binaryKindSnapshot, []byte{0})
+	assert.Nil(t, err)
+
+	_, err = codec.DecodeSnapshot(b)
+	_, ok := err.(*UnsupportedStateVersionError)
+	assert.True(t, ok)
+}
+
+// sortedJSON decodes the JSON and sorts its arrays
+func sortedJSON(t *testing.T, b []byte) interface{} {
+
+	var value interface{}
+	err := json.Unmarshal(b, &value)
+	assert.Nil(t, err)
+
+	return sortArrays(value)
+}
+
+func sortArrays(value interface{}) interface{} {
+
+	switch v := value.(type) {
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = sortArrays(elem)
+		}
+		sort.Slice(v, func(i, j int) bool {
+			bi, _ := json.Marshal(v[i])
+			bj, _ := json.Marshal(v[j])
+			return string(bi) < string(bj)
+		})
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = sortArrays(elem)
+		}
+	}
+
+	return value
+}
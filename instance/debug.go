@@ -0,0 +1,235 @@
+package instance
+
+// DebugOptions are the options used to execute an instance in debug mode
+type DebugOptions struct {
+	// Breakpoints are the IDs of the tasks to pause before
+	Breakpoints []string `json:"breakpoints,omitempty"`
+	// PauseOnStart pauses the instance before its first step
+	PauseOnStart bool `json:"pauseOnStart,omitempty"`
+}
+
+// DebugState describes the debug state of a live instance
+type DebugState struct {
+	ID          string   `json:"id"`
+	Status      int      `json:"status"`
+	Paused      bool     `json:"paused"`
+	Breakpoint  string   `json:"breakpoint,omitempty"`
+	Breakpoints []string `json:"breakpoints"`
+
+	// NextTask is the task that will be executed by the next step
+	NextTask *TaskInspection `json:"nextTask,omitempty"`
+	// LastTask is the task that was executed by the last step
+	LastTask *TaskInspection `json:"lastTask,omitempty"`
+}
+
+// TaskInspection contains the runtime data of a TaskInst
+type TaskInspection struct {
+	TaskID      string                 `json:"taskId"`
+	SubFlowID   int                    `json:"subFlowId"`
+	Status      int                    `json:"status"`
+	Inputs      map[string]interface{} `json:"inputs,omitempty"`
+	Outputs     map[string]interface{} `json:"outputs,omitempty"`
+	WorkingData map[string]interface{} `json:"workingData,omitempty"`
+}
+
+// debugState is the debug state of a Handle, it is protected by the Handle's mutex
+type debugState struct {
+	breakpoints map[string]bool
+	breakpoint  string
+
+	// resumed indicates that the next step was explicitly resumed and should not break
+	resumed  bool
+	stepOver bool
+
+	// stepOverFlow is the (sub)flow of the task being stepped over, -1 if not stepping
+	stepOverFlow int
+
+	lastTask *TaskInst
+}
+
+func newDebugState() *debugState {
+	return &debugState{breakpoints: make(map[string]bool), stepOverFlow: -1}
+}
+
+// checkBreak pauses the handle if the next step hits a breakpoint or completes a step over
+func (d *debugState) checkBreak(h *Handle) {
+
+	workItem := h.inst.nextWorkItem()
+	if workItem == nil || d.resumed {
+		return
+	}
+
+	if d.stepOverFlow >= 0 {
+		_, subFlowActive := h.inst.subFlows[d.stepOverFlow]
+
+		if workItem.SubFlowID == d.stepOverFlow || (d.stepOverFlow > 0 && !subFlowActive) {
+			d.stepOverFlow = -1
+			h.paused = true
+			h.info.Paused = true
+			return
+		}
+	}
+
+	if d.breakpoints[workItem.TaskID] {
+		d.stepOverFlow = -1
+		d.breakpoint = workItem.TaskID
+		h.paused = true
+		h.info.Paused = true
+	}
+}
+
+// beforeStep keeps track of the task that is about to be executed
+func (d *debugState) beforeStep(inst *IndependentInstance) {
+
+	workItem := inst.nextWorkItem()
+	if workItem != nil {
+		d.lastTask = workItem.taskInst
+
+		if d.stepOver {
+			d.stepOverFlow = workItem.SubFlowID
+		}
+	}
+
+	d.resumed = false
+	d.stepOver = false
+}
+
+// EnableDebug puts the instance in debug mode using the specified options
+func (h *Handle) EnableDebug(options *DebugOptions) {
+
+	h.mu.Lock()
+	if h.debug == nil {
+		h.debug = newDebugState()
+	}
+
+	if options != nil {
+		for _, taskID := range options.Breakpoints {
+			h.debug.breakpoints[taskID] = true
+		}
+
+		if options.PauseOnStart {
+			h.paused = true
+			h.info.Paused = true
+		}
+	}
+	h.mu.Unlock()
+}
+
+// SetBreakpoints replaces the breakpoints of the instance, enabling debug mode if needed
+func (h *Handle) SetBreakpoints(taskIDs []string) {
+
+	h.mu.Lock()
+	if h.debug == nil {
+		h.debug = newDebugState()
+	}
+
+	h.debug.breakpoints = make(map[string]bool, len(taskIDs))
+	for _, taskID := range taskIDs {
+		h.debug.breakpoints[taskID] = true
+	}
+	h.mu.Unlock()
+}
+
+// StepOver resumes a paused instance, executes the next task and pauses again once the
+// execution returns to the (sub)flow of that task
+func (h *Handle) StepOver() {
+
+	h.mu.Lock()
+	if h.debug == nil {
+		h.debug = newDebugState()
+	}
+
+	if h.paused {
+		h.debug.resumed = true
+		h.debug.stepOver = true
+		h.debug.breakpoint = ""
+		h.paused = false
+		h.info.Paused = false
+		h.cond.Broadcast()
+	}
+	h.mu.Unlock()
+}
+
+// DebugState returns the debug state of the instance, the task data is only
+// inspected when the instance is paused
+func (h *Handle) DebugState() *DebugState {
+
+	h.execMu.Lock()
+	defer h.execMu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state := &DebugState{ID: h.inst.ID(), Status: int(h.inst.Status()), Paused: h.paused, Breakpoints: []string{}}
+
+	if h.debug != nil {
+		state.Breakpoint = h.debug.breakpoint
+
+		for taskID := range h.debug.breakpoints {
+			state.Breakpoints = append(state.Breakpoints, taskID)
+		}
+	}
+
+	if h.paused {
+		if workItem := h.inst.nextWorkItem(); workItem != nil && workItem.taskInst != nil {
+			state.NextTask = inspectTask(workItem.taskInst)
+		}
+
+		if h.debug != nil && h.debug.lastTask != nil {
+			state.LastTask = inspectTask(h.debug.lastTask)
+		}
+	}
+
+	return state
+}
+
+// InspectTask returns the runtime data of the specified task of the top level flow or of
+// one of its active embedded flows
+func (h *Handle) InspectTask(taskID string) (*TaskInspection, bool) {
+
+	h.execMu.Lock()
+	defer h.execMu.Unlock()
+
+	if taskInst, ok := h.inst.taskInsts[taskID]; ok {
+		return inspectTask(taskInst), true
+	}
+
+	for _, subFlow := range h.inst.subFlows {
+		if taskInst, ok := subFlow.taskInsts[taskID]; ok {
+			return inspectTask(taskInst), true
+		}
+	}
+
+	return nil, false
+}
+
+// Inspect returns the runtime data of the task
+func (ti *TaskInst) Inspect() *TaskInspection {
+	return inspectTask(ti)
+}
+
+func inspectTask(taskInst *TaskInst) *TaskInspection {
+
+	ti := &TaskInspection{
+		TaskID: taskInst.taskID,
+		Status: int(taskInst.status),
+	}
+
+	if taskInst.flowInst != nil {
+		ti.SubFlowID = taskInst.flowInst.subFlowId
+	}
+
+	if len(taskInst.inputs) > 0 {
+		ti.Inputs = taskInst.task.MaskValues(taskInst.inputs)
+	}
+
+	if len(taskInst.outputs) > 0 {
+		ti.Outputs = taskInst.task.MaskValues(taskInst.outputs)
+	}
+
+	if taskInst.workingData != nil && len(taskInst.workingData.workingData) > 0 {
+		ti.WorkingData = taskInst.task.MaskValues(taskInst.workingData.workingData)
+	}
+
+	return ti
+}
@@ -0,0 +1,119 @@
+package instance
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandle_Breakpoint(t *testing.T) {
+
+	registry := NewRegistry()
+
+	inst := newRegistryTestInstance(t, "debug")
+	inst.Start(nil)
+	h := registry.Register(inst)
+	h.EnableDebug(&DebugOptions{Breakpoints: []string{"log2"}})
+
+	done := make(chan bool)
+	go func() {
+		for h.DoStep() {
+		}
+		done <- true
+	}()
+
+	waitForPause(t, h)
+
+	state := h.DebugState()
+	assert.True(t, state.Paused)
+	assert.Equal(t, "log2", state.Breakpoint)
+	assert.Equal(t, []string{"log2"}, state.Breakpoints)
+	assert.NotNil(t, state.NextTask)
+	assert.Equal(t, "log2", state.NextTask.TaskID)
+	assert.NotNil(t, state.LastTask)
+	assert.Equal(t, "log1", state.LastTask.TaskID)
+	assert.Equal(t, "first", state.LastTask.Inputs["message"])
+	assert.Equal(t, "first", state.LastTask.Outputs["message"])
+
+	taskInspection, found := h.InspectTask("log2")
+	assert.True(t, found)
+	assert.Equal(t, int(model.TaskStatusReady), taskInspection.Status)
+
+	h.StepOver()
+	<-done
+
+	assert.Equal(t, model.FlowStatusCompleted, inst.Status())
+}
+
+func TestHandle_StepOver(t *testing.T) {
+
+	registry := NewRegistry()
+
+	inst := newRegistryTestInstance(t, "step")
+	inst.Start(nil)
+	h := registry.Register(inst)
+	h.EnableDebug(&DebugOptions{PauseOnStart: true})
+
+	done := make(chan bool)
+	go func() {
+		for h.DoStep() {
+		}
+		done <- true
+	}()
+
+	waitForPause(t, h)
+	assert.Equal(t, "log1", h.DebugState().NextTask.TaskID)
+
+	h.StepOver()
+	waitForPause(t, h)
+
+	state := h.DebugState()
+	assert.Equal(t, "log2", state.NextTask.TaskID)
+	assert.Equal(t, "log1", state.LastTask.TaskID)
+
+	h.Resume()
+	<-done
+
+	assert.Equal(t, model.FlowStatusCompleted, inst.Status())
+}
+
+func waitForPause(t *testing.T, h *Handle) {
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		// paused and waiting for a command
+		if h.Paused() && h.Info().CurrentTask == "" {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatal("instance did not pause")
+}
+
+func TestTaskInst_InspectMasksSensitive(t *testing.T) {
+
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(`{
+	  "name": "sensitive-task-flow",
+	  "model": "test",
+	  "tasks": [ { "id": "echo", "sensitive": [ "message" ], "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "secret" } } } ]
+	}`), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	taskInst := &TaskInst{taskID: "echo", task: def.GetTask("echo"), workingData: NewWorkingDataScope(nil)}
+	taskInst.workingData.SetWorkingValue("message", "secret")
+	taskInst.workingData.SetWorkingValue("index", 1)
+
+	inspection := taskInst.Inspect()
+	assert.Equal(t, definition.MaskedValue, inspection.WorkingData["message"])
+	assert.Equal(t, 1, inspection.WorkingData["index"])
+	assert.Equal(t, "secret", taskInst.workingData.workingData["message"])
+}
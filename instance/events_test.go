@@ -0,0 +1,103 @@
+package instance
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	coreevent "github.com/qingcloudhx/core/engine/event"
+	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/support/event"
+	"github.com/stretchr/testify/assert"
+)
+
+// eventCollector forwards the events it receives to a channel
+type eventCollector struct {
+	events chan interface{}
+}
+
+func (c *eventCollector) HandleEvent(ctx *coreevent.Context) error {
+	c.events <- ctx.GetEvent()
+	return nil
+}
+
+func TestIndependentInstance_Events(t *testing.T) {
+
+	eventTypes := []string{event.FlowEventType, event.TaskEventType, event.LinkEventType, event.IterationEventType}
+	collector := &eventCollector{events: make(chan interface{}, 100)}
+	err := coreevent.RegisterListener("events-test", collector, eventTypes)
+	assert.Nil(t, err)
+	defer coreevent.UnRegisterListener("events-test", eventTypes)
+
+	defRep := &definition.DefinitionRep{}
+	err = json.Unmarshal([]byte(tracingDefJSON), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	inst, err := NewIndependentInstance("evented", "res://flow:tracing", def, log.RootLogger())
+	assert.Nil(t, err)
+
+	inst.Start(nil)
+	runToCompletion(inst)
+
+	var iterations []event.IterationEvent
+	var links []event.LinkEvent
+	var loopDone event.TaskEvent
+	var flowDone event.FlowEvent
+
+	timeout := time.After(5 * time.Second)
+	for flowDone == nil {
+		select {
+		case evt := <-collector.events:
+			switch e := evt.(type) {
+			case event.FlowEvent:
+				if e.FlowStatus() == event.COMPLETED {
+					flowDone = e
+				}
+			case event.TaskEvent:
+				if e.TaskID() == "loop" && e.TaskStatus() == event.COMPLETED {
+					loopDone = e
+				}
+			case event.LinkEvent:
+				links = append(links, e)
+			case event.IterationEvent:
+				iterations = append(iterations, e)
+			}
+		case <-timeout:
+			t.Fatal("flow completion event not received")
+		}
+	}
+
+	assert.Equal(t, "evented", flowDone.FlowID())
+	assert.Equal(t, 0, flowDone.SubFlowID())
+	assert.False(t, flowDone.StartTime().IsZero())
+	assert.True(t, flowDone.Duration() >= 0)
+	assert.Equal(t, inst.StepID(), flowDone.StepID())
+
+	assert.NotNil(t, loopDone)
+	assert.Equal(t, 3, loopDone.Iteration())
+	assert.Equal(t, 0, loopDone.RetryCount())
+	assert.False(t, loopDone.StartTime().IsZero())
+
+	if assert.Len(t, iterations, 6) {
+		for i := 0; i < 3; i++ {
+			started, ended := iterations[2*i], iterations[2*i+1]
+			assert.Equal(t, event.Status(event.STARTED), started.IterationStatus())
+			assert.Equal(t, event.Status(event.COMPLETED), ended.IterationStatus())
+			assert.Equal(t, i, started.Iteration())
+			assert.Equal(t, i, ended.Iteration())
+			assert.Equal(t, i, ended.IterationKey())
+			assert.Equal(t, "loop", ended.TaskID())
+			assert.Equal(t, started.StartTime(), ended.StartTime())
+		}
+	}
+
+	if assert.Len(t, links, 1) {
+		assert.Equal(t, "loop", links[0].FromTask())
+		assert.Equal(t, "echo", links[0].ToTask())
+		assert.Equal(t, event.Status(event.TRUE), links[0].LinkStatus())
+	}
+}
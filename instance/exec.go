@@ -17,12 +17,41 @@ type RunOptions struct {
 	FlowURI      string
 	InitialState *IndependentInstance
 	ExecOptions  *ExecOptions
+	// RestartFrom is the task from which a restarted instance continues its execution
+	RestartFrom *RestartFrom
+}
+
+// RestartFrom describes the task from which a restarted instance continues its execution
+type RestartFrom struct {
+	TaskID string `json:"taskId"`
+	// Inputs override the inputs of the task
+	Inputs map[string]interface{} `json:"inputs,omitempty"`
 }
 
 // ExecOptions are optional Patch & Interceptor to be used during instance execution
 type ExecOptions struct {
 	Patch       *support.Patch
 	Interceptor *support.Interceptor
+	Debug       *DebugOptions
+	Faults      *support.FaultInjection
+	Listener    ExecListener `json:"-"`
+}
+
+// ExecListener is synchronously notified during the execution of an instance, it can be
+// used by tests and tools to observe the tasks and links evaluated by the instance
+type ExecListener interface {
+	// TaskStatusChanged is called when the status of a task of the instance, or of one of
+	// its embedded flows, changes
+	TaskStatusChanged(inst *IndependentInstance, taskInst *TaskInst)
+	// LinkStatusChanged is called when the status of a link of the instance, or of one of
+	// its embedded flows, changes
+	LinkStatusChanged(inst *IndependentInstance, linkInst *LinkInst)
+}
+
+// DoneListener can be implemented by an ExecListener to be notified when the instance is done
+type DoneListener interface {
+	// InstanceDone is called when the instance completes, fails or is cancelled
+	InstanceDone(inst *IndependentInstance)
 }
 
 // IDGenerator generates IDs for flow instances
@@ -47,6 +76,16 @@ func ApplyExecOptions(instance *IndependentInstance, execOptions *ExecOptions) {
 			instance.interceptor = execOptions.Interceptor
 			instance.interceptor.Init()
 		}
+
+		if execOptions.Faults != nil {
+			instance.faults = execOptions.Faults
+			instance.faults.Init()
+			instance.logger.Infof("Instance [%s] is injecting faults using seed: %d", instance.ID(), instance.faults.Seed)
+		}
+
+		if execOptions.Listener != nil {
+			instance.listener = execOptions.Listener
+		}
 	}
 }
 
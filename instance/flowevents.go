@@ -9,11 +9,12 @@ import (
 )
 
 type flowEvent struct {
-	time                           time.Time
+	time, startTime                time.Time
 	err                            error
 	input, output                  map[string]interface{}
 	status                         event.Status
 	name, id, parentName, parentId string
+	subFlowId, stepId              int
 }
 
 func (fe *flowEvent) FlowName() string {
@@ -60,6 +61,29 @@ func (fe *flowEvent) FlowError() error {
 	return fe.err
 }
 
+// In case of subflow, returns its ID, 0 otherwise
+func (fe *flowEvent) SubFlowID() int {
+	return fe.subFlowId
+}
+
+// Returns the ID of the step of the instance during which the event occurred
+func (fe *flowEvent) StepID() int {
+	return fe.stepId
+}
+
+// Returns the time the flow instance started
+func (fe *flowEvent) StartTime() time.Time {
+	return fe.startTime
+}
+
+// Returns the time elapsed between the start of the flow instance and the event
+func (fe *flowEvent) Duration() time.Duration {
+	if fe.startTime.IsZero() {
+		return 0
+	}
+	return fe.time.Sub(fe.startTime)
+}
+
 func postFlowEvent(inst *Instance) {
 
 	if coreevent.HasListener(event.FlowEventType) {
@@ -68,9 +92,12 @@ func postFlowEvent(inst *Instance) {
 		fe.time = time.Now()
 		fe.name = inst.Name()
 		fe.id = inst.ID()
+		fe.startTime = inst.startTime
+		fe.subFlowId = inst.subFlowId
 		if inst.master != nil {
 			fe.parentName = inst.master.Name()
 			fe.parentId = inst.master.ID()
+			fe.stepId = inst.master.StepID()
 		}
 		fe.status = convertFlowStatus(inst.Status())
 
@@ -84,12 +111,12 @@ func postFlowEvent(inst *Instance) {
 				for name, attVal := range attrs {
 					if outData != nil && outData[name] != nil {
 						if fe.status == event.COMPLETED {
-							fe.output[name] = attVal
+							fe.output[name] = inst.flowDef.Mask(name, attVal)
 						}
 						// Since same attribute map is used for input and output, filter output attributes
 						continue
 					}
-					fe.input[name] = attVal
+					fe.input[name] = inst.flowDef.Mask(name, attVal)
 				}
 			}
 		}
@@ -2,6 +2,7 @@ package instance
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/qingcloudhx/core/action"
 	"github.com/qingcloudhx/core/data"
@@ -9,6 +10,7 @@ import (
 	"github.com/qingcloudhx/core/support/log"
 	"github.com/qingcloudhx/flow/definition"
 	"github.com/qingcloudhx/flow/model"
+	"github.com/qingcloudhx/flow/support/trace"
 )
 
 type Instance struct {
@@ -19,9 +21,13 @@ type Instance struct {
 
 	isHandlingError bool
 
-	status  model.FlowStatus
-	flowDef *definition.Definition
-	flowURI string //needed for serialization
+	status    model.FlowStatus
+	startTime time.Time
+	flowDef   *definition.Definition
+	flowURI   string //needed for serialization
+
+	// releaseDef releases the definition once the instance is done
+	releaseDef func()
 
 	taskInsts map[string]*TaskInst
 	linkInsts map[int]*LinkInst
@@ -36,6 +42,8 @@ type Instance struct {
 	resultHandler action.ResultHandler
 
 	logger log.Logger
+
+	span *trace.Span
 }
 
 func (inst *Instance) FlowURI() string {
@@ -96,8 +104,10 @@ func (inst *Instance) FindOrCreateLinkData(link *definition.Link) (linkInst *Lin
 }
 
 func (inst *Instance) releaseTask(task *definition.Task) {
+	// the released TaskInst is tracked so that its final status and outputs are recorded
+	taskInst := inst.taskInsts[task.ID()]
 	delete(inst.taskInsts, task.ID())
-	inst.master.ChangeTracker.trackTaskData(inst.subFlowId, &TaskInstChange{ChgType: CtDel, ID: task.ID()})
+	inst.master.ChangeTracker.trackTaskData(inst.subFlowId, &TaskInstChange{ChgType: CtDel, ID: task.ID(), TaskInst: taskInst})
 	links := task.FromLinks()
 
 	for _, link := range links {
@@ -170,8 +180,28 @@ func (inst *Instance) Status() model.FlowStatus {
 func (inst *Instance) SetStatus(status model.FlowStatus) {
 
 	inst.status = status
+	if status == model.FlowStatusActive && inst.startTime.IsZero() {
+		inst.startTime = time.Now()
+	}
 	inst.master.ChangeTracker.SetStatus(inst.subFlowId, status)
 	postFlowEvent(inst)
+
+	if tracer != nil {
+		traceFlowStatus(inst)
+	}
+
+	if status >= model.FlowStatusCompleted {
+		if inst.releaseDef != nil {
+			inst.releaseDef()
+			inst.releaseDef = nil
+		}
+
+		if inst.subFlowId == 0 {
+			if listener, ok := inst.master.listener.(DoneListener); ok {
+				listener.InstanceDone(inst.master)
+			}
+		}
+	}
 }
 
 // FlowDefinition returns the Flow definition associated with this context
@@ -216,7 +246,7 @@ func (inst *Instance) SetValue(name string, value interface{}) error {
 	}
 
 	if inst.logger.DebugEnabled() {
-		inst.logger.Debugf("SetAttr - name: %s, value:%v\n", name, value)
+		inst.logger.Debugf("SetAttr - name: %s, value:%v\n", name, inst.flowDef.Mask(name, value))
 	}
 
 	inst.attrs[name] = value
@@ -235,14 +265,14 @@ func (inst *Instance) UpdateAttrs(attrs map[string]interface{}) {
 
 	if attrs != nil {
 
-		inst.logger.Debugf("Updating flow attrs: %v", attrs)
+		inst.logger.Debugf("Updating flow attrs: %v", inst.flowDef.MaskValues(attrs))
 
 		if inst.attrs == nil {
 			inst.attrs = make(map[string]interface{}, len(attrs))
 		}
 
 		for name, attr := range attrs {
-			inst.attrs[name] = attr
+			inst.SetValue(name, attr)
 		}
 	}
 }
@@ -55,6 +55,7 @@ type SubFlowChange struct {
 	SubFlowID int
 	TaskID    string
 	ChgType   ChgType
+	FlowURI   string `json:",omitempty"`
 }
 
 // AttributeChange represents a change to an Attribute
@@ -79,7 +80,7 @@ func (ict *InstanceChangeTracker) getInstChange(flowId int) *InstanceChange {
 
 	change, exists := ict.instChanges[flowId]
 	if !exists {
-		change = &InstanceChange{}
+		change = &InstanceChange{SubFlowID: flowId}
 		ict.instChanges[flowId] = change
 	}
 
@@ -112,7 +113,7 @@ func (ict *InstanceChangeTracker) AttrChange(subFlowId int, chgType ChgType, att
 }
 
 // AttrChange is called to track a status change of an Attribute
-func (ict *InstanceChangeTracker) SubFlowChange(parentFlowId int, chgType ChgType, subFlowId int, taskID string) {
+func (ict *InstanceChangeTracker) SubFlowChange(parentFlowId int, chgType ChgType, subFlowId int, taskID string, flowURI string) {
 
 	ic := ict.getInstChange(parentFlowId)
 
@@ -120,6 +121,7 @@ func (ict *InstanceChangeTracker) SubFlowChange(parentFlowId int, chgType ChgTyp
 	change.ChgType = chgType
 	change.SubFlowID = subFlowId
 	change.TaskID = taskID
+	change.FlowURI = flowURI
 
 	ic.SubFlowChg = &change
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"github.com/qingcloudhx/core/data"
 	"github.com/qingcloudhx/core/support"
+	"github.com/qingcloudhx/flow/definition"
 	"github.com/qingcloudhx/flow/model"
 )
 
@@ -11,6 +12,7 @@ import (
 // Flow Instance Serialization
 
 type serIndependentInstance struct {
+	Version   int               `json:"version"`
 	ID        string            `json:"id"`
 	Status    model.FlowStatus  `json:"status"`
 	FlowURI   string            `json:"flowUri"`
@@ -20,7 +22,7 @@ type serIndependentInstance struct {
 	LinkInsts []*LinkInst       `json:"links"`
 	SubFlows  []*Instance       `json:"subFlows,omitempty"`
 
-	//for backwards compatibility
+	//for backwards compatibility, only written so older versions can read the state
 	RootTaskEnv *oldTaskEnv `json:"rootTaskEnv"`
 }
 
@@ -42,6 +44,11 @@ type linkData struct {
 
 // MarshalJSON overrides the default MarshalJSON for FlowInstance
 func (inst *IndependentInstance) MarshalJSON() ([]byte, error) {
+	return json.Marshal(inst.toSer())
+}
+
+// toSer creates the serializable representation of the instance
+func (inst *IndependentInstance) toSer() *serIndependentInstance {
 
 	queue := make([]*WorkItem, inst.workItemQueue.List.Len())
 
@@ -98,7 +105,8 @@ func (inst *IndependentInstance) MarshalJSON() ([]byte, error) {
 
 	//serialize all the subFlows
 
-	return json.Marshal(&serIndependentInstance{
+	return &serIndependentInstance{
+		Version:     StateVersion,
 		ID:          inst.id,
 		Status:      inst.status,
 		Attrs:       attrs,
@@ -108,18 +116,32 @@ func (inst *IndependentInstance) MarshalJSON() ([]byte, error) {
 		LinkInsts:   lis,
 		SubFlows:    sfs,
 		RootTaskEnv: rootTaskEnv,
-	})
+	}
 }
 
 // UnmarshalJSON overrides the default UnmarshalJSON for FlowInstance
 func (inst *IndependentInstance) UnmarshalJSON(d []byte) error {
 
+	d, err := migrateState(d)
+	if err != nil {
+		return err
+	}
+
 	ser := &serIndependentInstance{}
 	if err := json.Unmarshal(d, ser); err != nil {
 		return err
 	}
 
+	inst.fromSer(ser)
+
+	return nil
+}
+
+// fromSer initializes the instance from its serializable representation
+func (inst *IndependentInstance) fromSer(ser *serIndependentInstance) {
+
 	inst.Instance = &Instance{}
+	inst.master = inst
 	inst.id = ser.ID
 	inst.status = ser.Status
 	inst.flowURI = ser.FlowURI
@@ -131,6 +153,7 @@ func (inst *IndependentInstance) UnmarshalJSON(d []byte) error {
 	}
 
 	inst.ChangeTracker = NewInstanceChangeTracker()
+	inst.trackingChanges = true
 
 	inst.taskInsts = make(map[string]*TaskInst, len(ser.TaskInsts))
 
@@ -144,18 +167,6 @@ func (inst *IndependentInstance) UnmarshalJSON(d []byte) error {
 		inst.linkInsts[linkInst.linkID] = linkInst
 	}
 
-	//todo for backwards compatibility
-	if ser.RootTaskEnv != nil {
-		for _, value := range ser.RootTaskEnv.TaskDatas {
-			inst.taskInsts[value.TaskID] = &TaskInst{taskID: value.TaskID, status: model.TaskStatus(value.State)}
-		}
-
-		for _, value := range ser.RootTaskEnv.LinkDatas {
-			inst.linkInsts[value.LinkID] = &LinkInst{linkID: value.LinkID, status: model.LinkStatus(value.State)}
-		}
-
-	}
-
 	subFlowCtr := 0
 
 	if len(ser.SubFlows) > 0 {
@@ -186,8 +197,6 @@ func (inst *IndependentInstance) UnmarshalJSON(d []byte) error {
 		workItem.taskInst = taskInsts[workItem.TaskID]
 		inst.workItemQueue.Push(workItem)
 	}
-
-	return nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -204,6 +213,11 @@ type serInstance struct {
 
 // MarshalJSON overrides the default MarshalJSON for FlowInstance
 func (inst *Instance) MarshalJSON() ([]byte, error) {
+	return json.Marshal(inst.toSer())
+}
+
+// toSer creates the serializable representation of the embedded instance
+func (inst *Instance) toSer() *serInstance {
 
 	attrs := make([]*data.Attribute, 0, len(inst.attrs))
 
@@ -223,14 +237,14 @@ func (inst *Instance) MarshalJSON() ([]byte, error) {
 		lis = append(lis, linkInst)
 	}
 
-	return json.Marshal(&serInstance{
+	return &serInstance{
 		SubFlowId: inst.subFlowId,
 		Status:    inst.status,
 		Attrs:     attrs,
 		FlowURI:   inst.flowURI,
 		TaskInsts: tis,
 		LinkInsts: lis,
-	})
+	}
 }
 
 // UnmarshalJSON overrides the default UnmarshalJSON for FlowInstance
@@ -241,6 +255,14 @@ func (inst *Instance) UnmarshalJSON(d []byte) error {
 		return err
 	}
 
+	inst.fromSer(ser)
+
+	return nil
+}
+
+// fromSer initializes the embedded instance from its serializable representation
+func (inst *Instance) fromSer(ser *serInstance) {
+
 	inst.subFlowId = ser.SubFlowId
 	inst.status = ser.Status
 	inst.flowURI = ser.FlowURI
@@ -262,8 +284,6 @@ func (inst *Instance) UnmarshalJSON(d []byte) error {
 	for _, linkInst := range ser.LinkInsts {
 		inst.linkInsts[linkInst.linkID] = linkInst
 	}
-
-	return nil
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -276,7 +296,7 @@ func (ti *TaskInst) MarshalJSON() ([]byte, error) {
 		TaskID string `json:"taskId"`
 		Status int    `json:"status"`
 	}{
-		TaskID: ti.task.ID(),
+		TaskID: ti.taskID,
 		Status: int(ti.status),
 	})
 }
@@ -310,15 +330,22 @@ func (ti *TaskInst) UnmarshalJSON(d []byte) error {
 func (ti *TaskInstChange) MarshalJSON() ([]byte, error) {
 
 	var td *taskData
+	var outputs map[string]interface{}
 
 	if ti.TaskInst != nil {
-		td = &taskData{State: int(ti.TaskInst.status), TaskID: ti.TaskInst.task.ID()}
+		td = &taskData{State: int(ti.TaskInst.status), TaskID: ti.TaskInst.taskID}
+
+		// the outputs of the activity are recorded so that the step can be replayed
+		if ti.TaskInst.status == model.TaskStatusDone {
+			outputs = ti.TaskInst.outputs
+		}
 	}
 
 	return json.Marshal(&struct {
-		ChgType  ChgType   `json:"ct"`
-		ID       string    `json:"id"`
-		TaskInst *TaskInst `json:"task,omitempty"`
+		ChgType  ChgType                `json:"ct"`
+		ID       string                 `json:"id"`
+		TaskInst *TaskInst              `json:"task,omitempty"`
+		Outputs  map[string]interface{} `json:"outputs,omitempty"`
 
 		ChgTypeOld ChgType   `json:"ChgType"`
 		IDOld      string    `json:"ID"`
@@ -327,6 +354,7 @@ func (ti *TaskInstChange) MarshalJSON() ([]byte, error) {
 		ChgType:  ti.ChgType,
 		ID:       ti.ID,
 		TaskInst: ti.TaskInst,
+		Outputs:  outputs,
 
 		ChgTypeOld: ti.ChgType,
 		IDOld:      ti.ID,
@@ -334,13 +362,38 @@ func (ti *TaskInstChange) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// UnmarshalJSON overrides the default UnmarshalJSON for TaskInstChange, the TaskInst
+// of the change only contains its ID, status and outputs
+func (ti *TaskInstChange) UnmarshalJSON(d []byte) error {
+	ser := &struct {
+		ChgType  ChgType                `json:"ct"`
+		ID       string                 `json:"id"`
+		TaskInst *TaskInst              `json:"task"`
+		Outputs  map[string]interface{} `json:"outputs"`
+	}{}
+
+	if err := json.Unmarshal(d, ser); err != nil {
+		return err
+	}
+
+	ti.ChgType = ser.ChgType
+	ti.ID = ser.ID
+	ti.TaskInst = ser.TaskInst
+
+	if ti.TaskInst != nil {
+		ti.TaskInst.outputs = ser.Outputs
+	}
+
+	return nil
+}
+
 // MarshalJSON overrides the default MarshalJSON for TaskInst
 func (li *LinkInstChange) MarshalJSON() ([]byte, error) {
 
 	var ld *linkData
 
 	if li.LinkInst != nil {
-		ld = &linkData{State: int(li.LinkInst.status), LinkID: li.LinkInst.link.ID()}
+		ld = &linkData{State: int(li.LinkInst.status), LinkID: li.LinkInst.linkID}
 	}
 
 	return json.Marshal(&struct {
@@ -362,6 +415,25 @@ func (li *LinkInstChange) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// UnmarshalJSON overrides the default UnmarshalJSON for LinkInstChange
+func (li *LinkInstChange) UnmarshalJSON(d []byte) error {
+	ser := &struct {
+		ChgType  ChgType   `json:"ct"`
+		ID       int       `json:"id"`
+		LinkInst *LinkInst `json:"link"`
+	}{}
+
+	if err := json.Unmarshal(d, ser); err != nil {
+		return err
+	}
+
+	li.ChgType = ser.ChgType
+	li.ID = ser.ID
+	li.LinkInst = ser.LinkInst
+
+	return nil
+}
+
 //// LinkInstChange represents a change to a LinkInst
 //type LinkInstChange struct {
 //	ChgType  ChgType
@@ -379,7 +451,7 @@ func (ld *LinkInst) MarshalJSON() ([]byte, error) {
 		LinkID int `json:"linkId"`
 		Status int `json:"status"`
 	}{
-		LinkID: ld.link.ID(),
+		LinkID: ld.linkID,
 		Status: int(ld.status),
 	})
 }
@@ -404,6 +476,65 @@ func (ld *LinkInst) UnmarshalJSON(d []byte) error {
 ////////////////////////////////////////////////////////////////////////////////////////////////////////
 // Flow Instance Changes Serialization
 
+// MaskedChanges returns the JSON of the changes of the current step with the values of the
+// sensitive attributes and task outputs masked, the changes themselves keep the clear values
+// since they are used to restore and replay the instance
+func (inst *IndependentInstance) MaskedChanges() ([]byte, error) {
+
+	ict := inst.ChangeTracker
+	if ict == nil || ict.instChanges == nil {
+		return json.Marshal(ict)
+	}
+
+	masked := &InstanceChangeTracker{wiqChanges: ict.wiqChanges, instChanges: make(map[int]*InstanceChange, len(ict.instChanges))}
+
+	for id, ic := range ict.instChanges {
+		masked.instChanges[id] = inst.maskChange(ic)
+	}
+
+	return json.Marshal(masked)
+}
+
+// maskChange returns a copy of the change with the sensitive values masked, the attributes of
+// a sub flow that is no longer known are all masked
+func (inst *IndependentInstance) maskChange(ic *InstanceChange) *InstanceChange {
+
+	var flowDef *definition.Definition
+	if ic.SubFlowID == 0 {
+		flowDef = inst.flowDef
+	} else if subFlow, ok := inst.subFlows[ic.SubFlowID]; ok {
+		flowDef = subFlow.flowDef
+	}
+
+	masked := *ic
+
+	if len(ic.AttrChanges) > 0 {
+		masked.AttrChanges = make([]*AttributeChange, len(ic.AttrChanges))
+		for i, attrChange := range ic.AttrChanges {
+			value := attrChange.Attribute.Value()
+			if flowDef == nil || flowDef.IsSensitive(attrChange.Attribute.Name()) {
+				value = definition.MaskedValue
+			}
+			masked.AttrChanges[i] = &AttributeChange{SubFlowID: attrChange.SubFlowID, ChgType: attrChange.ChgType,
+				Attribute: data.NewAttribute(attrChange.Attribute.Name(), attrChange.Attribute.Type(), value)}
+		}
+	}
+
+	if len(ic.tiChanges) > 0 {
+		masked.tiChanges = make(map[string]*TaskInstChange, len(ic.tiChanges))
+		for id, tiChange := range ic.tiChanges {
+			if taskInst := tiChange.TaskInst; taskInst != nil && len(taskInst.outputs) > 0 {
+				// only the ID, status and outputs of the task are serialized with the change
+				tiChange = &TaskInstChange{ChgType: tiChange.ChgType, ID: tiChange.ID, TaskInst: &TaskInst{taskID: taskInst.taskID,
+					status: taskInst.status, outputs: taskInst.task.MaskValues(taskInst.outputs)}}
+			}
+			masked.tiChanges[id] = tiChange
+		}
+	}
+
+	return &masked
+}
+
 // MarshalJSON overrides the default MarshalJSON for InstanceChangeTracker
 func (ict *InstanceChangeTracker) MarshalJSON() ([]byte, error) {
 
@@ -443,7 +574,7 @@ func (ict *InstanceChangeTracker) MarshalJSON() ([]byte, error) {
 			attrs = masterChg.AttrChanges
 		}
 
-		if masterChg.tiChanges != nil {
+		if ok && masterChg.tiChanges != nil {
 			tdc = make([]*TaskInstChange, 0, len(masterChg.tiChanges))
 
 			for _, value := range masterChg.tiChanges {
@@ -453,7 +584,7 @@ func (ict *InstanceChangeTracker) MarshalJSON() ([]byte, error) {
 			tdc = nil
 		}
 
-		if masterChg.liChanges != nil {
+		if ok && masterChg.liChanges != nil {
 			ldc = make([]*LinkInstChange, 0, len(masterChg.liChanges))
 
 			for _, value := range masterChg.liChanges {
@@ -544,3 +675,66 @@ func (ic *InstanceChange) MarshalJSON() ([]byte, error) {
 		SfChange:    ic.SubFlowChg,
 	})
 }
+
+// UnmarshalJSON overrides the default UnmarshalJSON for InstanceChangeTracker, only the
+// current format of the changes is supported
+func (ict *InstanceChangeTracker) UnmarshalJSON(d []byte) error {
+	ser := &struct {
+		WqChanges   []*WorkItemQueueChange `json:"wqChanges"`
+		InstChanges []*InstanceChange      `json:"instChanges"`
+	}{}
+
+	if err := json.Unmarshal(d, ser); err != nil {
+		return err
+	}
+
+	ict.wiqChanges = nil
+	ict.instChanges = make(map[int]*InstanceChange, len(ser.InstChanges))
+
+	for _, wiChange := range ser.WqChanges {
+		ict.trackWorkItem(wiChange)
+	}
+
+	for _, ic := range ser.InstChanges {
+		ict.instChanges[ic.SubFlowID] = ic
+	}
+
+	return nil
+}
+
+// UnmarshalJSON overrides the default UnmarshalJSON for InstanceChange
+func (ic *InstanceChange) UnmarshalJSON(d []byte) error {
+	ser := &struct {
+		FlowID      int                `json:"flowId"`
+		Status      model.FlowStatus   `json:"status"`
+		AttrChanges []*AttributeChange `json:"attrs"`
+		TdChanges   []*TaskInstChange  `json:"tasks"`
+		LdChanges   []*LinkInstChange  `json:"links"`
+		SfChange    *SubFlowChange     `json:"subFlow"`
+	}{}
+
+	if err := json.Unmarshal(d, ser); err != nil {
+		return err
+	}
+
+	ic.SubFlowID = ser.FlowID
+	ic.Status = ser.Status
+	ic.AttrChanges = ser.AttrChanges
+	ic.SubFlowChg = ser.SfChange
+
+	if len(ser.TdChanges) > 0 {
+		ic.tiChanges = make(map[string]*TaskInstChange, len(ser.TdChanges))
+		for _, tdChange := range ser.TdChanges {
+			ic.tiChanges[tdChange.ID] = tdChange
+		}
+	}
+
+	if len(ser.LdChanges) > 0 {
+		ic.liChanges = make(map[int]*LinkInstChange, len(ser.LdChanges))
+		for _, ldChange := range ser.LdChanges {
+			ic.liChanges[ldChange.ID] = ldChange
+		}
+	}
+
+	return nil
+}
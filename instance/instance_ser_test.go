@@ -3,6 +3,7 @@ package instance
 import (
 	"encoding/json"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/qingcloudhx/core/support/log"
@@ -133,3 +134,87 @@ func TestChangeSerialization(t *testing.T) {
 //		log.Debugf("Changes: %s\n", string(json))
 //	}
 //}
+
+func TestSerialization_KeepsSensitive(t *testing.T) {
+
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(`{
+	  "name": "sensitive-flow",
+	  "model": "test",
+	  "metadata": { "input": [ { "name": "user", "type": "string" }, { "name": "password", "type": "string" } ] },
+	  "sensitive": [ "password" ],
+	  "tasks": [ { "id": "echo", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "hi" } } } ]
+	}`), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	inst, err := NewIndependentInstance("sensitive", "uri", def, log.RootLogger())
+	assert.Nil(t, err)
+	inst.Start(map[string]interface{}{"user": "bob", "password": "secret"})
+
+	// the snapshot is used to restore the instance, it keeps the clear values
+	snapshot, err := json.Marshal(inst)
+	assert.Nil(t, err)
+	assert.Contains(t, string(snapshot), "secret")
+
+	restored := &IndependentInstance{}
+	assert.Nil(t, json.Unmarshal(snapshot, restored))
+	assert.Equal(t, "secret", restored.attrs["password"])
+
+	assert.Nil(t, inst.SetValue("user", "alice"))
+	assert.Nil(t, inst.SetValue("password", "changed"))
+
+	changes, err := json.Marshal(inst.ChangeTracker)
+	assert.Nil(t, err)
+	assert.Contains(t, string(changes), "changed")
+
+	masked, err := inst.MaskedChanges()
+	assert.Nil(t, err)
+	assert.NotContains(t, string(masked), "changed")
+	assert.Contains(t, string(masked), "alice")
+	assert.Contains(t, string(masked), definition.MaskedValue)
+}
+
+func TestSerialization_MasksSensitiveTaskOutputs(t *testing.T) {
+
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(`{
+	  "name": "sensitive-task-flow",
+	  "model": "test",
+	  "tasks": [ { "id": "echo", "sensitive": [ "message" ], "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "secret" } } } ]
+	}`), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	inst, err := NewIndependentInstance("sensitive", "uri", def, log.RootLogger())
+	assert.Nil(t, err)
+	inst.Start(nil)
+
+	codec, err := NewCodec(CodecBinary, "")
+	assert.Nil(t, err)
+
+	recorded := false
+	for inst.Status() < model.FlowStatusCompleted && inst.DoStep() {
+
+		changes, err := json.Marshal(inst.ChangeTracker)
+		assert.Nil(t, err)
+
+		b, err := codec.EncodeStep(inst.ChangeTracker)
+		assert.Nil(t, err)
+
+		if strings.Contains(string(changes), `"outputs"`) {
+			recorded = true
+			assert.Contains(t, string(changes), "secret")
+			assert.Contains(t, string(b), "secret")
+		}
+
+		masked, err := inst.MaskedChanges()
+		assert.Nil(t, err)
+		assert.NotContains(t, string(masked), "secret")
+	}
+	assert.True(t, recorded)
+}
@@ -3,14 +3,27 @@ package instance
 import (
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/qingcloudhx/core/activity"
 	"github.com/qingcloudhx/core/support"
 	"github.com/qingcloudhx/core/support/log"
 	"github.com/qingcloudhx/flow/definition"
 	"github.com/qingcloudhx/flow/model"
 	flowsupport "github.com/qingcloudhx/flow/support"
+	"github.com/qingcloudhx/flow/support/metrics"
+	"github.com/qingcloudhx/flow/support/trace"
 )
 
+// metricsCollector collects the metrics of the executions of the tasks, nil if disabled
+var metricsCollector *metrics.Collector
+
+// SetMetricsCollector sets the collector of the metrics of the executions of the tasks,
+// nil disables their collection
+func SetMetricsCollector(collector *metrics.Collector) {
+	metricsCollector = collector
+}
+
 type IndependentInstance struct {
 	*Instance
 
@@ -26,6 +39,15 @@ type IndependentInstance struct {
 	flowModel   *model.FlowModel
 	patch       *flowsupport.Patch
 	interceptor *flowsupport.Interceptor
+	listener    ExecListener
+
+	// interceptorExecs counts the executions of the tasks intercepted by each interceptor
+	interceptorExecs map[*flowsupport.TaskInterceptor]int
+
+	faults *flowsupport.FaultInjection
+
+	// traceParent is the parent of the span of the instance
+	traceParent trace.SpanContext
 
 	subFlows map[int]*Instance
 }
@@ -45,10 +67,12 @@ func NewIndependentInstance(instanceID string, flowURI string, flow *definition.
 	if err != nil {
 		return nil, err
 	}
+	inst.releaseDef = flow.Acquire()
 	inst.logger = logger
 
 	inst.status = model.FlowStatusNotStarted
 	inst.ChangeTracker = NewInstanceChangeTracker()
+	inst.trackingChanges = true
 
 	inst.taskInsts = make(map[string]*TaskInst)
 	inst.linkInsts = make(map[int]*LinkInst)
@@ -67,6 +91,7 @@ func (inst *IndependentInstance) newEmbeddedInstance(taskInst *TaskInst, flowURI
 	embeddedInst.master = inst
 	embeddedInst.host = taskInst
 	embeddedInst.flowDef = flow
+	embeddedInst.releaseDef = flow.Acquire()
 	embeddedInst.status = model.FlowStatusNotStarted
 	embeddedInst.taskInsts = make(map[string]*TaskInst)
 	embeddedInst.linkInsts = make(map[int]*LinkInst)
@@ -78,7 +103,7 @@ func (inst *IndependentInstance) newEmbeddedInstance(taskInst *TaskInst, flowURI
 	}
 	inst.subFlows[embeddedInst.subFlowId] = embeddedInst
 
-	inst.ChangeTracker.SubFlowChange(taskInst.flowInst.subFlowId, CtAdd, embeddedInst.subFlowId, "")
+	inst.ChangeTracker.SubFlowChange(taskInst.flowInst.subFlowId, CtAdd, embeddedInst.subFlowId, "", flowURI)
 
 	return embeddedInst
 }
@@ -167,6 +192,27 @@ func (inst *IndependentInstance) StepID() int {
 	return inst.stepID
 }
 
+// nextWorkItem returns the WorkItem the next step will execute, nil if there is no work
+func (inst *IndependentInstance) nextWorkItem() *WorkItem {
+
+	if e := inst.workItemQueue.List.Front(); e != nil {
+		workItem, _ := e.Value.(*WorkItem)
+		return workItem
+	}
+
+	return nil
+}
+
+// nextTaskID returns the ID of the task the next step will execute, empty if there is no work
+func (inst *IndependentInstance) nextTaskID() string {
+
+	if workItem := inst.nextWorkItem(); workItem != nil {
+		return workItem.TaskID
+	}
+
+	return ""
+}
+
 func (inst *IndependentInstance) DoStep() bool {
 
 	hasNext := false
@@ -245,6 +291,16 @@ func (inst *IndependentInstance) execTask(behavior model.TaskBehavior, taskInst
 
 	var evalResult model.EvalResult
 
+	if metricsCollector != nil && taskInst.execStart.IsZero() {
+		taskInst.execStart = time.Now()
+	}
+
+	taskInst.fault = nil
+
+	if inst.faults != nil && taskInst.status != model.TaskStatusWaiting && taskInst.status != model.TaskStatusSkipped {
+		inst.injectFault(taskInst)
+	}
+
 	if taskInst.status == model.TaskStatusWaiting {
 
 		evalResult, err = behavior.PostEval(taskInst)
@@ -253,6 +309,14 @@ func (inst *IndependentInstance) execTask(behavior model.TaskBehavior, taskInst
 		evalResult, err = behavior.Eval(taskInst)
 	}
 
+	if metricsCollector != nil {
+		collectTaskMetrics(taskInst, evalResult, err)
+	}
+
+	if err == nil && evalResult == model.EvalRepeat && taskInst.task.TypeID() != iteratorTaskType {
+		taskInst.retries++
+	}
+
 	if err != nil {
 		//taskInst.returnError = err
 		inst.handleTaskError(behavior, taskInst, err)
@@ -277,6 +341,44 @@ func (inst *IndependentInstance) execTask(behavior model.TaskBehavior, taskInst
 	}
 }
 
+// collectTaskMetrics records the metrics of the execution of the task once it is finished,
+// skipped tasks are not recorded
+func collectTaskMetrics(taskInst *TaskInst, evalResult model.EvalResult, err error) {
+
+	finished := err != nil || evalResult == model.EvalFail || (evalResult == model.EvalDone && taskInst.status != model.TaskStatusSkipped)
+	if !finished || taskInst.execStart.IsZero() {
+		return
+	}
+
+	task := taskInst.task
+	metricsCollector.TaskFinished(taskInst.flowInst.Name(), task.ID(), time.Since(taskInst.execStart), task.TypeID() == iteratorTaskType, taskInst.iteration)
+
+	taskInst.execStart = time.Time{}
+}
+
+// injectFault determines the fault to inject in the execution of the task, a panic is raised
+// immediately while the other faults are applied when the task's activity is evaluated
+func (inst *IndependentInstance) injectFault(taskInst *TaskInst) {
+
+	ref := ""
+	if taskInst.HasActivity() {
+		ref = activity.GetRef(taskInst.task.ActivityConfig().Activity)
+	}
+
+	fault := inst.faults.Inject(taskInst.task.ID(), ref)
+	if fault == nil {
+		return
+	}
+
+	inst.logger.Debugf("Injecting '%s' fault in task '%s'", fault.Type, taskInst.task.ID())
+
+	if fault.Type == flowsupport.FaultPanic {
+		panic("injected fault: " + fault.Message)
+	}
+
+	taskInst.fault = fault
+}
+
 // handleTaskDone handles the completion of a task in the Flow Instance
 func (inst *IndependentInstance) handleTaskDone(taskBehavior model.TaskBehavior, taskInst *TaskInst) {
 
@@ -508,31 +610,138 @@ func (inst *IndependentInstance) enterTasks(activeInst *Instance, taskEntries []
 //// Restart indicates that this FlowInstance was restarted
 func (inst *IndependentInstance) Restart(id string, manager *flowsupport.FlowManager) error {
 	inst.id = id
-	var err error
-	inst.flowDef, err = manager.GetFlow(inst.flowURI)
-
+	flowDef, release, err := manager.AcquireFlow(inst.flowURI)
 	if err != nil {
 		return err
 	}
-	if inst.flowDef == nil {
+	defer release()
+
+	if flowDef == nil {
 		return errors.New("unable to resolve flow: " + inst.flowURI)
 	}
+	inst.flowDef = flowDef
 
 	inst.flowModel, err = getFlowModel(inst.flowDef)
 	if err != nil {
 		return err
 	}
+	if inst.releaseDef != nil {
+		inst.releaseDef()
+		inst.releaseDef = nil
+	}
+	if inst.status < model.FlowStatusCompleted {
+		inst.releaseDef = inst.flowDef.Acquire()
+	}
 	inst.master = inst
+
+	// the logger is not serialized
+	if inst.logger == nil {
+		inst.logger = log.RootLogger()
+	}
+
 	inst.init(inst.Instance)
 
 	return nil
 }
 
+// RestartFrom rebuilds the state of a restarted instance so that its execution continues from
+// the specified task.  The state of the task and of the tasks and links downstream of it is
+// reset, the pending work of those tasks is discarded and the task is scheduled for evaluation,
+// optionally with some of its inputs overridden.  Sub-flows of the instance are not affected.
+func (inst *IndependentInstance) RestartFrom(taskID string, inputs map[string]interface{}) error {
+
+	task := inst.flowDef.GetTask(taskID)
+	if task == nil {
+		return fmt.Errorf("task '%s' not found in flow '%s'", taskID, inst.flowDef.Name())
+	}
+
+	// the task and all the tasks reachable from it
+	downstream := map[string]*definition.Task{task.ID(): task}
+	toVisit := []*definition.Task{task}
+
+	for len(toVisit) > 0 {
+		current := toVisit[0]
+		toVisit = toVisit[1:]
+
+		for _, link := range current.ToLinks() {
+			if next := link.ToTask(); next != nil && downstream[next.ID()] == nil {
+				downstream[next.ID()] = next
+				toVisit = append(toVisit, next)
+			}
+		}
+	}
+
+	// the error handler is re-entered if the restarted execution fails again
+	if eh := inst.flowDef.GetErrorHandler(); eh != nil {
+		for _, ehTask := range eh.Tasks() {
+			downstream[ehTask.ID()] = ehTask
+		}
+	}
+
+	for id, t := range downstream {
+		if _, exists := inst.taskInsts[id]; exists {
+			delete(inst.taskInsts, id)
+			inst.ChangeTracker.trackTaskData(inst.subFlowId, &TaskInstChange{ChgType: CtDel, ID: id})
+		}
+
+		for _, link := range t.ToLinks() {
+			if _, exists := inst.linkInsts[link.ID()]; exists {
+				delete(inst.linkInsts, link.ID())
+				inst.ChangeTracker.trackLinkData(inst.subFlowId, &LinkInstChange{ChgType: CtDel, ID: link.ID()})
+			}
+		}
+	}
+
+	// rebuild the work queue, keeping the pending work of the other tasks
+	queue := support.NewSyncQueue()
+
+	for e := inst.workItemQueue.List.Front(); e != nil; e = e.Next() {
+		workItem, _ := e.Value.(*WorkItem)
+		if workItem.SubFlowID == 0 && downstream[workItem.TaskID] != nil {
+			inst.ChangeTracker.trackWorkItem(&WorkItemQueueChange{ChgType: CtDel, ID: workItem.ID, WorkItem: workItem})
+			continue
+		}
+		queue.Push(workItem)
+
+		// the counter is not serialized, new work items must not reuse the IDs of the pending ones
+		if workItem.ID > inst.wiCounter {
+			inst.wiCounter = workItem.ID
+		}
+	}
+
+	inst.workItemQueue = queue
+
+	// a completed or failed instance released its definition, it must be held while it runs again
+	if inst.releaseDef == nil {
+		inst.releaseDef = inst.flowDef.Acquire()
+	}
+
+	inst.isHandlingError = false
+	inst.returnError = nil
+	inst.SetStatus(model.FlowStatusActive)
+
+	taskInst, _ := inst.FindOrCreateTaskData(task)
+	taskInst.inputOverrides = inputs
+	taskInst.SetStatus(model.TaskStatusReady)
+
+	err := applySettingsMapper(taskInst)
+	if err != nil {
+		return err
+	}
+
+	inst.scheduleEval(taskInst)
+
+	return nil
+}
+
 func (inst *IndependentInstance) init(flowInst *Instance) {
 
 	for _, v := range flowInst.taskInsts {
 		v.flowInst = flowInst
 		v.task = flowInst.flowDef.GetTask(v.taskID)
+		if v.logger == nil && v.task != nil {
+			v.logger = v.task.ActivityConfig().Logger
+		}
 	}
 
 	for _, v := range flowInst.linkInsts {
@@ -0,0 +1,215 @@
+package instance
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"github.com/qingcloudhx/core/activity"
+	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/model"
+	"github.com/qingcloudhx/flow/support"
+	"github.com/qingcloudhx/flow/support/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+const restartDefJSON = `
+{
+  "name": "restart-flow",
+  "model": "test",
+  "metadata": {
+    "output": [ { "name": "out", "type": "any" } ]
+  },
+  "tasks": [
+    { "id": "echo1", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "first" } } },
+    { "id": "echo2", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "second" }, "output": { "out": "=$.message" } } },
+    { "id": "echo3", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "third" } } }
+  ],
+  "links": [
+    { "from": "echo1", "to": "echo2" },
+    { "from": "echo2", "to": "echo3" }
+  ]
+}
+`
+
+type restartTestProvider struct {
+}
+
+func (p *restartTestProvider) GetFlow(flowURI string) (*definition.DefinitionRep, error) {
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(restartDefJSON), defRep)
+	return defRep, err
+}
+
+// doneListener records the tasks that completed
+type doneListener struct {
+	done []string
+}
+
+func (l *doneListener) TaskStatusChanged(inst *IndependentInstance, taskInst *TaskInst) {
+	if taskInst.Status() == model.TaskStatusDone {
+		l.done = append(l.done, taskInst.Task().ID())
+	}
+}
+
+func (l *doneListener) LinkStatusChanged(inst *IndependentInstance, linkInst *LinkInst) {
+}
+
+func runToCompletion(inst *IndependentInstance) {
+	hasWork := true
+	for hasWork && inst.Status() < model.FlowStatusCompleted {
+		hasWork = inst.DoStep()
+	}
+}
+
+func TestIndependentInstance_RestartFrom(t *testing.T) {
+
+	manager := support.NewFlowManager(&restartTestProvider{})
+	def, err := manager.GetFlow("res://flow:restart")
+	assert.Nil(t, err)
+
+	inst, err := NewIndependentInstance("failed", "res://flow:restart", def, log.RootLogger())
+	assert.Nil(t, err)
+
+	// echo2 fails the first time it is executed
+	interceptor := &support.Interceptor{TaskInterceptors: []*support.TaskInterceptor{
+		{ID: "echo2", Skip: true, Error: &support.InterceptorError{Message: "bad data"}}}}
+	ApplyExecOptions(inst, &ExecOptions{Interceptor: interceptor})
+
+	inst.Start(nil)
+	runToCompletion(inst)
+	assert.Equal(t, model.FlowStatusFailed, inst.Status())
+
+	snapshot, err := json.Marshal(inst)
+	assert.Nil(t, err)
+
+	restarted := &IndependentInstance{}
+	err = json.Unmarshal(snapshot, restarted)
+	assert.Nil(t, err)
+
+	err = restarted.Restart("restarted", manager)
+	assert.Nil(t, err)
+
+	err = restarted.RestartFrom("echo2", map[string]interface{}{"message": "fixed"})
+	assert.Nil(t, err)
+	assert.Equal(t, model.FlowStatusActive, restarted.Status())
+	assert.Equal(t, "echo2", restarted.nextTaskID())
+
+	// the failed instance was restored as failed, its definition is held again while it runs
+	assert.True(t, restarted.flowDef.InUse())
+
+	listener := &doneListener{}
+	ApplyExecOptions(restarted, &ExecOptions{Listener: listener})
+
+	runToCompletion(restarted)
+	assert.Equal(t, model.FlowStatusCompleted, restarted.Status())
+	assert.Equal(t, []string{"echo2", "echo3"}, listener.done)
+	assert.False(t, restarted.flowDef.InUse())
+
+	outputs, err := restarted.GetReturnData()
+	assert.Nil(t, err)
+	assert.Equal(t, "fixed", outputs["out"])
+}
+
+func TestIndependentInstance_RestartFrom_UnknownTask(t *testing.T) {
+
+	manager := support.NewFlowManager(&restartTestProvider{})
+	def, err := manager.GetFlow("res://flow:restart")
+	assert.Nil(t, err)
+
+	inst, err := NewIndependentInstance("unknown", "res://flow:restart", def, log.RootLogger())
+	assert.Nil(t, err)
+
+	err = inst.RestartFrom("echo4", nil)
+	assert.NotNil(t, err)
+}
+
+func TestIndependentInstance_Metrics(t *testing.T) {
+
+	collector := metrics.NewCollector()
+	SetMetricsCollector(collector)
+	defer SetMetricsCollector(nil)
+
+	manager := support.NewFlowManager(&restartTestProvider{})
+	def, err := manager.GetFlow("res://flow:restart")
+	assert.Nil(t, err)
+
+	inst, err := NewIndependentInstance("metrics", "res://flow:restart", def, log.RootLogger())
+	assert.Nil(t, err)
+
+	inst.Start(nil)
+	runToCompletion(inst)
+
+	fs := collector.Snapshot().Flows["restart-flow"]
+	assert.NotNil(t, fs)
+	assert.Len(t, fs.Tasks, 3)
+	assert.Equal(t, uint64(1), fs.Tasks["echo2"].Duration.Count)
+	assert.Nil(t, fs.Tasks["echo2"].Iterations)
+}
+
+func init() {
+	_ = activity.Register(&cleanupTestActivity{}, func(ctx activity.InitContext) (activity.Activity, error) {
+		return &cleanupTestActivity{}, nil
+	})
+}
+
+var cleanupTestCount int32
+
+// cleanupTestActivity counts the times it is cleaned up
+type cleanupTestActivity struct {
+}
+
+func (a *cleanupTestActivity) Metadata() *activity.Metadata {
+	return activity.ToMetadata()
+}
+
+func (a *cleanupTestActivity) Eval(ctx activity.Context) (done bool, err error) {
+	return true, nil
+}
+
+func (a *cleanupTestActivity) Cleanup() error {
+	atomic.AddInt32(&cleanupTestCount, 1)
+	return nil
+}
+
+type cleanupTestProvider struct {
+}
+
+func (p *cleanupTestProvider) GetFlow(flowURI string) (*definition.DefinitionRep, error) {
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(`{ "name": "cleanup-flow", "model": "test",
+	  "tasks": [ { "id": "cleanup", "activity": { "ref": "github.com/qingcloudhx/flow/instance" } } ] }`), defRep)
+	return defRep, err
+}
+
+func TestIndependentInstance_EvictedDefinition(t *testing.T) {
+
+	manager := support.NewFlowManagerWithCache(&cleanupTestProvider{}, &support.CacheConfig{MaxSize: 1})
+	atomic.StoreInt32(&cleanupTestCount, 0)
+
+	def, err := manager.GetFlow("flow1")
+	assert.Nil(t, err)
+
+	inst, err := NewIndependentInstance("evicted", "flow1", def, log.RootLogger())
+	assert.Nil(t, err)
+	inst.Start(nil)
+
+	// loading another flow evicts the definition, it is still used by the instance
+	_, err = manager.GetFlow("flow2")
+	assert.Nil(t, err)
+	assert.True(t, def.InUse())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&cleanupTestCount))
+
+	runToCompletion(inst)
+	assert.Equal(t, model.FlowStatusCompleted, inst.Status())
+
+	// the definition is cleaned up once the instance is done
+	assert.False(t, def.InUse())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cleanupTestCount))
+
+	// evicting an unused definition cleans it up right away
+	_, err = manager.GetFlow("flow3")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&cleanupTestCount))
+}
@@ -0,0 +1,139 @@
+package instance
+
+import (
+	"time"
+
+	coreevent "github.com/qingcloudhx/core/engine/event"
+	"github.com/qingcloudhx/flow/support/event"
+)
+
+// iteratorTaskType is the type of the tasks that evaluate their activity once per iteration
+const iteratorTaskType = "iterator"
+
+type iterationEvent struct {
+	time, startTime            time.Time
+	err                        error
+	key                        interface{}
+	status                     event.Status
+	flowName, flowId, name, id string
+	subFlowId, stepId          int
+	iteration                  int
+}
+
+// Returns flow name
+func (ie *iterationEvent) FlowName() string {
+	return ie.flowName
+}
+
+// Returns flow ID
+func (ie *iterationEvent) FlowID() string {
+	return ie.flowId
+}
+
+// In case of a task of a subflow, returns the ID of the subflow, 0 otherwise
+func (ie *iterationEvent) SubFlowID() int {
+	return ie.subFlowId
+}
+
+// Returns the ID of the step of the instance during which the event occurred
+func (ie *iterationEvent) StepID() int {
+	return ie.stepId
+}
+
+// Returns task name
+func (ie *iterationEvent) TaskName() string {
+	return ie.name
+}
+
+// Returns task ID
+func (ie *iterationEvent) TaskID() string {
+	return ie.id
+}
+
+// Returns the index of the iteration
+func (ie *iterationEvent) Iteration() int {
+	return ie.iteration
+}
+
+// Returns the key of the iteration, the index or the key of the iterated element
+func (ie *iterationEvent) IterationKey() interface{} {
+	return ie.key
+}
+
+// Returns STARTED when the iteration starts, COMPLETED or FAILED when it ends
+func (ie *iterationEvent) IterationStatus() event.Status {
+	return ie.status
+}
+
+// Returns event time
+func (ie *iterationEvent) Time() time.Time {
+	return ie.time
+}
+
+// Returns the time the iteration started
+func (ie *iterationEvent) StartTime() time.Time {
+	return ie.startTime
+}
+
+// Returns the time elapsed between the start of the iteration and the event
+func (ie *iterationEvent) Duration() time.Duration {
+	return ie.time.Sub(ie.startTime)
+}
+
+// Returns error for failed iteration
+func (ie *iterationEvent) IterationError() error {
+	return ie.err
+}
+
+// startIteration marks the start of the current iteration of the task
+func (ti *TaskInst) startIteration() {
+
+	ti.iterationStart = time.Now()
+	ti.iterating = true
+
+	postIterationEvent(ti, event.STARTED, nil)
+}
+
+// endIteration marks the end of the current iteration of the task, the following
+// iteration gets the next index
+func (ti *TaskInst) endIteration(err error) {
+
+	if err != nil {
+		postIterationEvent(ti, event.FAILED, err)
+	} else {
+		postIterationEvent(ti, event.COMPLETED, nil)
+	}
+
+	if tracer != nil {
+		traceIteration(ti, err)
+	}
+
+	ti.iterating = false
+	ti.iteration++
+}
+
+func postIterationEvent(ti *TaskInst, status event.Status, err error) {
+
+	if coreevent.HasListener(event.IterationEventType) {
+		ie := &iterationEvent{}
+		ie.time = time.Now()
+		ie.startTime = ti.iterationStart
+		ie.status = status
+		ie.err = err
+		ie.flowName = ti.flowInst.Name()
+		ie.flowId = ti.flowInst.ID()
+		ie.subFlowId = ti.flowInst.subFlowId
+		ie.stepId = ti.flowInst.master.StepID()
+		ie.name = ti.task.Name()
+		ie.id = ti.task.ID()
+		ie.iteration = ti.iteration
+
+		if iteration, ok := ti.GetWorkingData("iteration"); ok {
+			if values, ok := iteration.(map[string]interface{}); ok {
+				ie.key = values["key"]
+			}
+		}
+
+		coreevent.Post(event.IterationEventType, ie)
+	}
+}
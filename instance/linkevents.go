@@ -0,0 +1,92 @@
+package instance
+
+import (
+	"time"
+
+	coreevent "github.com/qingcloudhx/core/engine/event"
+	"github.com/qingcloudhx/flow/model"
+	"github.com/qingcloudhx/flow/support/event"
+)
+
+type linkEvent struct {
+	time                      time.Time
+	status                    event.Status
+	flowName, flowId          string
+	fromTask, toTask          string
+	subFlowId, stepId, linkId int
+}
+
+// Returns flow name
+func (le *linkEvent) FlowName() string {
+	return le.flowName
+}
+
+// Returns flow ID
+func (le *linkEvent) FlowID() string {
+	return le.flowId
+}
+
+// In case of a link of a subflow, returns the ID of the subflow, 0 otherwise
+func (le *linkEvent) SubFlowID() int {
+	return le.subFlowId
+}
+
+// Returns the ID of the step of the instance during which the event occurred
+func (le *linkEvent) StepID() int {
+	return le.stepId
+}
+
+// Returns link ID
+func (le *linkEvent) LinkID() int {
+	return le.linkId
+}
+
+// Returns the ID of the task the link starts from
+func (le *linkEvent) FromTask() string {
+	return le.fromTask
+}
+
+// Returns the ID of the task the link leads to
+func (le *linkEvent) ToTask() string {
+	return le.toTask
+}
+
+// Returns the result of the evaluation of the link
+func (le *linkEvent) LinkStatus() event.Status {
+	return le.status
+}
+
+// Returns event time
+func (le *linkEvent) Time() time.Time {
+	return le.time
+}
+
+func convertLinkStatus(code model.LinkStatus) event.Status {
+	switch code {
+	case model.LinkStatusTrue:
+		return event.TRUE
+	case model.LinkStatusFalse:
+		return event.FALSE
+	case model.LinkStatusSkipped:
+		return event.SKIPPED
+	}
+	return event.UNKNOWN
+}
+
+func postLinkEvent(linkInst *LinkInst) {
+
+	if coreevent.HasListener(event.LinkEventType) {
+		le := &linkEvent{}
+		le.time = time.Now()
+		le.status = convertLinkStatus(linkInst.Status())
+		le.flowName = linkInst.flowInst.Name()
+		le.flowId = linkInst.flowInst.ID()
+		le.subFlowId = linkInst.flowInst.subFlowId
+		le.stepId = linkInst.flowInst.master.StepID()
+		le.linkId = linkInst.link.ID()
+		le.fromTask = linkInst.link.FromTask().ID()
+		le.toTask = linkInst.link.ToTask().ID()
+
+		coreevent.Post(event.LinkEventType, le)
+	}
+}
@@ -22,6 +22,7 @@ func NewLinkInst(inst *Instance, link *definition.Link) *LinkInst {
 
 	linkInst.flowInst = inst
 	linkInst.link = link
+	linkInst.linkID = link.ID()
 
 	return &linkInst
 }
@@ -35,6 +36,10 @@ func (ld *LinkInst) Status() model.LinkStatus {
 func (ld *LinkInst) SetStatus(status model.LinkStatus) {
 	ld.status = status
 	ld.flowInst.master.ChangeTracker.trackLinkData(ld.flowInst.subFlowId, &LinkInstChange{ChgType: CtUpd, ID: ld.link.ID(), LinkInst: ld})
+	if listener := ld.flowInst.master.listener; listener != nil {
+		listener.LinkStatusChanged(ld.flowInst.master, ld)
+	}
+	postLinkEvent(ld)
 }
 
 // Link returns the Link associated with ld context
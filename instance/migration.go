@@ -0,0 +1,162 @@
+package instance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Flow Instance State Migration
+//
+// The serialized state of an IndependentInstance contains the version of its format.  When the
+// format changes StateVersion is incremented and a migration from the previous version is
+// registered, so that the state of instances serialized by older versions of the library (ex.
+// waiting instances) can still be restored.  State without a version is version 0.
+
+// StateVersion is the version of the format of the serialized state of an instance
+const StateVersion = 1
+
+// StateMigration upgrades the JSON representation of the state of an instance from the
+// version it was registered for to the next version, the numbers of the state are decoded
+// as json.Number so that they are migrated without loss of precision
+type StateMigration func(state map[string]interface{}) error
+
+var (
+	migrationsMu sync.RWMutex
+	migrations   = make(map[int]StateMigration)
+)
+
+func init() {
+	RegisterStateMigration(0, migrateRootTaskEnv)
+}
+
+// RegisterStateMigration registers the migration of the state from the specified version
+// to the next version
+func RegisterStateMigration(fromVersion int, migration StateMigration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	migrations[fromVersion] = migration
+}
+
+// UnsupportedStateVersionError is returned when the state of an instance was serialized
+// using a newer version of the format than the ones supported
+type UnsupportedStateVersionError struct {
+	Version int
+}
+
+func (e *UnsupportedStateVersionError) Error() string {
+	return fmt.Sprintf("unsupported instance state version %d, the latest supported version is %d", e.Version, StateVersion)
+}
+
+// stateVersion returns the version of the serialized state
+func stateVersion(d []byte) (int, error) {
+
+	ser := &struct {
+		Version int `json:"version"`
+	}{}
+
+	if err := json.Unmarshal(d, ser); err != nil {
+		return 0, err
+	}
+
+	return ser.Version, nil
+}
+
+// migrateState upgrades the serialized state to the current version
+func migrateState(d []byte) ([]byte, error) {
+
+	version, err := stateVersion(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > StateVersion {
+		return nil, &UnsupportedStateVersionError{Version: version}
+	}
+
+	if version == StateVersion {
+		return d, nil
+	}
+
+	var state map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(d))
+	decoder.UseNumber()
+	if err := decoder.Decode(&state); err != nil {
+		return nil, err
+	}
+
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+
+	for ; version < StateVersion; version++ {
+		migration, exists := migrations[version]
+		if !exists {
+			return nil, fmt.Errorf("no migration registered for instance state version %d", version)
+		}
+
+		if err := migration(state); err != nil {
+			return nil, fmt.Errorf("unable to migrate instance state from version %d: %v", version, err)
+		}
+
+		state["version"] = version + 1
+	}
+
+	return json.Marshal(state)
+}
+
+// migrateRootTaskEnv migrates the unversioned state, whose task and link statuses could
+// also be stored in the "rootTaskEnv", those statuses override the ones of the tasks and
+// links of the state
+func migrateRootTaskEnv(state map[string]interface{}) error {
+
+	env, ok := state["rootTaskEnv"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	delete(state, "rootTaskEnv")
+
+	tasks, _ := state["tasks"].([]interface{})
+	taskDatas, _ := env["taskDatas"].([]interface{})
+	for _, value := range taskDatas {
+		td, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid task data '%v'", value)
+		}
+
+		tasks = putEntry(tasks, "taskId", map[string]interface{}{"taskId": td["taskId"], "status": td["state"]})
+	}
+
+	links, _ := state["links"].([]interface{})
+	linkDatas, _ := env["linkDatas"].([]interface{})
+	for _, value := range linkDatas {
+		ld, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid link data '%v'", value)
+		}
+
+		links = putEntry(links, "linkId", map[string]interface{}{"linkId": ld["linkId"], "status": ld["state"]})
+	}
+
+	state["tasks"] = tasks
+	state["links"] = links
+
+	return nil
+}
+
+// putEntry replaces the entry with the same id in the entries or appends it
+func putEntry(entries []interface{}, idKey string, entry map[string]interface{}) []interface{} {
+
+	for i, value := range entries {
+		if e, ok := value.(map[string]interface{}); ok && fmt.Sprint(e[idKey]) == fmt.Sprint(entry[idKey]) {
+			entries[i] = entry
+			return entries
+		}
+	}
+
+	return append(entries, entry)
+}
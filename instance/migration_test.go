@@ -0,0 +1,111 @@
+package instance
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/qingcloudhx/flow/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const unversionedStateJSON = `
+{
+  "id": "old",
+  "status": 100,
+  "flowUri": "res://flow:registry",
+  "attrs": [],
+  "workQueue": [ { "id": 1, "taskID": "log2", "subFlowId": 0 } ],
+  "rootTaskEnv": {
+    "taskDatas": [ { "state": 40, "taskId": "log1" }, { "state": 20, "taskId": "log2" } ],
+    "linkDatas": [ { "state": 2, "linkId": 0 } ]
+  }
+}
+`
+
+func TestUnmarshal_CurrentVersion(t *testing.T) {
+
+	inst := newRegistryTestInstance(t, "current")
+	inst.Start(nil)
+	inst.DoStep()
+
+	b, err := json.Marshal(inst)
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), `"version":1`)
+
+	restored := &IndependentInstance{}
+	err = json.Unmarshal(b, restored)
+	assert.Nil(t, err)
+	assert.Len(t, restored.taskInsts, len(inst.taskInsts))
+}
+
+func TestUnmarshal_MigratesUnversioned(t *testing.T) {
+
+	inst := &IndependentInstance{}
+	err := json.Unmarshal([]byte(unversionedStateJSON), inst)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "old", inst.ID())
+	assert.Equal(t, model.TaskStatusDone, inst.taskInsts["log1"].status)
+	assert.Equal(t, model.TaskStatusReady, inst.taskInsts["log2"].status)
+	assert.Equal(t, model.LinkStatusTrue, inst.linkInsts[0].status)
+	assert.Equal(t, 1, inst.workItemQueue.List.Len())
+}
+
+func TestUnmarshal_FutureVersion(t *testing.T) {
+
+	inst := &IndependentInstance{}
+	err := json.Unmarshal([]byte(`{"version": 99, "id": "new"}`), inst)
+	assert.NotNil(t, err)
+
+	_, ok := err.(*UnsupportedStateVersionError)
+	assert.True(t, ok)
+}
+
+func TestMigrateState_Chain(t *testing.T) {
+
+	var applied []int
+
+	RegisterStateMigration(0, func(state map[string]interface{}) error {
+		applied = append(applied, 0)
+		return migrateRootTaskEnv(state)
+	})
+	defer RegisterStateMigration(0, migrateRootTaskEnv)
+
+	b, err := migrateState([]byte(unversionedStateJSON))
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0}, applied)
+
+	version, err := stateVersion(b)
+	assert.Nil(t, err)
+	assert.Equal(t, StateVersion, version)
+	assert.NotContains(t, string(b), "rootTaskEnv")
+}
+
+func TestUnmarshal_RootTaskEnvOverrides(t *testing.T) {
+
+	inst := &IndependentInstance{}
+	err := json.Unmarshal([]byte(`{
+	  "id": "old",
+	  "status": 100,
+	  "tasks": [ { "taskId": "log1", "status": 20 }, { "taskId": "log3", "status": 40 } ],
+	  "links": [ { "linkId": 0, "status": 1 } ],
+	  "rootTaskEnv": {
+	    "taskDatas": [ { "state": 40, "taskId": "log1" }, { "state": 20, "taskId": "log2" } ],
+	    "linkDatas": [ { "state": 2, "linkId": 0 } ]
+	  }
+	}`), inst)
+	assert.Nil(t, err)
+
+	// as before the migrations, the statuses of the rootTaskEnv win
+	assert.Equal(t, model.TaskStatusDone, inst.taskInsts["log1"].status)
+	assert.Equal(t, model.TaskStatusReady, inst.taskInsts["log2"].status)
+	assert.Equal(t, model.TaskStatusDone, inst.taskInsts["log3"].status)
+	assert.Equal(t, model.LinkStatusTrue, inst.linkInsts[0].status)
+}
+
+func TestMigrateState_KeepsPrecision(t *testing.T) {
+
+	b, err := migrateState([]byte(`{ "id": "old", "attrs": [ { "name": "big", "type": "int64", "value": 9007199254740993 } ] }`))
+	assert.Nil(t, err)
+	assert.Contains(t, string(b), "9007199254740993")
+}
@@ -3,7 +3,9 @@ package instance
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/qingcloudhx/core/support"
@@ -21,24 +23,37 @@ type StateRecorder interface {
 	RecordStep(instance *IndependentInstance)
 }
 
+// Headers identifying the instance and step of a snapshot or step posted using a non JSON codec
+const (
+	HeaderFlowID  = "X-Flow-ID"
+	HeaderStepID  = "X-Step-ID"
+	HeaderStatus  = "X-Flow-Status"
+	HeaderFlowURI = "X-Flow-URI"
+)
+
 // RemoteStateRecorder is an implementation of StateRecorder service
 // that can access flows via URI
 type RemoteStateRecorder struct {
 	host    string
 	enabled bool
+	codec   Codec
 	logger  log.Logger
 }
 
-// NewRemoteStateRecorder creates a new RemoteStateRecorder
-func NewRemoteStateRecorder(config *support.ServiceConfig) *RemoteStateRecorder {
+// NewRemoteStateRecorder creates a new RemoteStateRecorder, an error is returned if the
+// settings are invalid, ex. an unknown codec
+func NewRemoteStateRecorder(config *support.ServiceConfig) (*RemoteStateRecorder, error) {
 
 	recorder := &RemoteStateRecorder{enabled: config.Enabled}
-	recorder.init(config.Settings)
 
 	//todo switch this logger
 	recorder.logger = log.RootLogger()
 
-	return recorder
+	if err := recorder.init(config.Settings); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
 }
 
 func (sr *RemoteStateRecorder) Name() string {
@@ -62,13 +77,13 @@ func (sr *RemoteStateRecorder) Stop() error {
 }
 
 // Init implements services.StateRecorderService.Init()
-func (sr *RemoteStateRecorder) init(settings map[string]string) {
+func (sr *RemoteStateRecorder) init(settings map[string]string) error {
 
 	host, set := settings["host"]
 	port, set := settings["port"]
 
 	if !set {
-		panic("RemoteStateRecorder: required setting 'host' not set")
+		return errors.New("RemoteStateRecorder: required setting 'host' not set")
 	}
 
 	if strings.Index(host, "http") != 0 {
@@ -77,12 +92,30 @@ func (sr *RemoteStateRecorder) init(settings map[string]string) {
 		sr.host = host + ":" + port
 	}
 
-	sr.logger.Debugf("RemoteStateRecorder: StateRecorder Server = %s", sr.host)
+	codec, err := NewCodec(settings["codec"], settings["compression"])
+	if err != nil {
+		return errors.New("RemoteStateRecorder: " + err.Error())
+	}
+	sr.codec = codec
+
+	sr.logger.Debugf("RemoteStateRecorder: StateRecorder Server = %s, Codec = %s", sr.host, sr.codec.Name())
+
+	return nil
 }
 
 // RecordSnapshot implements instance.StateRecorder.RecordSnapshot
 func (sr *RemoteStateRecorder) RecordSnapshot(instance *IndependentInstance) {
 
+	if sr.codec.Name() != CodecJSON {
+		encoded, err := sr.codec.EncodeSnapshot(instance)
+		if err != nil {
+			sr.logger.Errorf("Unable to encode snapshot of instance '%s': %v", instance.ID(), err)
+			return
+		}
+		sr.post("/instances/snapshot", instance, encoded)
+		return
+	}
+
 	storeReq := &RecordSnapshotReq{
 		ID:           instance.StepID(),
 		FlowID:       instance.ID(),
@@ -118,6 +151,16 @@ func (sr *RemoteStateRecorder) RecordSnapshot(instance *IndependentInstance) {
 // RecordStep implements instance.StateRecorder.RecordStep
 func (sr *RemoteStateRecorder) RecordStep(instance *IndependentInstance) {
 
+	if sr.codec.Name() != CodecJSON {
+		encoded, err := sr.codec.EncodeStep(instance.ChangeTracker)
+		if err != nil {
+			sr.logger.Errorf("Unable to encode step of instance '%s': %v", instance.ID(), err)
+			return
+		}
+		sr.post("/instances/steps", instance, encoded)
+		return
+	}
+
 	storeReq := &RecordStepReq{
 		ID:       instance.StepID(),
 		FlowID:   instance.ID(),
@@ -153,6 +196,41 @@ func (sr *RemoteStateRecorder) RecordStep(instance *IndependentInstance) {
 	}
 }
 
+// post posts the encoded snapshot or step of the instance, the identification of the
+// instance and step is sent in headers since the body is not JSON
+func (sr *RemoteStateRecorder) post(path string, instance *IndependentInstance, encoded []byte) {
+
+	uri := sr.host + path
+
+	sr.logger.Debugf("POST %s (%d bytes): %s\n", sr.codec.Name(), len(encoded), uri)
+
+	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(encoded))
+	if err != nil {
+		sr.logger.Errorf("Unable to create request: %v", err)
+		return
+	}
+
+	req.Header.Set("Content-Type", sr.codec.ContentType())
+	req.Header.Set(HeaderFlowID, instance.ID())
+	req.Header.Set(HeaderStepID, strconv.Itoa(instance.StepID()))
+	req.Header.Set(HeaderStatus, strconv.Itoa(int(instance.Status())))
+	req.Header.Set(HeaderFlowURI, instance.flowURI)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		sr.logger.Errorf("Unable to post to '%s' for instance '%s': %v", uri, instance.ID(), err)
+		return
+	}
+	defer resp.Body.Close()
+
+	sr.logger.Debug("response Status:", resp.Status)
+
+	if resp.StatusCode >= 300 {
+		sr.logger.Errorf("Post to '%s' for instance '%s' failed: %s", uri, instance.ID(), resp.Status)
+	}
+}
+
 // RecordSnapshotReq serializable representation of the RecordSnapshot request
 type RecordSnapshotReq struct {
 	ID     int    `json:"id"`
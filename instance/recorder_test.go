@@ -0,0 +1,45 @@
+package instance
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qingcloudhx/core/support"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRemoteStateRecorder_InvalidCodec(t *testing.T) {
+
+	config := &support.ServiceConfig{Enabled: true, Settings: map[string]string{"host": "localhost", "port": "9090", "codec": "xml"}}
+
+	recorder, err := NewRemoteStateRecorder(config)
+	assert.NotNil(t, err)
+	assert.Nil(t, recorder)
+
+	config.Settings = map[string]string{"host": "localhost", "port": "9090", "codec": CodecBinary, "compression": "zip"}
+
+	_, err = NewRemoteStateRecorder(config)
+	assert.NotNil(t, err)
+}
+
+func TestRemoteStateRecorder_Unreachable(t *testing.T) {
+
+	// the server is closed right away, so the posts fail
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	config := &support.ServiceConfig{Enabled: true, Settings: map[string]string{"host": server.URL, "port": "", "codec": CodecBinary}}
+
+	recorder, err := NewRemoteStateRecorder(config)
+	assert.Nil(t, err)
+	recorder.host = server.URL
+
+	inst := newCodecTestInstance(t)
+	inst.DoStep()
+
+	assert.NotPanics(t, func() {
+		recorder.RecordSnapshot(inst)
+		recorder.RecordStep(inst)
+	})
+}
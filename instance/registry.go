@@ -0,0 +1,335 @@
+package instance
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/qingcloudhx/flow/model"
+)
+
+var defaultRegistry = NewRegistry()
+
+// GetDefaultRegistry returns the Registry used to track the instances started by the flow action
+func GetDefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// ErrInstanceNotFound is returned when the specified instance is not live in the registry
+var ErrInstanceNotFound = errors.New("instance not found")
+
+// Registry keeps track of the live IndependentInstances by ID, it can be used to
+// query them and to control their execution
+type Registry struct {
+	mu        sync.RWMutex
+	instances map[string]*Handle
+}
+
+// NewRegistry creates a new Registry
+func NewRegistry() *Registry {
+	return &Registry{instances: make(map[string]*Handle)}
+}
+
+// Register adds the instance to the registry, the instance should be stepped via
+// the returned Handle so that it can be controlled
+func (r *Registry) Register(inst *IndependentInstance) *Handle {
+
+	h := newHandle(inst)
+
+	r.mu.Lock()
+	r.instances[inst.ID()] = h
+	r.mu.Unlock()
+
+	return h
+}
+
+// Unregister removes the specified instance from the registry
+func (r *Registry) Unregister(id string) {
+	r.mu.Lock()
+	delete(r.instances, id)
+	r.mu.Unlock()
+}
+
+// Get returns the Handle of the specified live instance
+func (r *Registry) Get(id string) (*Handle, bool) {
+	r.mu.RLock()
+	h, ok := r.instances[id]
+	r.mu.RUnlock()
+
+	return h, ok
+}
+
+// List returns the info of the live instances that match the filter, a nil filter matches all instances
+func (r *Registry) List(filter *Filter) []*InstanceInfo {
+
+	r.mu.RLock()
+	handles := make([]*Handle, 0, len(r.instances))
+	for _, h := range r.instances {
+		handles = append(handles, h)
+	}
+	r.mu.RUnlock()
+
+	infos := make([]*InstanceInfo, 0, len(handles))
+	for _, h := range handles {
+		info := h.Info()
+		if filter == nil || filter.Matches(info) {
+			infos = append(infos, info)
+		}
+	}
+
+	return infos
+}
+
+// Snapshot returns the JSON snapshot of the specified live instance
+func (r *Registry) Snapshot(id string) ([]byte, error) {
+	h, ok := r.Get(id)
+	if !ok {
+		return nil, ErrInstanceNotFound
+	}
+
+	return h.Snapshot()
+}
+
+// Pause pauses the specified instance before its next step
+func (r *Registry) Pause(id string) error {
+	h, ok := r.Get(id)
+	if !ok {
+		return ErrInstanceNotFound
+	}
+
+	h.Pause()
+	return nil
+}
+
+// Resume resumes the specified paused instance
+func (r *Registry) Resume(id string) error {
+	h, ok := r.Get(id)
+	if !ok {
+		return ErrInstanceNotFound
+	}
+
+	h.Resume()
+	return nil
+}
+
+// Cancel cancels the specified instance before its next step
+func (r *Registry) Cancel(id string) error {
+	h, ok := r.Get(id)
+	if !ok {
+		return ErrInstanceNotFound
+	}
+
+	h.Cancel()
+	return nil
+}
+
+// Filter is used to select instances when listing the registry
+type Filter struct {
+	// FlowName matches the name of the flow, empty matches all
+	FlowName string
+	// Statuses matches any of the statuses, empty matches all
+	Statuses []model.FlowStatus
+	// StartedBefore matches instances started before the time, zero matches all
+	StartedBefore time.Time
+}
+
+// Matches determines if the filter matches the specified instance info
+func (f *Filter) Matches(info *InstanceInfo) bool {
+
+	if f.FlowName != "" && f.FlowName != info.FlowName {
+		return false
+	}
+
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			if status == info.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !f.StartedBefore.IsZero() && !info.StartTime.Before(f.StartedBefore) {
+		return false
+	}
+
+	return true
+}
+
+// InstanceInfo describes the current execution state of a live instance
+type InstanceInfo struct {
+	ID        string           `json:"id"`
+	FlowName  string           `json:"flowName"`
+	FlowURI   string           `json:"flowUri"`
+	Status    model.FlowStatus `json:"status"`
+	StepID    int              `json:"stepId"`
+	StartTime time.Time        `json:"startTime"`
+	Paused    bool             `json:"paused"`
+
+	// CurrentTask is the task being executed by the current step
+	CurrentTask string `json:"currentTask,omitempty"`
+	// QueuedTasks are the tasks scheduled to be executed
+	QueuedTasks []string `json:"queuedTasks,omitempty"`
+	// WaitingTasks are the tasks waiting on an async activity
+	WaitingTasks []string `json:"waitingTasks,omitempty"`
+}
+
+// Handle is used to step and control an instance of the Registry, commands and
+// queries are serialized with the execution of the instance's steps
+type Handle struct {
+	inst      *IndependentInstance
+	startTime time.Time
+
+	execMu sync.Mutex // held while a step is executing
+
+	mu        sync.Mutex // protects the control and info state
+	cond      *sync.Cond
+	paused    bool
+	cancelled bool
+	info      InstanceInfo
+	debug     *debugState
+}
+
+func newHandle(inst *IndependentInstance) *Handle {
+	h := &Handle{inst: inst, startTime: time.Now()}
+	h.cond = sync.NewCond(&h.mu)
+	h.updateInfo("")
+
+	return h
+}
+
+// Instance returns the instance associated with the handle
+func (h *Handle) Instance() *IndependentInstance {
+	return h.inst
+}
+
+// DoStep executes the next step of the instance, blocking while the instance is paused.
+// Returns false if there is no more work or if the instance was cancelled.
+func (h *Handle) DoStep() bool {
+
+	h.mu.Lock()
+	if h.debug != nil && !h.paused && !h.cancelled {
+		h.debug.checkBreak(h)
+	}
+	for h.paused && !h.cancelled {
+		h.cond.Wait()
+	}
+	cancelled := h.cancelled
+	if h.debug != nil && !cancelled {
+		h.debug.beforeStep(h.inst)
+	}
+	h.mu.Unlock()
+
+	if cancelled {
+		h.execMu.Lock()
+		if h.inst.Status() < model.FlowStatusCompleted {
+			h.inst.SetStatus(model.FlowStatusCancelled)
+		}
+		h.execMu.Unlock()
+		h.updateInfo("")
+		return false
+	}
+
+	h.updateInfo(h.inst.nextTaskID())
+
+	h.execMu.Lock()
+	hasWork := h.inst.DoStep()
+	h.execMu.Unlock()
+
+	h.updateInfo("")
+
+	return hasWork
+}
+
+// Pause pauses the instance before its next step
+func (h *Handle) Pause() {
+	h.mu.Lock()
+	h.paused = true
+	h.info.Paused = true
+	h.mu.Unlock()
+}
+
+// Resume resumes the paused instance
+func (h *Handle) Resume() {
+	h.mu.Lock()
+	if h.debug != nil && h.paused {
+		h.debug.resumed = true
+		h.debug.breakpoint = ""
+	}
+	h.paused = false
+	h.info.Paused = false
+	h.cond.Broadcast()
+	h.mu.Unlock()
+}
+
+// Cancel cancels the instance before its next step, a paused instance is woken up to be cancelled
+func (h *Handle) Cancel() {
+	h.mu.Lock()
+	h.cancelled = true
+	h.cond.Broadcast()
+	h.mu.Unlock()
+}
+
+// Paused indicates if the instance is paused
+func (h *Handle) Paused() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.paused
+}
+
+// Info returns the current execution info of the instance
+func (h *Handle) Info() *InstanceInfo {
+	h.mu.Lock()
+	info := h.info
+	h.mu.Unlock()
+
+	return &info
+}
+
+// Snapshot returns the JSON snapshot of the instance, if a step is executing it
+// waits for it to complete
+func (h *Handle) Snapshot() ([]byte, error) {
+	h.execMu.Lock()
+	defer h.execMu.Unlock()
+
+	return json.Marshal(h.inst)
+}
+
+// updateInfo refreshes the execution info, only called from the goroutine stepping the instance
+func (h *Handle) updateInfo(currentTask string) {
+
+	inst := h.inst
+
+	info := InstanceInfo{
+		ID:          inst.ID(),
+		FlowName:    inst.Name(),
+		FlowURI:     inst.FlowURI(),
+		Status:      inst.Status(),
+		StepID:      inst.StepID(),
+		StartTime:   h.startTime,
+		CurrentTask: currentTask,
+	}
+
+	for e := inst.workItemQueue.List.Front(); e != nil; e = e.Next() {
+		if wi, ok := e.Value.(*WorkItem); ok {
+			info.QueuedTasks = append(info.QueuedTasks, wi.TaskID)
+		}
+	}
+
+	for id, taskInst := range inst.taskInsts {
+		if taskInst.status == model.TaskStatusWaiting {
+			info.WaitingTasks = append(info.WaitingTasks, id)
+		}
+	}
+
+	h.mu.Lock()
+	info.Paused = h.paused
+	h.info = info
+	h.mu.Unlock()
+}
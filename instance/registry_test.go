@@ -0,0 +1,127 @@
+package instance
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/qingcloudhx/core/support/log"
+	_ "github.com/qingcloudhx/core/support/test"
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const registryDefJSON = `
+{
+  "name": "registry-flow",
+  "model": "test",
+  "tasks": [
+    { "id": "log1", "activity": { "ref": "testlog", "input": { "message": "first" } } },
+    { "id": "log2", "activity": { "ref": "testlog", "input": { "message": "second" } } }
+  ],
+  "links": [
+    { "from": "log1", "to": "log2" }
+  ]
+}
+`
+
+func newRegistryTestInstance(t *testing.T, id string) *IndependentInstance {
+
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(registryDefJSON), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	inst, err := NewIndependentInstance(id, "res://flow:registry", def, log.RootLogger())
+	assert.Nil(t, err)
+
+	return inst
+}
+
+func TestRegistry_List(t *testing.T) {
+
+	registry := NewRegistry()
+
+	inst := newRegistryTestInstance(t, "1")
+	inst.Start(nil)
+	registry.Register(inst)
+
+	infos := registry.List(nil)
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "1", infos[0].ID)
+	assert.Equal(t, "registry-flow", infos[0].FlowName)
+	assert.Equal(t, []string{"log1"}, infos[0].QueuedTasks)
+
+	infos = registry.List(&Filter{FlowName: "other"})
+	assert.Len(t, infos, 0)
+
+	infos = registry.List(&Filter{Statuses: []model.FlowStatus{model.FlowStatusActive}})
+	assert.Len(t, infos, 1)
+
+	infos = registry.List(&Filter{StartedBefore: time.Now().Add(-time.Hour)})
+	assert.Len(t, infos, 0)
+
+	snapshot, err := registry.Snapshot("1")
+	assert.Nil(t, err)
+	assert.Contains(t, string(snapshot), `"flowUri":"res://flow:registry"`)
+
+	registry.Unregister("1")
+	_, err = registry.Snapshot("1")
+	assert.Equal(t, ErrInstanceNotFound, err)
+}
+
+func TestRegistry_PauseResume(t *testing.T) {
+
+	registry := NewRegistry()
+
+	inst := newRegistryTestInstance(t, "2")
+	inst.Start(nil)
+	h := registry.Register(inst)
+
+	err := registry.Pause("2")
+	assert.Nil(t, err)
+	assert.True(t, h.Paused())
+
+	stepped := make(chan bool)
+	go func() {
+		stepped <- h.DoStep()
+	}()
+
+	select {
+	case <-stepped:
+		t.Fatal("paused instance should not step")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	err = registry.Resume("2")
+	assert.Nil(t, err)
+	assert.True(t, <-stepped)
+
+	for h.DoStep() {
+	}
+	assert.Equal(t, model.FlowStatusCompleted, inst.Status())
+}
+
+func TestRegistry_Cancel(t *testing.T) {
+
+	registry := NewRegistry()
+
+	inst := newRegistryTestInstance(t, "3")
+	inst.Start(nil)
+	h := registry.Register(inst)
+
+	assert.True(t, h.DoStep())
+
+	err := registry.Cancel("3")
+	assert.Nil(t, err)
+
+	assert.False(t, h.DoStep())
+	assert.Equal(t, model.FlowStatusCancelled, inst.Status())
+	assert.Equal(t, model.FlowStatusCancelled, registry.List(nil)[0].Status)
+
+	err = registry.Cancel("unknown")
+	assert.Equal(t, ErrInstanceNotFound, err)
+}
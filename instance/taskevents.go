@@ -9,11 +9,12 @@ import (
 )
 
 type taskEvent struct {
-	time                           time.Time
-	err                            error
-	taskIn, taskOut                map[string]interface{}
-	status                         event.Status
-	name, typeId, flowName, flowId string
+	time, startTime                       time.Time
+	err                                   error
+	taskIn, taskOut                       map[string]interface{}
+	status                                event.Status
+	name, id, typeId, flowName, flowId    string
+	subFlowId, stepId, iteration, retries int
 }
 
 // Returns flow name
@@ -61,6 +62,44 @@ func (te *taskEvent) TaskError() error {
 	return te.err
 }
 
+// Returns task ID
+func (te *taskEvent) TaskID() string {
+	return te.id
+}
+
+// In case of a task of a subflow, returns the ID of the subflow, 0 otherwise
+func (te *taskEvent) SubFlowID() int {
+	return te.subFlowId
+}
+
+// Returns the ID of the step of the instance during which the event occurred
+func (te *taskEvent) StepID() int {
+	return te.stepId
+}
+
+// Returns the time the task was entered
+func (te *taskEvent) StartTime() time.Time {
+	return te.startTime
+}
+
+// Returns the time elapsed between the entry of the task and the event
+func (te *taskEvent) Duration() time.Duration {
+	if te.startTime.IsZero() {
+		return 0
+	}
+	return te.time.Sub(te.startTime)
+}
+
+// Returns the index of the current iteration of an iterator task
+func (te *taskEvent) Iteration() int {
+	return te.iteration
+}
+
+// Returns the number of times the execution of the task was repeated, not counting iterations
+func (te *taskEvent) RetryCount() int {
+	return te.retries
+}
+
 func convertTaskStatus(code model.TaskStatus) event.Status {
 	switch code {
 	case model.TaskStatusNotStarted:
@@ -87,6 +126,12 @@ func postTaskEvent(taskInstance *TaskInst) {
 		te := &taskEvent{}
 		te.time = time.Now()
 		te.name = taskInstance.Task().Name()
+		te.id = taskInstance.Task().ID()
+		te.startTime = taskInstance.startTime
+		te.subFlowId = taskInstance.flowInst.subFlowId
+		te.stepId = taskInstance.flowInst.master.StepID()
+		te.iteration = taskInstance.iteration
+		te.retries = taskInstance.retries
 		te.status = convertTaskStatus(taskInstance.Status())
 		te.flowName = taskInstance.flowInst.Name()
 		te.flowId = taskInstance.flowInst.ID()
@@ -171,6 +216,10 @@ func postTaskEvent(taskInstance *TaskInst) {
 
 			}
 		}
+
+		te.taskIn = taskInstance.task.MaskValues(te.taskIn)
+		te.taskOut = taskInstance.task.MaskValues(te.taskOut)
+
 		coreevent.Post(event.TaskEventType, te)
 	}
 
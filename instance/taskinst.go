@@ -3,6 +3,7 @@ package instance
 import (
 	"fmt"
 	"runtime/debug"
+	"time"
 
 	"github.com/qingcloudhx/core/activity"
 	"github.com/qingcloudhx/core/data"
@@ -11,6 +12,8 @@ import (
 	"github.com/qingcloudhx/core/support/log"
 	"github.com/qingcloudhx/flow/definition"
 	"github.com/qingcloudhx/flow/model"
+	"github.com/qingcloudhx/flow/support"
+	"github.com/qingcloudhx/flow/support/trace"
 )
 
 func NewTaskInst(inst *Instance, task *definition.Task) *TaskInst {
@@ -44,6 +47,29 @@ type TaskInst struct {
 	logger      log.Logger
 	returnError error
 
+	interception *taskInterception
+	fault        *support.Fault
+
+	// startTime is the time the task was entered, retries counts the repeated executions
+	// of the task that are not iterations
+	startTime time.Time
+	retries   int
+
+	// iteration is the index of the current iteration of an iterator task, iterating
+	// indicates that the iteration started at iterationStart did not end yet
+	iteration      int
+	iterationStart time.Time
+	iterating      bool
+
+	// execStart is used to collect the metrics of the execution of the task
+	execStart time.Time
+
+	// span is used to trace the execution of the task
+	span *trace.Span
+
+	// inputOverrides override the inputs of the next execution of the task
+	inputOverrides map[string]interface{}
+
 	//needed for serialization
 	taskID string
 }
@@ -75,7 +101,7 @@ func (ti *TaskInst) GetInput(name string) interface{} {
 func (ti *TaskInst) SetOutput(name string, value interface{}) error {
 
 	if ti.logger.DebugEnabled() {
-		ti.logger.Debugf("Task[%s] - Set Output: %s = %v", ti.taskID, name, value)
+		ti.logger.Debugf("Task[%s] - Set Output: %s = %v", ti.taskID, name, ti.task.Mask(name, value))
 	}
 
 	if ti.outputs == nil {
@@ -119,9 +145,21 @@ func (ti *TaskInst) Status() model.TaskStatus {
 // SetStatus implements flow.TaskContext.SetStatus
 func (ti *TaskInst) SetStatus(status model.TaskStatus) {
 	ti.status = status
+	if status == model.TaskStatusEntered || ti.startTime.IsZero() {
+		ti.startTime = time.Now()
+		ti.retries = 0
+		ti.iteration = 0
+	}
 	ti.flowInst.master.ChangeTracker.trackTaskData(ti.flowInst.subFlowId, &TaskInstChange{ChgType: CtUpd, ID: ti.task.ID(), TaskInst: ti})
+	if listener := ti.flowInst.master.listener; listener != nil {
+		listener.TaskStatusChanged(ti.flowInst.master, ti)
+	}
 	//log.RootLogger().Info("Status.............", status, ti.task.ID())
 	postTaskEvent(ti)
+
+	if tracer != nil {
+		traceTaskStatus(ti)
+	}
 }
 
 func (ti *TaskInst) SetWorkingData(key string, value interface{}) {
@@ -252,10 +290,18 @@ func (ti *TaskInst) EvalActivity() (done bool, evalErr error) {
 			}
 		}
 		if evalErr != nil {
+			ti.returnError = evalErr
 			ti.logger.Errorf("Execution failed for Activity[%s] in Flow[%s] - %s", ti.task.ID(), ti.flowInst.flowDef.Name(), evalErr.Error())
 		}
+		if ti.iterating && (done || evalErr != nil) {
+			ti.endIteration(evalErr)
+		}
 	}()
 
+	if ti.task.TypeID() == iteratorTaskType {
+		ti.startIteration()
+	}
+
 	eval := true
 
 	if actCfg.InputMapper() != nil {
@@ -269,8 +315,24 @@ func (ti *TaskInst) EvalActivity() (done bool, evalErr error) {
 		}
 	}
 
+	if err := applyInputOverrides(ti); err != nil {
+		return false, NewActivityEvalError(ti.task.Name(), "mapper", err.Error())
+	}
+
+	if err := applyFault(ti); err != nil {
+		return false, err
+	}
+
+	if err := matchInterceptor(ti); err != nil {
+		return false, err
+	}
+
 	eval = applyInputInterceptor(ti)
 
+	if err := interceptorError(ti); err != nil {
+		return false, err
+	}
+
 	if eval {
 
 		if schema.ValidationEnabled() {
@@ -326,6 +388,8 @@ func (ti *TaskInst) EvalActivity() (done bool, evalErr error) {
 			}
 		}
 
+		applyCorruptFault(ti)
+
 		err := applyOutputInterceptor(ti)
 		if err != nil {
 			return false, err
@@ -369,8 +433,12 @@ func (ti *TaskInst) PostEvalActivity() (done bool, evalErr error) {
 			}
 		}
 		if evalErr != nil {
+			ti.returnError = evalErr
 			ti.logger.Errorf("Execution failed for Activity[%s] in Flow[%s] - %s", ti.task.Name(), ti.flowInst.flowDef.Name(), evalErr.Error())
 		}
+		if ti.iterating && (done || evalErr != nil) {
+			ti.endIteration(evalErr)
+		}
 	}()
 
 	aa, ok := act.(activity.AsyncActivity)
@@ -427,7 +495,7 @@ func (ti *TaskInst) GetSetting(name string) (value interface{}, exists bool) {
 func (ti *TaskInst) appendErrorData(err error) {
 	//For global handle only
 	errObj := ti.getErrorObject(err)
-	ti.flowInst.attrs["_E."+ti.Task().ID()] = errObj
+	ti.flowInst.SetValue("_E."+ti.Task().ID(), errObj)
 	ti.flowInst.SetValue("_E", errObj)
 
 }
@@ -435,7 +503,7 @@ func (ti *TaskInst) appendErrorData(err error) {
 func (ti *TaskInst) setTaskError(err error) {
 	//For error branch handle.
 	errObj := ti.getErrorObject(err)
-	ti.flowInst.attrs["_E."+ti.Task().ID()] = errObj
+	ti.flowInst.SetValue("_E."+ti.Task().ID(), errObj)
 
 }
 
@@ -0,0 +1,144 @@
+package instance
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/qingcloudhx/core/activity"
+	"github.com/qingcloudhx/flow/model"
+	"github.com/qingcloudhx/flow/support/trace"
+)
+
+// tracer creates the spans of the flows and tasks, nil if tracing is disabled
+var tracer *trace.Tracer
+
+// SetTracer sets the tracer used to create the spans of the flows and tasks executed by
+// the instances, nil disables tracing
+func SetTracer(t *trace.Tracer) {
+	tracer = t
+}
+
+// SetTraceContext sets the parent of the span of the instance to the span context carried
+// by the context, if any
+func (inst *IndependentInstance) SetTraceContext(ctx context.Context) {
+	inst.traceParent, _ = trace.SpanContextFromContext(ctx)
+}
+
+// flowSpan returns the span of the flow, starting it if needed.  The span of an embedded
+// flow is a child of the span of the task that started it.
+func flowSpan(inst *Instance) *trace.Span {
+
+	if inst.span != nil {
+		return inst.span
+	}
+
+	parent := inst.master.traceParent
+	if host, ok := inst.host.(*TaskInst); ok {
+		parent = taskSpan(host).Context
+	}
+
+	inst.span = tracer.StartSpan("flow "+inst.Name(), parent)
+	inst.span.SetAttribute("flow.name", inst.Name())
+	inst.span.SetAttribute("flow.id", inst.ID())
+	inst.span.SetAttribute("flow.uri", inst.FlowURI())
+
+	if inst.subFlowId > 0 {
+		inst.span.SetAttribute("flow.subflow_id", inst.subFlowId)
+	}
+
+	return inst.span
+}
+
+// taskSpan returns the span of the task, starting it if needed
+func taskSpan(ti *TaskInst) *trace.Span {
+
+	if ti.span != nil {
+		return ti.span
+	}
+
+	ti.span = tracer.StartSpan("task "+ti.task.ID(), flowSpan(ti.flowInst).Context)
+	ti.span.SetAttribute("flow.id", ti.flowInst.ID())
+	ti.span.SetAttribute("task.id", ti.task.ID())
+	ti.span.SetAttribute("task.name", ti.task.Name())
+	ti.span.SetAttribute("task.type", ti.task.TypeID())
+
+	if ti.HasActivity() {
+		ti.span.SetAttribute("activity.ref", activity.GetRef(ti.task.ActivityConfig().Activity))
+	}
+
+	return ti.span
+}
+
+// traceFlowStatus starts the span of the flow when it becomes active and finishes it when
+// the flow ends
+func traceFlowStatus(inst *Instance) {
+
+	switch inst.status {
+	case model.FlowStatusActive:
+		flowSpan(inst)
+	case model.FlowStatusCompleted, model.FlowStatusCancelled, model.FlowStatusFailed:
+		span := flowSpan(inst)
+		span.SetAttribute("flow.status", int(inst.status))
+
+		if inst.status == model.FlowStatusCompleted {
+			span.SetStatus(trace.StatusOK, "")
+		} else if inst.returnError != nil {
+			span.SetAttribute("error", inst.returnError.Error())
+			span.SetStatus(trace.StatusError, inst.returnError.Error())
+		} else {
+			span.SetStatus(trace.StatusError, "flow "+strconv.Itoa(int(inst.status)))
+		}
+
+		span.Finish()
+		inst.span = nil
+	}
+}
+
+// traceTaskStatus starts the span of the task when it becomes ready and finishes it when the
+// task ends
+func traceTaskStatus(ti *TaskInst) {
+
+	switch ti.status {
+	case model.TaskStatusReady:
+		taskSpan(ti)
+	case model.TaskStatusDone, model.TaskStatusSkipped, model.TaskStatusFailed:
+		span := taskSpan(ti)
+		span.SetAttribute("task.status", int(ti.status))
+
+		if ti.status == model.TaskStatusFailed {
+			if ti.returnError != nil {
+				span.SetAttribute("error", ti.returnError.Error())
+				span.SetStatus(trace.StatusError, ti.returnError.Error())
+			} else {
+				span.SetStatus(trace.StatusError, "")
+			}
+		} else {
+			span.SetStatus(trace.StatusOK, "")
+		}
+
+		if ti.iteration > 0 {
+			span.SetAttribute("task.iterations", ti.iteration)
+		}
+
+		span.Finish()
+		ti.span = nil
+	}
+}
+
+// traceIteration traces the current iteration of the task as a child span of the span of the task
+func traceIteration(ti *TaskInst, err error) {
+
+	iteration := tracer.StartSpan("iteration "+strconv.Itoa(ti.iteration), taskSpan(ti).Context)
+	iteration.Start = ti.iterationStart
+	iteration.SetAttribute("task.id", ti.task.ID())
+	iteration.SetAttribute("task.iteration", ti.iteration)
+
+	if err != nil {
+		iteration.SetAttribute("error", err.Error())
+		iteration.SetStatus(trace.StatusError, err.Error())
+	} else {
+		iteration.SetStatus(trace.StatusOK, "")
+	}
+
+	iteration.Finish()
+}
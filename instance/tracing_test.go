@@ -0,0 +1,100 @@
+package instance
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/support/trace"
+	"github.com/stretchr/testify/assert"
+)
+
+const tracingDefJSON = `
+{
+  "name": "tracing-flow",
+  "model": "flogo-simple",
+  "tasks": [
+    { "id": "loop", "type": "iterator", "settings": { "iterate": 3 }, "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "loop" } } },
+    { "id": "echo", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "done" } } }
+  ],
+  "links": [
+    { "from": "loop", "to": "echo" }
+  ]
+}
+`
+
+// memoryExporter keeps the exported spans
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*trace.Span
+}
+
+func (e *memoryExporter) ExportSpans(spans []*trace.Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *memoryExporter) Shutdown() error {
+	return nil
+}
+
+func (e *memoryExporter) find(name string) *trace.Span {
+	for _, span := range e.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestIndependentInstance_Tracing(t *testing.T) {
+
+	exporter := &memoryExporter{}
+	SetTracer(trace.NewTracer(exporter, nil))
+	defer SetTracer(nil)
+
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(tracingDefJSON), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	inst, err := NewIndependentInstance("traced", "res://flow:tracing", def, log.RootLogger())
+	assert.Nil(t, err)
+
+	parent, err := trace.ParseTraceParent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	assert.Nil(t, err)
+	inst.SetTraceContext(trace.ContextWithSpanContext(context.Background(), parent))
+
+	inst.Start(nil)
+	runToCompletion(inst)
+
+	flowSpan := exporter.find("flow tracing-flow")
+	assert.NotNil(t, flowSpan)
+	assert.Equal(t, parent.TraceID, flowSpan.Context.TraceID)
+	assert.Equal(t, parent.SpanID, flowSpan.Parent)
+	assert.Equal(t, trace.StatusOK, flowSpan.Status)
+
+	loopSpan := exporter.find("task loop")
+	assert.NotNil(t, loopSpan)
+	assert.Equal(t, flowSpan.Context.SpanID, loopSpan.Parent)
+	assert.Equal(t, "github.com/qingcloudhx/flow/support/test", loopSpan.Attributes["activity.ref"])
+	assert.Equal(t, 3, loopSpan.Attributes["task.iterations"])
+
+	for _, name := range []string{"iteration 0", "iteration 1", "iteration 2"} {
+		iteration := exporter.find(name)
+		assert.NotNil(t, iteration)
+		assert.Equal(t, loopSpan.Context.SpanID, iteration.Parent)
+	}
+
+	echoSpan := exporter.find("task echo")
+	assert.NotNil(t, echoSpan)
+	assert.Equal(t, flowSpan.Context.SpanID, echoSpan.Parent)
+	assert.True(t, flowSpan.Ended())
+}
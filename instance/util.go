@@ -2,11 +2,15 @@ package instance
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/qingcloudhx/core/activity"
 	"github.com/qingcloudhx/core/data"
 	"github.com/qingcloudhx/core/data/coerce"
+	"github.com/qingcloudhx/core/data/expression"
 	"github.com/qingcloudhx/core/data/metadata"
+	"github.com/qingcloudhx/flow/definition"
 	"github.com/qingcloudhx/flow/support"
 )
 
@@ -68,65 +72,177 @@ func applyInputMapper(taskInst *TaskInst) error {
 	return nil
 }
 
-func applyInputInterceptor(taskInst *TaskInst) bool {
+// taskInterception is the interceptor applied to an execution of a task
+type taskInterception struct {
+	interceptor *support.TaskInterceptor
+	outputs     []*data.Attribute
+	err         *support.InterceptorError
+}
+
+// matchInterceptor determines the interceptor to apply to the current execution of the task,
+// it should be called once the task's inputs have been mapped
+func matchInterceptor(taskInst *TaskInst) error {
+
+	taskInst.interception = nil
 
 	master := taskInst.flowInst.master
 
-	if master.interceptor != nil {
+	if master.interceptor == nil {
+		return nil
+	}
+
+	for _, taskInterceptor := range master.interceptor.GetTaskInterceptors(taskInst.task.ID()) {
 
-		// check if this task as an interceptor
-		taskInterceptor := master.interceptor.GetTaskInterceptor(taskInst.task.ID())
+		if taskInterceptor.When != "" {
+			scope := data.NewSimpleScope(taskInst.inputs, taskInst.flowInst)
 
-		if taskInterceptor != nil {
+			matches, err := taskInterceptor.Matches(scope, getExprFactory())
+			if err != nil {
+				return fmt.Errorf("unable to evaluate interceptor condition '%s': %v", taskInterceptor.When, err)
+			}
+
+			if !matches {
+				continue
+			}
+		}
 
-			taskInst.logger.Debug("Applying Interceptor - Input")
+		if master.interceptorExecs == nil {
+			master.interceptorExecs = make(map[*support.TaskInterceptor]int)
+		}
 
-			if len(taskInterceptor.Inputs) > 0 {
-				// override input attributes
-				mdInputs := taskInst.task.ActivityConfig().Activity.Metadata().Input
-				var err error
-				for _, attribute := range taskInterceptor.Inputs {
+		execution := master.interceptorExecs[taskInterceptor]
+		master.interceptorExecs[taskInterceptor] = execution + 1
 
-					if taskInst.logger.DebugEnabled() {
-						taskInst.logger.Debugf("Overriding Input Attr: %s = %s", attribute.Name(), attribute.Value())
-					}
+		outputs, interceptorErr := taskInterceptor.Result(execution)
+		taskInst.interception = &taskInterception{interceptor: taskInterceptor, outputs: outputs, err: interceptorErr}
+
+		return nil
+	}
+
+	return nil
+}
+
+func applyInputInterceptor(taskInst *TaskInst) bool {
+
+	if taskInst.interception != nil {
+
+		taskInterceptor := taskInst.interception.interceptor
+
+		taskInst.logger.Debug("Applying Interceptor - Input")
+
+		if delay, err := taskInterceptor.Delay(); err != nil {
+			taskInst.logger.Warnf("Invalid interceptor latency '%s': %v", taskInterceptor.Latency, err)
+		} else if delay > 0 {
+			time.Sleep(delay)
+		}
 
-					if mdAttr, ok := mdInputs[attribute.Name()]; ok {
-						taskInst.inputs[attribute.Name()], err = coerce.ToType(attribute.Value(), mdAttr.Type())
-						if err != nil {
-							//handler err
-						}
-					} else {
-						taskInst.inputs[attribute.Name()] = attribute.Value()
+		if len(taskInterceptor.Inputs) > 0 {
+
+			if taskInst.inputs == nil {
+				taskInst.inputs = make(map[string]interface{}, len(taskInterceptor.Inputs))
+			}
+
+			// override input attributes
+			mdInputs := taskInst.task.ActivityConfig().Activity.Metadata().Input
+			var err error
+			for _, attribute := range taskInterceptor.Inputs {
+
+				if taskInst.logger.DebugEnabled() {
+					taskInst.logger.Debugf("Overriding Input Attr: %s = %s", attribute.Name(), attribute.Value())
+				}
+
+				if mdAttr, ok := mdInputs[attribute.Name()]; ok {
+					taskInst.inputs[attribute.Name()], err = coerce.ToType(attribute.Value(), mdAttr.Type())
+					if err != nil {
+						//handler err
 					}
+				} else {
+					taskInst.inputs[attribute.Name()] = attribute.Value()
 				}
 			}
-
-			// check if we should not evaluate the task
-			return !taskInterceptor.Skip
 		}
+
+		// check if we should not evaluate the task
+		return !taskInterceptor.Skip
 	}
 
 	return true
 }
 
-func applyOutputInterceptor(taskInst *TaskInst) error {
+// applyInputOverrides overrides the inputs of a task that is re-executed by a restart,
+// the overrides only apply to the first execution of the task
+func applyInputOverrides(taskInst *TaskInst) error {
 
-	master := taskInst.flowInst.master
+	if taskInst.inputOverrides == nil {
+		return nil
+	}
 
-	if master.interceptor != nil {
+	overrides := taskInst.inputOverrides
+	taskInst.inputOverrides = nil
+
+	taskInst.logger.Debug("Applying Restart Input Overrides")
+
+	if taskInst.inputs == nil {
+		taskInst.inputs = make(map[string]interface{}, len(overrides))
+	}
+
+	mdInputs := taskInst.task.ActivityConfig().Activity.Metadata().Input
+
+	for name, value := range overrides {
+
+		if mdAttr, ok := mdInputs[name]; ok {
+			coerced, err := coerce.ToType(value, mdAttr.Type())
+			if err != nil {
+				return fmt.Errorf("unable to override input '%s': %v", name, err)
+			}
+			taskInst.inputs[name] = coerced
+		} else {
+			taskInst.inputs[name] = value
+		}
+	}
+
+	return nil
+}
+
+// interceptorError returns the error that the task's interceptor injects, if any
+func interceptorError(taskInst *TaskInst) error {
+
+	if taskInst.interception != nil && taskInst.interception.err != nil {
+
+		taskInst.logger.Debug("Applying Interceptor - Error")
+
+		interceptorErr := taskInst.interception.err
+
+		if interceptorErr.Type != "" {
+			return NewActivityEvalError(taskInst.task.Name(), interceptorErr.Type, interceptorErr.Message)
+		}
+
+		return activity.NewError(interceptorErr.Message, interceptorErr.Code, nil)
+	}
+
+	return nil
+}
+
+func applyOutputInterceptor(taskInst *TaskInst) error {
+
+	if taskInst.interception != nil {
 
 		taskInst.logger.Debug("Applying Interceptor - Output")
 
 		// check if this task as an interceptor and overrides ouputs
-		taskInterceptor := master.interceptor.GetTaskInterceptor(taskInst.task.ID())
-		if taskInterceptor != nil && len(taskInterceptor.Outputs) > 0 {
+		outputs := taskInst.interception.outputs
+		if len(outputs) > 0 {
 
 			mdOutput := taskInst.task.ActivityConfig().Activity.Metadata().Output
 			var err error
 
+			// the outputs are not set if the task was skipped
+			if taskInst.outputs == nil {
+				taskInst.outputs = make(map[string]interface{}, len(outputs))
+			}
+
 			// override output attributes
-			for _, attribute := range taskInterceptor.Outputs {
+			for _, attribute := range outputs {
 
 				if taskInst.logger.DebugEnabled() {
 					taskInst.logger.Debugf("Overriding Output Attr: %s = %s", attribute.Name(), attribute.Value())
@@ -147,6 +263,61 @@ func applyOutputInterceptor(taskInst *TaskInst) error {
 	return nil
 }
 
+// applyFault applies the fault injected in the task before its activity is evaluated,
+// returns the error to fail the task with, if any
+func applyFault(taskInst *TaskInst) error {
+
+	fault := taskInst.fault
+	if fault == nil {
+		return nil
+	}
+
+	switch fault.Type {
+	case support.FaultError:
+		taskInst.logger.Debug("Applying Fault - Error")
+		return NewActivityEvalError(taskInst.task.Name(), "fault", fault.Message)
+	case support.FaultDelay:
+		taskInst.logger.Debug("Applying Fault - Delay")
+		delay, err := fault.GetDelay()
+		if err != nil {
+			taskInst.logger.Warnf("Invalid fault delay '%s': %v", fault.Delay, err)
+		}
+		time.Sleep(delay)
+	case support.FaultCorrupt:
+		// applied once the activity has been evaluated
+	default:
+		taskInst.logger.Warnf("Unsupported fault type '%s'", fault.Type)
+	}
+
+	return nil
+}
+
+// applyCorruptFault corrupts the outputs of the task if a corrupt fault was injected
+func applyCorruptFault(taskInst *TaskInst) {
+
+	if taskInst.fault == nil || taskInst.fault.Type != support.FaultCorrupt {
+		return
+	}
+
+	taskInst.logger.Debug("Applying Fault - Corrupt Outputs")
+
+	if taskInst.outputs == nil {
+		taskInst.outputs = make(map[string]interface{})
+	}
+
+	for name := range taskInst.task.ActivityConfig().Activity.Metadata().Output {
+		taskInst.outputs[name] = taskInst.fault.Value
+	}
+}
+
+func getExprFactory() expression.Factory {
+	if factory := definition.GetExprFactory(); factory != nil {
+		return factory
+	}
+
+	return expression.NewFactory(definition.GetDataResolver())
+}
+
 // applyOutputMapper applies the output mapper, returns flag indicating if
 // there was an output mapper
 func applyOutputMapper(taskInst *TaskInst) (bool, error) {
@@ -170,10 +341,7 @@ func applyOutputMapper(taskInst *TaskInst) (bool, error) {
 		values, err := outputMapper.Apply(data.NewSimpleScope(taskInst.outputs, nil))
 
 		for name, value := range values {
-			if taskInst.flowInst.attrs == nil {
-				taskInst.flowInst.attrs = make(map[string]interface{})
-			}
-			taskInst.flowInst.attrs[name] = value //data.ToTypedValue(value)
+			taskInst.flowInst.SetValue(name, value)
 		}
 
 		return true, err
@@ -203,10 +371,13 @@ func StartSubFlow(ctx activity.Context, flowURI string, inputs map[string]interf
 		return errors.New("unable to create subFlow using this context")
 	}
 
-	def, _, err := support.GetDefinition(flowURI)
+	// the subflow acquires the definition, it is released once it is created
+	def, release, _, err := support.AcquireDefinition(flowURI)
 	if err != nil {
 		return err
 	}
+	defer release()
+
 	if def == nil {
 		return errors.New("unable to resolve subflow: " + flowURI)
 	}
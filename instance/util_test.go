@@ -0,0 +1,81 @@
+package instance
+
+import (
+	"encoding/json"
+	"testing"
+
+	_ "github.com/qingcloudhx/core/data/expression/script"
+	"github.com/qingcloudhx/flow/support"
+	"github.com/stretchr/testify/assert"
+)
+
+const interceptorJSON = `
+{
+  "tasks": [
+    {
+      "id": "log1",
+      "when": "$.message == 'first'",
+      "skip": true,
+      "sequence": [
+        { "outputs": [ { "name": "message", "type": "string", "value": "one" } ] },
+        { "error": { "type": "timeout", "message": "timed out" } },
+        { "outputs": [ { "name": "message", "type": "string", "value": "three" } ] }
+      ]
+    },
+    { "id": "log1", "error": "no match" }
+  ]
+}
+`
+
+func newInterceptedTaskInst(t *testing.T) *TaskInst {
+
+	interceptor := &support.Interceptor{}
+	err := json.Unmarshal([]byte(interceptorJSON), interceptor)
+	assert.Nil(t, err)
+
+	inst := newRegistryTestInstance(t, "intercepted")
+	ApplyExecOptions(inst, &ExecOptions{Interceptor: interceptor})
+
+	return NewTaskInst(inst.Instance, inst.flowDef.GetTask("log1"))
+}
+
+func TestMatchInterceptor_Sequence(t *testing.T) {
+
+	taskInst := newInterceptedTaskInst(t)
+	taskInst.inputs = map[string]interface{}{"message": "first"}
+
+	var results []string
+	for i := 0; i < 4; i++ {
+		err := matchInterceptor(taskInst)
+		assert.Nil(t, err)
+		assert.False(t, applyInputInterceptor(taskInst))
+
+		if err := interceptorError(taskInst); err != nil {
+			evalErr, ok := err.(*ActivityEvalError)
+			assert.True(t, ok)
+			assert.Equal(t, "timeout", evalErr.Type())
+			results = append(results, evalErr.errText)
+			continue
+		}
+
+		taskInst.outputs = nil
+		err = applyOutputInterceptor(taskInst)
+		assert.Nil(t, err)
+		results = append(results, taskInst.outputs["message"].(string))
+	}
+
+	assert.Equal(t, []string{"one", "timed out", "three", "three"}, results)
+}
+
+func TestMatchInterceptor_When(t *testing.T) {
+
+	taskInst := newInterceptedTaskInst(t)
+	taskInst.inputs = map[string]interface{}{"message": "other"}
+
+	err := matchInterceptor(taskInst)
+	assert.Nil(t, err)
+
+	err = interceptorError(taskInst)
+	assert.NotNil(t, err)
+	assert.Equal(t, "no match", err.Error())
+}
@@ -1,9 +1,11 @@
 package ondemand
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"strconv"
 	"time"
@@ -94,6 +96,38 @@ func (f *ActionFactory) Initialize(ctx action.InitContext) error {
 
 }
 
+// flowPackageJSON returns the JSON of the flow package, it can be provided as JSON or as a
+// string containing its JSON or YAML
+func flowPackageJSON(fpAttr interface{}) ([]byte, error) {
+
+	var content []byte
+
+	switch fp := fpAttr.(type) {
+	case json.RawMessage:
+		trimmed := bytes.TrimSpace(fp)
+		if len(trimmed) == 0 || trimmed[0] != '"' {
+			return fp, nil
+		}
+		var s string
+		if err := json.Unmarshal(trimmed, &s); err != nil {
+			return nil, err
+		}
+		content = []byte(s)
+	case string:
+		content = []byte(fp)
+	case []byte:
+		content = fp
+	default:
+		return nil, fmt.Errorf("unsupported flow package type %T", fpAttr)
+	}
+
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		return trimmed, nil
+	}
+
+	return definition.YAMLToJSON(content)
+}
+
 func recordFlows() bool {
 	recordFlows := os.Getenv(EnvFlowRecord)
 	if len(recordFlows) == 0 {
@@ -143,8 +177,11 @@ func (fa *FlowAction) Run(ctx context.Context, inputs map[string]interface{}, ha
 
 	if exists {
 
-		raw := fpAttr.(json.RawMessage)
-		err := json.Unmarshal(raw, flowPackage)
+		raw, err := flowPackageJSON(fpAttr)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(raw, flowPackage)
 		if err != nil {
 			return err
 		}
@@ -184,9 +221,18 @@ func (fa *FlowAction) Run(ctx context.Context, inputs map[string]interface{}, ha
 
 	inst.SetResultHandler(handler)
 
+	registry := instance.GetDefaultRegistry()
+	instHandle := registry.Register(inst)
+
 	go func() {
 
 		defer handler.Done()
+		defer func() {
+			// an instance waiting for an async activity is still live
+			if inst.Status() >= model.FlowStatusCompleted {
+				registry.Unregister(inst.ID())
+			}
+		}()
 
 		if !inst.FlowDefinition().ExplicitReply() {
 
@@ -200,7 +246,7 @@ func (fa *FlowAction) Run(ctx context.Context, inputs map[string]interface{}, ha
 		for hasWork && inst.Status() < model.FlowStatusCompleted && stepCount < maxStepCount {
 			stepCount++
 			logger.Debugf("Step: %d", stepCount)
-			hasWork = inst.DoStep()
+			hasWork = instHandle.DoStep()
 
 			if record {
 				//ep.GetStateRecorder().RecordSnapshot(inst)
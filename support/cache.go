@@ -0,0 +1,225 @@
+package support
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/definition"
+)
+
+const (
+	// DefaultCacheSize is the default maximum number of flows cached by the FlowManager
+	DefaultCacheSize = 1000
+
+	// EnvFlowCacheSize is the maximum number of flows cached by the FlowManager
+	EnvFlowCacheSize = "FLOGO_FLOW_CACHE_SIZE"
+	// EnvFlowCacheTTL is the time the FlowManager caches a flow (ex. "10m"), flows don't expire if not set
+	EnvFlowCacheTTL = "FLOGO_FLOW_CACHE_TTL"
+)
+
+// CacheConfig is the configuration of the cache of the flows of the FlowManager
+type CacheConfig struct {
+	// MaxSize is the maximum number of flows cached, the least recently used flows are evicted
+	// first, DefaultCacheSize if not set
+	MaxSize int
+	// TTL is the time a flow is cached, flows don't expire if not set
+	TTL time.Duration
+}
+
+// CacheConfigFromEnv creates the CacheConfig defined by the environment
+func CacheConfigFromEnv() (*CacheConfig, error) {
+
+	config := &CacheConfig{}
+
+	if size := os.Getenv(EnvFlowCacheSize); len(size) > 0 {
+		var err error
+		config.MaxSize, err = strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %v", EnvFlowCacheSize, size, err)
+		}
+	}
+
+	if ttl := os.Getenv(EnvFlowCacheTTL); len(ttl) > 0 {
+		var err error
+		config.TTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %v", EnvFlowCacheTTL, ttl, err)
+		}
+	}
+
+	return config, nil
+}
+
+// flowCache is a LRU cache of flow definitions, the evicted definitions are disposed, their
+// activities are cleaned up once they aren't used by running instances anymore.  Concurrent
+// loads of the same flow are done once.
+type flowCache struct {
+	maxSize int
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	inflight map[string]*flowLoad
+}
+
+type cacheEntry struct {
+	uri      string
+	flow     *definition.Definition
+	loadedAt time.Time
+}
+
+type flowLoad struct {
+	done chan struct{}
+	flow *definition.Definition
+	err  error
+
+	// waiters is the number of callers waiting for the load, releases releases the flow
+	// acquired for the loader and for each of them
+	waiters  int
+	releases []func()
+}
+
+func newFlowCache(config *CacheConfig) *flowCache {
+
+	c := &flowCache{
+		maxSize:  DefaultCacheSize,
+		now:      time.Now,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[string]*flowLoad),
+	}
+
+	if config != nil {
+		if config.MaxSize > 0 {
+			c.maxSize = config.MaxSize
+		}
+		c.ttl = config.TTL
+	}
+
+	return c
+}
+
+// get returns the cached flow, loading it if it isn't cached or has expired.  The flow is
+// acquired while the cache is locked, so that it can't be cleaned up by an eviction before the
+// caller uses it, the returned function releases it.
+func (c *flowCache) get(uri string, load func() (*definition.Definition, error)) (*definition.Definition, func(), error) {
+
+	c.mu.Lock()
+
+	var evicted []*definition.Definition
+
+	if elem, exists := c.entries[uri]; exists {
+		entry := elem.Value.(*cacheEntry)
+		if c.ttl <= 0 || c.now().Sub(entry.loadedAt) < c.ttl {
+			c.lru.MoveToFront(elem)
+			release := entry.flow.Acquire()
+			c.mu.Unlock()
+			return entry.flow, release, nil
+		}
+		c.remove(elem)
+		evicted = append(evicted, entry.flow)
+	}
+
+	if call, loading := c.inflight[uri]; loading {
+		// the loader acquires the flow for each of the callers waiting for it
+		idx := call.waiters
+		call.waiters++
+		c.mu.Unlock()
+		cleanup(evicted)
+		<-call.done
+		if call.err != nil {
+			return nil, nil, call.err
+		}
+		return call.flow, call.releases[idx+1], nil
+	}
+
+	call := &flowLoad{done: make(chan struct{})}
+	c.inflight[uri] = call
+	c.mu.Unlock()
+
+	cleanup(evicted)
+
+	c.load(uri, call, load)
+
+	if call.err != nil {
+		return nil, nil, call.err
+	}
+	return call.flow, call.releases[0], nil
+}
+
+// load loads the flow and caches it, the waiting callers are notified even if the load panics
+func (c *flowCache) load(uri string, call *flowLoad, load func() (*definition.Definition, error)) {
+
+	var evicted []*definition.Definition
+
+	defer func() {
+		r := recover()
+		if r != nil {
+			call.flow, call.err = nil, fmt.Errorf("panic loading flow '%s': %v", uri, r)
+		}
+
+		c.mu.Lock()
+		delete(c.inflight, uri)
+		if call.err == nil {
+			c.entries[uri] = c.lru.PushFront(&cacheEntry{uri: uri, flow: call.flow, loadedAt: c.now()})
+			call.releases = make([]func(), call.waiters+1)
+			for i := range call.releases {
+				call.releases[i] = call.flow.Acquire()
+			}
+			evicted = c.evictOverflow()
+		}
+		c.mu.Unlock()
+
+		close(call.done)
+		cleanup(evicted)
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	call.flow, call.err = load()
+}
+
+// len returns the number of cached flows
+func (c *flowCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *flowCache) evictOverflow() []*definition.Definition {
+
+	var evicted []*definition.Definition
+
+	for c.lru.Len() > c.maxSize {
+		elem := c.lru.Back()
+		c.remove(elem)
+		evicted = append(evicted, elem.Value.(*cacheEntry).flow)
+	}
+
+	return evicted
+}
+
+func (c *flowCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).uri)
+}
+
+func cleanup(evicted []*definition.Definition) {
+	for _, flow := range evicted {
+		if flow.InUse() {
+			log.RootLogger().Debugf("Evicting flow '%s' from cache, it will be cleaned up once unused", flow.Name())
+		} else {
+			log.RootLogger().Debugf("Evicting flow '%s' from cache", flow.Name())
+		}
+		flow.Dispose()
+	}
+}
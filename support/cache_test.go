@@ -0,0 +1,209 @@
+package support
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qingcloudhx/core/activity"
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	_ = activity.Register(&cleanupActivity{}, func(ctx activity.InitContext) (activity.Activity, error) {
+		return &cleanupActivity{}, nil
+	})
+}
+
+var cleanedUp int32
+
+// cleanupActivity counts the times it is cleaned up
+type cleanupActivity struct {
+}
+
+func (a *cleanupActivity) Metadata() *activity.Metadata {
+	return activity.ToMetadata()
+}
+
+func (a *cleanupActivity) Eval(ctx activity.Context) (done bool, err error) {
+	return true, nil
+}
+
+func (a *cleanupActivity) Cleanup() error {
+	atomic.AddInt32(&cleanedUp, 1)
+	return nil
+}
+
+// countingFlowProvider counts the times each flow is loaded
+type countingFlowProvider struct {
+	mu    sync.Mutex
+	loads map[string]int
+	delay time.Duration
+}
+
+func (p *countingFlowProvider) GetFlow(flowURI string) (*definition.DefinitionRep, error) {
+
+	time.Sleep(p.delay)
+
+	p.mu.Lock()
+	p.loads[flowURI]++
+	p.mu.Unlock()
+
+	rep := &definition.DefinitionRep{Name: flowURI}
+	rep.Tasks = []*definition.TaskRep{{ID: "cleanup", ActivityCfgRep: &activity.Config{Ref: "github.com/qingcloudhx/flow/support"}}}
+	return rep, nil
+}
+
+func (p *countingFlowProvider) count(flowURI string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.loads[flowURI]
+}
+
+func TestFlowManager_Eviction(t *testing.T) {
+
+	provider := &countingFlowProvider{loads: make(map[string]int)}
+	fm := NewFlowManagerWithCache(provider, &CacheConfig{MaxSize: 2})
+
+	atomic.StoreInt32(&cleanedUp, 0)
+
+	for _, uri := range []string{"flow1", "flow2", "flow1", "flow3"} {
+		flow, err := fm.GetFlow(uri)
+		assert.Nil(t, err)
+		assert.Equal(t, uri, flow.Name())
+	}
+
+	// flow2 is the least recently used
+	assert.Equal(t, 2, fm.flows.len())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cleanedUp))
+
+	_, _ = fm.GetFlow("flow1")
+	assert.Equal(t, 1, provider.count("flow1"))
+	_, _ = fm.GetFlow("flow2")
+	assert.Equal(t, 2, provider.count("flow2"))
+}
+
+func TestFlowManager_TTL(t *testing.T) {
+
+	provider := &countingFlowProvider{loads: make(map[string]int)}
+	fm := NewFlowManagerWithCache(provider, &CacheConfig{TTL: time.Minute})
+
+	now := time.Now()
+	fm.flows.now = func() time.Time { return now }
+
+	_, _ = fm.GetFlow("flow")
+	_, _ = fm.GetFlow("flow")
+	assert.Equal(t, 1, provider.count("flow"))
+
+	now = now.Add(2 * time.Minute)
+	_, _ = fm.GetFlow("flow")
+	assert.Equal(t, 2, provider.count("flow"))
+}
+
+func TestFlowManager_SingleFlight(t *testing.T) {
+
+	provider := &countingFlowProvider{loads: make(map[string]int), delay: 50 * time.Millisecond}
+	fm := NewFlowManager(provider)
+
+	var wg sync.WaitGroup
+	flows := make([]*definition.Definition, 10)
+	for i := range flows {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			flows[i], _ = fm.GetFlow(fmt.Sprintf("flow%d", i%2))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, provider.count("flow0"))
+	assert.Equal(t, 1, provider.count("flow1"))
+	for i := range flows {
+		assert.True(t, flows[i] == flows[i%2])
+	}
+}
+
+func TestFlowManager_AcquireFlow(t *testing.T) {
+
+	provider := &countingFlowProvider{loads: make(map[string]int), delay: 20 * time.Millisecond}
+	fm := NewFlowManagerWithCache(provider, &CacheConfig{MaxSize: 1})
+
+	atomic.StoreInt32(&cleanedUp, 0)
+
+	// the concurrent callers of a load each get an acquired flow
+	var wg sync.WaitGroup
+	releases := make([]func(), 5)
+	var flow *definition.Definition
+	for i := range releases {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			def, release, err := fm.AcquireFlow("flow1")
+			assert.Nil(t, err)
+			releases[i] = release
+			if i == 0 {
+				flow = def
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// flow1 is evicted but isn't cleaned up while acquired
+	_, err := fm.GetFlow("flow2")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&cleanedUp))
+
+	for i, release := range releases {
+		assert.True(t, flow.InUse())
+		release()
+		if i < len(releases)-1 {
+			assert.Equal(t, int32(0), atomic.LoadInt32(&cleanedUp))
+		}
+	}
+
+	assert.False(t, flow.InUse())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cleanedUp))
+}
+
+// panicFlowProvider panics on the first load of a flow
+type panicFlowProvider struct {
+	countingFlowProvider
+}
+
+func (p *panicFlowProvider) GetFlow(flowURI string) (*definition.DefinitionRep, error) {
+	if p.count(flowURI) == 0 {
+		p.mu.Lock()
+		p.loads[flowURI]++
+		p.mu.Unlock()
+		panic("load failed")
+	}
+	return p.countingFlowProvider.GetFlow(flowURI)
+}
+
+func TestFlowManager_LoadPanic(t *testing.T) {
+
+	provider := &panicFlowProvider{countingFlowProvider{loads: make(map[string]int)}}
+	fm := NewFlowManager(provider)
+
+	assert.Panics(t, func() {
+		_, _ = fm.GetFlow("flow1")
+	})
+
+	// the failed load doesn't block the next callers
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		flow, err := fm.GetFlow("flow1")
+		assert.Nil(t, err)
+		assert.Equal(t, "flow1", flow.Name())
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("load of the flow blocked")
+	}
+}
@@ -42,3 +42,21 @@ func GetDefinition(flowURI string) (*definition.Definition, bool, error) {
 
 	return nil, false, nil
 }
+
+// AcquireDefinition gets the definition like GetDefinition and acquires it, it isn't cleaned up
+// until the returned function is called
+func AcquireDefinition(flowURI string) (*definition.Definition, func(), bool, error) {
+
+	if strings.HasPrefix(flowURI, resource.UriScheme) {
+		// the flow resources are never cleaned up
+		def, res, err := GetDefinition(flowURI)
+		return def, func() {}, res, err
+	}
+
+	def, release, err := flowManager.AcquireFlow(flowURI)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	return def, release, false, nil
+}
@@ -0,0 +1,333 @@
+package support
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/qingcloudhx/core/data/metadata"
+	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/definition"
+)
+
+const uriSchemeFlow = "flow://"
+
+// flowFileExts are the extensions of the flow files of a repository, in order of preference
+var flowFileExts = []string{".json", ".json.gz", ".yaml", ".yaml.gz", ".yml", ".yml.gz", ".gz"}
+
+// FlowInfo describes a flow of a repository
+type FlowInfo struct {
+	// Name is the logical name of the flow, the path of its file relative to the root of the
+	// repository without the extension, ex. "orders/create"
+	Name string `json:"name"`
+	// URI is the URI that resolves the flow, ex. "flow://orders/create"
+	URI string `json:"uri"`
+	// Path is the path of the file of the flow relative to the root of the repository
+	Path string `json:"path"`
+
+	FlowName string               `json:"flowName,omitempty"`
+	ModelID  string               `json:"model,omitempty"`
+	Metadata *metadata.IOMetadata `json:"metadata,omitempty"`
+
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// DirectoryFlowProvider is a definition.Provider that resolves the flows of a directory, it
+// indexes the flow files (.json, .yaml/.yml or gzipped .json.gz/.gz) found under its root and
+// resolves them by their logical name using the "flow://" scheme, ex. "flow://orders/create"
+// for <root>/orders/create.json.  The other URIs are delegated to its fallback provider.  Like
+// the remote flows, the URI can have a checksum, ex. "flow://orders/create#sha256=9f86d0...",
+// and the signature of a flow file is read from <file>.sig.
+type DirectoryFlowProvider struct {
+	root     string
+	fallback definition.Provider
+	verifier *FlowVerifier
+	logger   log.Logger
+
+	mu    sync.RWMutex
+	index map[string]*FlowInfo
+}
+
+// NewDirectoryFlowProvider creates a DirectoryFlowProvider for the directory and indexes its
+// flows, fallback resolves the URIs that don't use the "flow://" scheme and can be nil
+func NewDirectoryFlowProvider(root string, fallback definition.Provider) (*DirectoryFlowProvider, error) {
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open flow repository '%s': %s", root, err.Error())
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("flow repository '%s' is not a directory", root)
+	}
+
+	p := &DirectoryFlowProvider{root: root, fallback: fallback, logger: log.ChildLogger(log.RootLogger(), "flow")}
+
+	if err := p.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// SetVerifier implements VerifyingProvider.SetVerifier, the verifier is also set on the
+// fallback provider
+func (p *DirectoryFlowProvider) SetVerifier(verifier *FlowVerifier) error {
+	p.verifier = verifier
+	return setFallbackVerifier(p.fallback, verifier)
+}
+
+// Refresh re-indexes the flows of the directory
+func (p *DirectoryFlowProvider) Refresh() error {
+
+	index := make(map[string]*FlowInfo)
+
+	err := filepath.Walk(p.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(p.root, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		name, ok := flowName(relPath)
+		if !ok {
+			return nil
+		}
+
+		if existing, exists := index[name]; exists {
+			if flowExtRank(existing.Path) <= flowExtRank(relPath) {
+				p.logger.Warnf("Ignoring flow file '%s', flow '%s' already defined by '%s'", relPath, name, existing.Path)
+				return nil
+			}
+			p.logger.Warnf("Ignoring flow file '%s', flow '%s' also defined by '%s'", existing.Path, name, relPath)
+		}
+
+		flowInfo, err := readFlowInfo(path, relPath, name, info)
+		if err != nil {
+			p.logger.Warnf("Ignoring flow file '%s': %s", relPath, err.Error())
+			return nil
+		}
+
+		index[name] = flowInfo
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("error indexing flow repository '%s': %s", p.root, err.Error())
+	}
+
+	p.mu.Lock()
+	p.index = index
+	p.mu.Unlock()
+
+	p.logger.Debugf("Indexed %d flows in repository '%s'", len(index), p.root)
+
+	return nil
+}
+
+// List returns the flows of the directory sorted by name
+func (p *DirectoryFlowProvider) List() []*FlowInfo {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	flows := make([]*FlowInfo, 0, len(p.index))
+	for _, flowInfo := range p.index {
+		flows = append(flows, flowInfo)
+	}
+
+	sort.Slice(flows, func(i, j int) bool { return flows[i].Name < flows[j].Name })
+
+	return flows
+}
+
+// Lookup returns the flow with the logical name
+func (p *DirectoryFlowProvider) Lookup(name string) (*FlowInfo, bool) {
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	flowInfo, ok := p.index[strings.Trim(name, "/")]
+	return flowInfo, ok
+}
+
+// GetFlow implements definition.Provider.GetFlow
+func (p *DirectoryFlowProvider) GetFlow(flowURI string) (*definition.DefinitionRep, error) {
+
+	if !strings.HasPrefix(flowURI, uriSchemeFlow) {
+		if p.fallback != nil {
+			return p.fallback.GetFlow(flowURI)
+		}
+		return nil, fmt.Errorf("unsupport uri %s", flowURI)
+	}
+
+	flowURI, checksum, err := SplitChecksum(flowURI)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimPrefix(flowURI, uriSchemeFlow)
+
+	flowInfo, ok := p.Lookup(name)
+	if !ok {
+		// the flow could have been added since the directory was indexed
+		flowInfo, ok = p.indexFlow(strings.Trim(name, "/"))
+		if !ok {
+			return nil, fmt.Errorf("flow '%s' not found in repository '%s'", name, p.root)
+		}
+	}
+
+	p.logger.Infof("Loading Repository Flow: %s\n", flowURI)
+
+	flowPath := filepath.Join(p.root, filepath.FromSlash(flowInfo.Path))
+
+	readBytes, err := ioutil.ReadFile(flowPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading flow with uri '%s', %s", flowURI, err.Error())
+	}
+
+	var signature []byte
+	if p.verifier != nil {
+		signature, err = ioutil.ReadFile(flowPath + signatureExt)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading signature of flow with uri '%s', %s", flowURI, err.Error())
+		}
+	}
+
+	if err := verifyFlow(readBytes, checksum, p.verifier, signature); err != nil {
+		return nil, fmt.Errorf("error verifying flow with uri '%s', %s", flowURI, err.Error())
+	}
+
+	flow, err := decodeFlow(readBytes, definition.IsYAMLFile(flowInfo.Path))
+	if err != nil {
+		return nil, fmt.Errorf("error loading flow with uri '%s', %s", flowURI, err.Error())
+	}
+
+	return flow, nil
+}
+
+// indexFlow adds the file of the flow with the logical name to the index if it exists, only
+// the candidate files of the flow are checked instead of re-indexing the directory
+func (p *DirectoryFlowProvider) indexFlow(name string) (*FlowInfo, bool) {
+
+	// the name must not escape the root of the repository
+	if name == "" || path.Clean("/"+name) != "/"+name {
+		return nil, false
+	}
+
+	for _, ext := range flowFileExts {
+		relPath := name + ext
+		if n, _ := flowName(relPath); n != name {
+			continue
+		}
+
+		filePath := filepath.Join(p.root, filepath.FromSlash(relPath))
+		info, err := os.Stat(filePath)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		flowInfo, err := readFlowInfo(filePath, relPath, name, info)
+		if err != nil {
+			p.logger.Warnf("Ignoring flow file '%s': %s", relPath, err.Error())
+			return nil, false
+		}
+
+		p.mu.Lock()
+		if p.index == nil {
+			p.index = make(map[string]*FlowInfo)
+		}
+		p.index[name] = flowInfo
+		p.mu.Unlock()
+
+		return flowInfo, true
+	}
+
+	return nil, false
+}
+
+// flowName returns the logical name of the flow file
+func flowName(relPath string) (string, bool) {
+
+	for _, ext := range flowFileExts {
+		if strings.HasSuffix(relPath, ext) {
+			return strings.TrimSuffix(relPath, ext), true
+		}
+	}
+
+	return "", false
+}
+
+func flowExtRank(relPath string) int {
+
+	for i, ext := range flowFileExts {
+		if strings.HasSuffix(relPath, ext) {
+			return i
+		}
+	}
+
+	return len(flowFileExts)
+}
+
+// readFlowInfo reads the name, model and metadata of the flow file
+func readFlowInfo(filePath, relPath, name string, info os.FileInfo) (*FlowInfo, error) {
+
+	readBytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	flow, err := decodeFlow(readBytes, definition.IsYAMLFile(filePath))
+	if err != nil {
+		return nil, err
+	}
+
+	return &FlowInfo{Name: name, URI: uriSchemeFlow + name, Path: relPath, FlowName: flow.Name, ModelID: flow.ModelID,
+		Metadata: flow.Metadata, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// decodeFlow decodes the JSON or YAML flow definition, uncompressing it if it is gzipped
+func decodeFlow(flowDefBytes []byte, yamlFormat bool) (*definition.DefinitionRep, error) {
+
+	if isGzip(flowDefBytes) {
+		var err error
+		flowDefBytes, err = unzip(flowDefBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var flow *definition.DefinitionRep
+	if yamlFormat {
+		var err error
+		if flow, err = definition.DecodeYAML(flowDefBytes); err != nil {
+			return nil, err
+		}
+	} else if err := json.Unmarshal(flowDefBytes, &flow); err != nil {
+		return nil, err
+	}
+	if flow == nil {
+		return nil, errors.New("empty flow definition")
+	}
+
+	return flow, nil
+}
+
+func isGzip(b []byte) bool {
+	return len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b
+}
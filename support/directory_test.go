@@ -0,0 +1,80 @@
+package support
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFlowFile(t *testing.T, root, relPath string, content string, compress bool) {
+
+	path := filepath.Join(root, filepath.FromSlash(relPath))
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+
+	data := []byte(content)
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(data)
+		assert.Nil(t, zw.Close())
+		data = buf.Bytes()
+	}
+
+	assert.Nil(t, ioutil.WriteFile(path, data, 0644))
+}
+
+func TestDirectoryFlowProvider(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "flows")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	writeFlowFile(t, root, "orders/create.json", `{"name":"CreateOrder","model":"flogo-simple","metadata":{"input":[{"name":"orderId","type":"string"}]},"tasks":[]}`, false)
+	writeFlowFile(t, root, "orders/cancel.json.gz", `{"name":"CancelOrder","tasks":[]}`, true)
+	writeFlowFile(t, root, "orders/refund.yaml", "name: RefundOrder\ntasks: []\n", false)
+	writeFlowFile(t, root, "orders/README.md", `not a flow`, false)
+	writeFlowFile(t, root, "broken.json", `{`, false)
+
+	p, err := NewDirectoryFlowProvider(root, nil)
+	assert.Nil(t, err)
+
+	flows := p.List()
+	if assert.Len(t, flows, 3) {
+		assert.Equal(t, "orders/cancel", flows[0].Name)
+		assert.Equal(t, "flow://orders/cancel", flows[0].URI)
+		assert.Equal(t, "orders/create", flows[1].Name)
+		assert.Equal(t, "CreateOrder", flows[1].FlowName)
+		assert.Equal(t, "flogo-simple", flows[1].ModelID)
+		assert.Contains(t, flows[1].Metadata.Input, "orderId")
+		assert.Equal(t, "RefundOrder", flows[2].FlowName)
+	}
+
+	rep, err := p.GetFlow("flow://orders/cancel")
+	assert.Nil(t, err)
+	assert.Equal(t, "CancelOrder", rep.Name)
+
+	// flows added after the indexing are found
+	writeFlowFile(t, root, "payments/refund.json", `{"name":"Refund","tasks":[]}`, false)
+	rep, err = p.GetFlow("flow://payments/refund")
+	assert.Nil(t, err)
+	assert.Equal(t, "Refund", rep.Name)
+
+	// a miss only checks the files of the flow, the directory isn't re-indexed
+	writeFlowFile(t, root, "payments/charge.json", `{"name":"Charge","tasks":[]}`, false)
+	_, err = p.GetFlow("flow://orders/unknown")
+	assert.NotNil(t, err)
+	_, ok := p.Lookup("payments/charge")
+	assert.False(t, ok)
+	assert.Len(t, p.List(), 4)
+
+	_, err = p.GetFlow("flow://../" + filepath.Base(root) + "/orders/create")
+	assert.NotNil(t, err)
+
+	_, err = p.GetFlow("file:///tmp/flow.json")
+	assert.NotNil(t, err)
+}
@@ -15,11 +15,17 @@ const (
 	SKIPPED   = "Skipped"
 	STARTED   = "Started"
 	WAITING   = "Waiting"
-	UNKNOWN   = "Created"
+	UNKNOWN   = "Unknown"
+
+	// TRUE and FALSE are the results of the evaluation of a link
+	TRUE  = "True"
+	FALSE = "False"
 )
 
 const FlowEventType = "flowevent"
 const TaskEventType = "taskevent"
+const LinkEventType = "linkevent"
+const IterationEventType = "iterationevent"
 
 // FlowEvent provides access to flow instance execution details
 type FlowEvent interface {
@@ -41,6 +47,14 @@ type FlowEvent interface {
 	FlowOutput() map[string]interface{}
 	// Returns error for failed flow instance
 	FlowError() error
+	// In case of subflow, returns its ID, 0 otherwise
+	SubFlowID() int
+	// Returns the ID of the step of the instance during which the event occurred
+	StepID() int
+	// Returns the time the flow instance started
+	StartTime() time.Time
+	// Returns the time elapsed between the start of the flow instance and the event
+	Duration() time.Duration
 }
 
 // TaskEvent provides access to task instance execution details
@@ -63,4 +77,70 @@ type TaskEvent interface {
 	TaskOutput() map[string]interface{}
 	// Returns error for failed task
 	TaskError() error
+	// Returns task ID
+	TaskID() string
+	// In case of a task of a subflow, returns the ID of the subflow, 0 otherwise
+	SubFlowID() int
+	// Returns the ID of the step of the instance during which the event occurred
+	StepID() int
+	// Returns the time the task was entered
+	StartTime() time.Time
+	// Returns the time elapsed between the entry of the task and the event
+	Duration() time.Duration
+	// Returns the index of the current iteration of an iterator task
+	Iteration() int
+	// Returns the number of times the execution of the task was repeated, not counting iterations
+	RetryCount() int
+}
+
+// LinkEvent provides access to the evaluation of a link
+type LinkEvent interface {
+	// Returns flow name
+	FlowName() string
+	// Returns flow ID
+	FlowID() string
+	// In case of a link of a subflow, returns the ID of the subflow, 0 otherwise
+	SubFlowID() int
+	// Returns the ID of the step of the instance during which the event occurred
+	StepID() int
+	// Returns link ID
+	LinkID() int
+	// Returns the ID of the task the link starts from
+	FromTask() string
+	// Returns the ID of the task the link leads to
+	ToTask() string
+	// Returns the result of the evaluation of the link: TRUE, FALSE or SKIPPED
+	LinkStatus() Status
+	// Returns event time
+	Time() time.Time
+}
+
+// IterationEvent provides access to the start and end of an iteration of an iterator task
+type IterationEvent interface {
+	// Returns flow name
+	FlowName() string
+	// Returns flow ID
+	FlowID() string
+	// In case of a task of a subflow, returns the ID of the subflow, 0 otherwise
+	SubFlowID() int
+	// Returns the ID of the step of the instance during which the event occurred
+	StepID() int
+	// Returns task name
+	TaskName() string
+	// Returns task ID
+	TaskID() string
+	// Returns the index of the iteration
+	Iteration() int
+	// Returns the key of the iteration, the index or the key of the iterated element
+	IterationKey() interface{}
+	// Returns STARTED when the iteration starts, COMPLETED or FAILED when it ends
+	IterationStatus() Status
+	// Returns event time
+	Time() time.Time
+	// Returns the time the iteration started
+	StartTime() time.Time
+	// Returns the time elapsed between the start of the iteration and the event
+	Duration() time.Duration
+	// Returns error for failed iteration
+	IterationError() error
 }
@@ -0,0 +1,186 @@
+package sink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	coreevent "github.com/qingcloudhx/core/engine/event"
+)
+
+const (
+	// DefaultMaxSize is the default size, in bytes, above which the file of a FileSink is rotated
+	DefaultMaxSize = 100 * 1024 * 1024
+	// DefaultMaxBackups is the default number of rotated files kept by a FileSink
+	DefaultMaxBackups = 5
+)
+
+// FileConfig is the configuration of a FileSink
+type FileConfig struct {
+	// Path is the path of the file
+	Path string
+	// MaxSize is the size, in bytes, above which the file is rotated, DefaultMaxSize if not set
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept, DefaultMaxBackups if not set
+	MaxBackups int
+	// Filter selects the records written to the file
+	Filter *Filter
+}
+
+// FileSink is an event listener that appends the records of the events to a JSON-lines file.
+// When the file gets too big it is renamed to <path>.1, the previous <path>.1 to <path>.2 and
+// so on, the oldest file is removed.
+type FileSink struct {
+	mu     sync.Mutex
+	config FileConfig
+	file   *os.File
+	size   int64
+}
+
+// NewFileSink creates a FileSink, opening or creating its file
+func NewFileSink(config FileConfig) (*FileSink, error) {
+
+	if config.Path == "" {
+		return nil, errors.New("path of the audit file not specified")
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultMaxSize
+	}
+	if config.MaxBackups <= 0 {
+		config.MaxBackups = DefaultMaxBackups
+	}
+
+	s := &FileSink{config: config}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// HandleEvent implements event.Listener.HandleEvent
+func (s *FileSink) HandleEvent(ctx *coreevent.Context) error {
+
+	r, err := NewRecord(ctx.GetEventType(), ctx.GetEvent())
+	if err != nil {
+		return err
+	}
+
+	return s.Write(r)
+}
+
+// Write appends the record to the file if it is selected by the filter
+func (s *FileSink) Write(r *Record) error {
+
+	if !s.config.Filter.Matches(r) {
+		return nil
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("audit file '%s' is closed", s.config.Path)
+	}
+
+	var rotateErr error
+	if s.size > 0 && s.size+int64(len(b)) > s.config.MaxSize {
+		rotateErr = s.rotate()
+		if s.file == nil {
+			return rotateErr
+		}
+	}
+
+	n, err := s.file.Write(b)
+	s.size += int64(n)
+
+	if err != nil {
+		return err
+	}
+
+	// the record is written even if the rotation failed
+	return rotateErr
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
+
+func (s *FileSink) open() error {
+
+	f, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.file = f
+	s.size = info.Size()
+
+	return nil
+}
+
+// rotate renames the files and opens a new file, if a rename fails the file is reopened so
+// that the records are still written and the rotation is retried on the next write
+func (s *FileSink) rotate() error {
+
+	err := s.file.Close()
+	s.file = nil
+
+	if err == nil {
+		err = s.renameFiles()
+	}
+
+	if openErr := s.open(); openErr != nil {
+		return openErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to rotate audit file '%s': %s", s.config.Path, err.Error())
+	}
+
+	return nil
+}
+
+func (s *FileSink) renameFiles() error {
+
+	path := s.config.Path
+	_ = os.Remove(backupPath(path, s.config.MaxBackups))
+
+	for i := s.config.MaxBackups - 1; i > 0; i-- {
+		if err := os.Rename(backupPath(path, i), backupPath(path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(path, backupPath(path, 1))
+}
+
+func backupPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
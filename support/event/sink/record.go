@@ -0,0 +1,162 @@
+package sink
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/qingcloudhx/flow/support/event"
+)
+
+// EventTypes are the types of the events handled by the sinks
+var EventTypes = []string{event.FlowEventType, event.TaskEventType, event.LinkEventType, event.IterationEventType}
+
+// Record is the representation of an event written by the sinks
+type Record struct {
+	Type   string       `json:"type"`
+	Time   time.Time    `json:"time"`
+	Status event.Status `json:"status"`
+
+	FlowName       string `json:"flowName"`
+	FlowID         string `json:"flowId"`
+	ParentFlowName string `json:"parentFlowName,omitempty"`
+	ParentFlowID   string `json:"parentFlowId,omitempty"`
+	SubFlowID      int    `json:"subFlowId,omitempty"`
+	StepID         int    `json:"stepId"`
+
+	TaskName   string `json:"taskName,omitempty"`
+	TaskID     string `json:"taskId,omitempty"`
+	TaskType   string `json:"taskType,omitempty"`
+	Iteration  *int   `json:"iteration,omitempty"`
+	RetryCount int    `json:"retryCount,omitempty"`
+
+	LinkID   int    `json:"linkId,omitempty"`
+	FromTask string `json:"fromTask,omitempty"`
+	ToTask   string `json:"toTask,omitempty"`
+
+	// DurationMs is the time elapsed, in milliseconds, since the start of the flow, task or iteration
+	DurationMs float64 `json:"durationMs,omitempty"`
+
+	Input  map[string]interface{} `json:"input,omitempty"`
+	Output map[string]interface{} `json:"output,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// NewRecord creates the Record of an event
+func NewRecord(eventType string, evt interface{}) (*Record, error) {
+
+	r := &Record{Type: eventType}
+
+	switch e := evt.(type) {
+	case event.FlowEvent:
+		r.Time, r.Status = e.Time(), e.FlowStatus()
+		r.FlowName, r.FlowID = e.FlowName(), e.FlowID()
+		r.ParentFlowName, r.ParentFlowID = e.ParentFlowName(), e.ParentFlowID()
+		r.SubFlowID, r.StepID = e.SubFlowID(), e.StepID()
+		r.DurationMs = toMillis(e.Duration())
+		r.Input, r.Output = nonEmpty(e.FlowInput()), nonEmpty(e.FlowOutput())
+		r.Error = errorString(e.FlowError())
+	case event.TaskEvent:
+		r.Time, r.Status = e.Time(), e.TaskStatus()
+		r.FlowName, r.FlowID = e.FlowName(), e.FlowID()
+		r.SubFlowID, r.StepID = e.SubFlowID(), e.StepID()
+		r.TaskName, r.TaskID, r.TaskType = e.TaskName(), e.TaskID(), e.TaskType()
+		r.RetryCount = e.RetryCount()
+		if iteration := e.Iteration(); iteration > 0 {
+			r.Iteration = &iteration
+		}
+		r.DurationMs = toMillis(e.Duration())
+		r.Input, r.Output = nonEmpty(e.TaskInput()), nonEmpty(e.TaskOutput())
+		r.Error = errorString(e.TaskError())
+	case event.LinkEvent:
+		r.Time, r.Status = e.Time(), e.LinkStatus()
+		r.FlowName, r.FlowID = e.FlowName(), e.FlowID()
+		r.SubFlowID, r.StepID = e.SubFlowID(), e.StepID()
+		r.LinkID, r.FromTask, r.ToTask = e.LinkID(), e.FromTask(), e.ToTask()
+	case event.IterationEvent:
+		r.Time, r.Status = e.Time(), e.IterationStatus()
+		r.FlowName, r.FlowID = e.FlowName(), e.FlowID()
+		r.SubFlowID, r.StepID = e.SubFlowID(), e.StepID()
+		r.TaskName, r.TaskID = e.TaskName(), e.TaskID()
+		iteration := e.Iteration()
+		r.Iteration = &iteration
+		if e.IterationStatus() != event.STARTED {
+			r.DurationMs = toMillis(e.Duration())
+		}
+		r.Error = errorString(e.IterationError())
+	default:
+		return nil, fmt.Errorf("unsupported event '%s' of type %T", eventType, evt)
+	}
+
+	return r, nil
+}
+
+func toMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func nonEmpty(values map[string]interface{}) map[string]interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// Filter selects the records written by a sink, an empty criterion matches all the records
+type Filter struct {
+	// FlowNames are the names of the flows, or path.Match patterns of the names
+	FlowNames []string
+	// Statuses are the statuses of the events
+	Statuses []event.Status
+	// EventTypes are the types of the events
+	EventTypes []string
+}
+
+// Matches indicates if the record is selected by the filter, a nil Filter matches all the records
+func (f *Filter) Matches(r *Record) bool {
+
+	if f == nil {
+		return true
+	}
+
+	if len(f.FlowNames) > 0 && !matchesAny(f.FlowNames, r.FlowName) {
+		return false
+	}
+
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			if status == r.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.EventTypes) > 0 && !matchesAny(f.EventTypes, r.Type) {
+		return false
+	}
+
+	return true
+}
+
+func matchesAny(patterns []string, value string) bool {
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched || pattern == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,209 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/qingcloudhx/flow/support/event"
+	"github.com/stretchr/testify/assert"
+)
+
+type testFlowEvent struct {
+	name, id string
+	status   event.Status
+	start    time.Time
+	err      error
+}
+
+func (e *testFlowEvent) FlowName() string                   { return e.name }
+func (e *testFlowEvent) FlowID() string                     { return e.id }
+func (e *testFlowEvent) ParentFlowName() string             { return "" }
+func (e *testFlowEvent) ParentFlowID() string               { return "" }
+func (e *testFlowEvent) Time() time.Time                    { return e.start.Add(2 * time.Millisecond) }
+func (e *testFlowEvent) FlowStatus() event.Status           { return e.status }
+func (e *testFlowEvent) FlowInput() map[string]interface{}  { return map[string]interface{}{"in": 1} }
+func (e *testFlowEvent) FlowOutput() map[string]interface{} { return nil }
+func (e *testFlowEvent) FlowError() error                   { return e.err }
+func (e *testFlowEvent) SubFlowID() int                     { return 0 }
+func (e *testFlowEvent) StepID() int                        { return 4 }
+func (e *testFlowEvent) StartTime() time.Time               { return e.start }
+func (e *testFlowEvent) Duration() time.Duration            { return e.Time().Sub(e.start) }
+
+func TestNewRecord(t *testing.T) {
+
+	evt := &testFlowEvent{name: "orders", id: "1", status: event.FAILED, start: time.Now(), err: errors.New("boom")}
+
+	r, err := NewRecord(event.FlowEventType, evt)
+	assert.Nil(t, err)
+	assert.Equal(t, event.FlowEventType, r.Type)
+	assert.Equal(t, "orders", r.FlowName)
+	assert.Equal(t, event.Status(event.FAILED), r.Status)
+	assert.Equal(t, 4, r.StepID)
+	assert.Equal(t, 2.0, r.DurationMs)
+	assert.Equal(t, "boom", r.Error)
+	assert.Nil(t, r.Output)
+
+	_, err = NewRecord("other", "not an event")
+	assert.NotNil(t, err)
+}
+
+func TestFilter_Matches(t *testing.T) {
+
+	r := &Record{Type: event.FlowEventType, FlowName: "orders-create", Status: event.COMPLETED}
+
+	var nilFilter *Filter
+	assert.True(t, nilFilter.Matches(r))
+	assert.True(t, (&Filter{FlowNames: []string{"orders-*"}}).Matches(r))
+	assert.False(t, (&Filter{FlowNames: []string{"payments"}}).Matches(r))
+	assert.True(t, (&Filter{Statuses: []event.Status{event.FAILED, event.COMPLETED}}).Matches(r))
+	assert.False(t, (&Filter{Statuses: []event.Status{event.FAILED}}).Matches(r))
+	assert.False(t, (&Filter{EventTypes: []string{event.TaskEventType}}).Matches(r))
+}
+
+func TestFileSink_Rotate(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	s, err := NewFileSink(FileConfig{Path: path, MaxSize: 150, MaxBackups: 2, Filter: &Filter{FlowNames: []string{"orders"}}})
+	assert.Nil(t, err)
+
+	for i := 0; i < 6; i++ {
+		assert.Nil(t, s.Write(&Record{Type: event.FlowEventType, FlowName: "orders", FlowID: "id", Status: event.STARTED}))
+		assert.Nil(t, s.Write(&Record{Type: event.FlowEventType, FlowName: "payments", FlowID: "id", Status: event.STARTED}))
+	}
+	assert.Nil(t, s.Close())
+
+	lines := 0
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		f, err := os.Open(name)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			r := &Record{}
+			assert.Nil(t, json.Unmarshal(scanner.Bytes(), r))
+			assert.Equal(t, "orders", r.FlowName)
+			lines++
+		}
+		f.Close()
+	}
+
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, lines > 0 && lines < 6)
+}
+
+func TestFileSink_RotateFailure(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+
+	// a non empty directory in place of the backup makes the rename fail
+	assert.Nil(t, os.MkdirAll(filepath.Join(path+".1", "blocked"), 0755))
+
+	s, err := NewFileSink(FileConfig{Path: path, MaxSize: 100, MaxBackups: 1})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	record := &Record{Type: event.FlowEventType, FlowName: "orders", FlowID: "id", Status: event.STARTED}
+
+	assert.Nil(t, s.Write(record))
+	assert.NotNil(t, s.Write(record))
+	assert.NotNil(t, s.Write(record))
+
+	// the sink is still open and the records were kept
+	b, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, strings.Count(string(b), "\n"))
+
+	// the rotation succeeds once the backup can be written
+	assert.Nil(t, os.RemoveAll(path+".1"))
+	assert.Nil(t, s.Write(record))
+
+	b, err = ioutil.ReadFile(path + ".1")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, strings.Count(string(b), "\n"))
+}
+
+func TestWebhookSink_BatchAndRetry(t *testing.T) {
+
+	var mu sync.Mutex
+	var requests int
+	var received []*Record
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var batch []*Record
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&batch))
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		received = append(received, batch...)
+	}))
+	defer server.Close()
+
+	s, err := NewWebhookSink(WebhookConfig{URL: server.URL, Headers: map[string]string{"X-Token": "secret"},
+		BatchSize: 2, FlushInterval: time.Hour, RetryBackoff: time.Millisecond})
+	assert.Nil(t, err)
+
+	for _, id := range []string{"1", "2", "3"} {
+		s.Write(&Record{Type: event.FlowEventType, FlowName: "orders", FlowID: id})
+	}
+	assert.Nil(t, s.Close())
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, 3, requests)
+	if assert.Len(t, received, 3) {
+		assert.Equal(t, "1", received[0].FlowID)
+		assert.Equal(t, "3", received[2].FlowID)
+	}
+	assert.Equal(t, uint64(0), s.Dropped())
+}
+
+func TestWebhookSink_DropWhenFull(t *testing.T) {
+
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+
+	s, err := NewWebhookSink(WebhookConfig{URL: server.URL, BatchSize: 1, BufferSize: 1, MaxRetries: -1})
+	assert.Nil(t, err)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		s.Write(&Record{Type: event.FlowEventType, FlowName: "orders"})
+	}
+	assert.True(t, time.Since(start) < time.Second)
+	assert.True(t, s.Dropped() > 0)
+
+	close(block)
+	assert.Nil(t, s.Close())
+}
@@ -0,0 +1,256 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	coreevent "github.com/qingcloudhx/core/engine/event"
+)
+
+const (
+	// DefaultBatchSize is the default maximum number of records sent in a request by a WebhookSink
+	DefaultBatchSize = 100
+	// DefaultBufferSize is the default number of records buffered by a WebhookSink
+	DefaultBufferSize = 1000
+	// DefaultFlushInterval is the default maximum time a record is buffered by a WebhookSink
+	DefaultFlushInterval = time.Second
+	// DefaultMaxRetries is the default number of times a WebhookSink retries to send a batch
+	DefaultMaxRetries = 3
+	// DefaultRetryBackoff is the default delay before the first retry of a WebhookSink
+	DefaultRetryBackoff = 500 * time.Millisecond
+	// DefaultTimeout is the default timeout of the requests of a WebhookSink
+	DefaultTimeout = 10 * time.Second
+)
+
+// WebhookConfig is the configuration of a WebhookSink
+type WebhookConfig struct {
+	// URL is the URL to which the batches of records are posted
+	URL string
+	// Headers are added to the requests
+	Headers map[string]string
+	// BatchSize is the maximum number of records sent in a request, DefaultBatchSize if not set
+	BatchSize int
+	// BufferSize is the number of records buffered, DefaultBufferSize if not set, the records
+	// received while the buffer is full are dropped
+	BufferSize int
+	// FlushInterval is the maximum time a record is buffered, DefaultFlushInterval if not set
+	FlushInterval time.Duration
+	// MaxRetries is the number of times a batch is retried, DefaultMaxRetries if not set, a
+	// negative value disables the retries
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, it is doubled after each retry,
+	// DefaultRetryBackoff if not set
+	RetryBackoff time.Duration
+	// Timeout is the timeout of the requests, DefaultTimeout if not set
+	Timeout time.Duration
+	// Filter selects the records sent
+	Filter *Filter
+	// OnError is called with the errors of the batches that could not be sent, it can be nil
+	OnError func(err error)
+}
+
+// WebhookSink is an event listener that posts the records of the events, as JSON arrays, to a
+// URL.  The records are buffered and sent in batches by a background goroutine so the execution
+// of the flows is never blocked, the records received while the buffer is full are dropped.
+type WebhookSink struct {
+	config WebhookConfig
+	client *http.Client
+
+	buffer  chan *Record
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	dropped uint64
+}
+
+// NewWebhookSink creates a WebhookSink and starts its background goroutine
+func NewWebhookSink(config WebhookConfig) (*WebhookSink, error) {
+
+	if config.URL == "" {
+		return nil, errors.New("URL of the webhook not specified")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
+	} else if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = DefaultRetryBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	s := &WebhookSink{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		buffer: make(chan *Record, config.BufferSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// HandleEvent implements event.Listener.HandleEvent
+func (s *WebhookSink) HandleEvent(ctx *coreevent.Context) error {
+
+	r, err := NewRecord(ctx.GetEventType(), ctx.GetEvent())
+	if err != nil {
+		return err
+	}
+
+	s.Write(r)
+	return nil
+}
+
+// Write buffers the record if it is selected by the filter, without blocking
+func (s *WebhookSink) Write(r *Record) {
+
+	if !s.config.Filter.Matches(r) {
+		return
+	}
+
+	select {
+	case <-s.stop:
+		atomic.AddUint64(&s.dropped, 1)
+	case s.buffer <- r:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns the number of records dropped because the buffer was full or the sink closed
+func (s *WebhookSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close sends the buffered records and stops the background goroutine
+func (s *WebhookSink) Close() error {
+
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+
+	return nil
+}
+
+func (s *WebhookSink) run() {
+
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Record, 0, s.config.BatchSize)
+
+	flush := func() {
+		if len(batch) > 0 {
+			s.send(batch)
+			batch = make([]*Record, 0, s.config.BatchSize)
+		}
+	}
+
+	for {
+		select {
+		case r := <-s.buffer:
+			batch = append(batch, r)
+			if len(batch) >= s.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-s.stop:
+			for {
+				select {
+				case r := <-s.buffer:
+					batch = append(batch, r)
+					if len(batch) >= s.config.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts the batch, retrying on network errors and on 5xx and 429 responses
+func (s *WebhookSink) send(batch []*Record) {
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		s.handleError(err)
+		return
+	}
+
+	backoff := s.config.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt >= s.config.MaxRetries {
+			s.handleError(fmt.Errorf("unable to send %d events to '%s': %v", len(batch), s.config.URL, err))
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (s *WebhookSink) post(body []byte) (retry bool, err error) {
+
+	req, err := http.NewRequest(http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+func (s *WebhookSink) handleError(err error) {
+	if s.config.OnError != nil {
+		s.config.OnError(err)
+	}
+}
@@ -0,0 +1,116 @@
+package support
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// FaultError makes the task fail with an error
+	FaultError = "error"
+	// FaultPanic makes the task panic outside of its activity
+	FaultPanic = "panic"
+	// FaultDelay delays the execution of the task
+	FaultDelay = "delay"
+	// FaultCorrupt replaces the outputs of the task
+	FaultCorrupt = "corrupt"
+)
+
+// FaultInjection contains a set of faults to inject in the tasks of an instance of a Flow,
+// this can be used to verify that the error handling of the flow works as expected.  The
+// seed of the random generator is recorded so that the faults of a run can be reproduced.
+type FaultInjection struct {
+	// Seed is the seed of the random generator, if not set one is generated by Init
+	Seed   int64    `json:"seed,omitempty"`
+	Faults []*Fault `json:"faults"`
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// Fault describes a fault injected in the tasks that match its task ID or activity ref
+type Fault struct {
+	// TaskID matches the ID of the task, empty matches all tasks
+	TaskID string `json:"task,omitempty"`
+	// ActivityRef matches the ref of the task's activity, empty matches all activities
+	ActivityRef string `json:"activityRef,omitempty"`
+	// Probability that the fault is injected in an execution of a matching task, if not set
+	// the fault is always injected and if 0 it is never injected
+	Probability *float64 `json:"probability,omitempty"`
+
+	// Type is the type of fault: error, panic, delay or corrupt
+	Type string `json:"type"`
+	// Message is the message of the error or panic
+	Message string `json:"message,omitempty"`
+	// Delay is the delay of the execution (ex. "100ms")
+	Delay string `json:"delay,omitempty"`
+	// Value replaces the value of each of the outputs of the task when corrupting them
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Init initializes the FaultInjection, generating its seed if needed
+func (fi *FaultInjection) Init() {
+
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	if fi.rnd != nil {
+		return
+	}
+
+	if fi.Seed == 0 {
+		fi.Seed = time.Now().UnixNano()
+	}
+
+	fi.rnd = rand.New(rand.NewSource(fi.Seed))
+}
+
+// Inject determines the fault to inject in an execution of the specified task, a random
+// number is drawn for each matching fault until one is selected
+func (fi *FaultInjection) Inject(taskID, activityRef string) *Fault {
+
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	if fi.rnd == nil {
+		return nil
+	}
+
+	for _, fault := range fi.Faults {
+
+		if fault.TaskID != "" && fault.TaskID != taskID {
+			continue
+		}
+
+		if fault.ActivityRef != "" && fault.ActivityRef != activityRef {
+			continue
+		}
+
+		if fault.Probability == nil {
+			return fault
+		}
+
+		if fi.rnd.Float64() < *fault.Probability {
+			return fault
+		}
+	}
+
+	return nil
+}
+
+// SetProbability sets the probability that the fault is injected
+func (f *Fault) SetProbability(probability float64) *Fault {
+	f.Probability = &probability
+	return f
+}
+
+// GetDelay returns the delay of the fault
+func (f *Fault) GetDelay() (time.Duration, error) {
+
+	if f.Delay == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(f.Delay)
+}
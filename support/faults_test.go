@@ -0,0 +1,31 @@
+package support
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultInjection_Probability(t *testing.T) {
+
+	var fi FaultInjection
+	err := json.Unmarshal([]byte(`{"seed":1,"faults":[
+		{"task":"never","type":"error","probability":0},
+		{"task":"always","type":"error"},
+		{"task":"half","type":"error","probability":0.5}]}`), &fi)
+	assert.Nil(t, err)
+
+	fi.Init()
+
+	injected := 0
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, fi.Inject("never", ""))
+		assert.NotNil(t, fi.Inject("always", ""))
+		if fi.Inject("half", "") != nil {
+			injected++
+		}
+	}
+
+	assert.True(t, injected > 0 && injected < 100)
+}
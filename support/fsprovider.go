@@ -0,0 +1,90 @@
+//go:build go1.16
+// +build go1.16
+
+package support
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/definition"
+)
+
+const uriSchemeEmbed = "embed://"
+
+// FSFlowProvider is a definition.Provider that resolves the "embed://" URIs using a fs.FS,
+// ex. an embed.FS compiled in the binary, "embed://flows/orders.json" resolves the file
+// "flows/orders.json" of the FS.  Gzipped and YAML flows are supported.  The other URIs, and the
+// "embed://" URIs of files missing from the FS, are delegated to its fallback provider.  The URI
+// can have a checksum and the signature of a flow file is read from <file>.sig.
+type FSFlowProvider struct {
+	fsys     fs.FS
+	fallback definition.Provider
+	verifier *FlowVerifier
+	logger   log.Logger
+}
+
+// NewFSFlowProvider creates a FSFlowProvider for the FS, fallback can be nil
+func NewFSFlowProvider(fsys fs.FS, fallback definition.Provider) *FSFlowProvider {
+	return &FSFlowProvider{fsys: fsys, fallback: fallback, logger: log.ChildLogger(log.RootLogger(), "flow")}
+}
+
+// SetVerifier implements VerifyingProvider.SetVerifier, the verifier is also set on the
+// fallback provider
+func (p *FSFlowProvider) SetVerifier(verifier *FlowVerifier) error {
+	p.verifier = verifier
+	return setFallbackVerifier(p.fallback, verifier)
+}
+
+// GetFlow implements definition.Provider.GetFlow
+func (p *FSFlowProvider) GetFlow(flowURI string) (*definition.DefinitionRep, error) {
+
+	if !strings.HasPrefix(flowURI, uriSchemeEmbed) {
+		if p.fallback != nil {
+			return p.fallback.GetFlow(flowURI)
+		}
+		return nil, fmt.Errorf("unsupport uri %s", flowURI)
+	}
+
+	uri, checksum, err := SplitChecksum(flowURI)
+	if err != nil {
+		return nil, err
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(uri, uriSchemeEmbed), "/")
+	if !fs.ValidPath(path) {
+		return nil, fmt.Errorf("invalid flow path in uri '%s'", uri)
+	}
+
+	readBytes, err := fs.ReadFile(p.fsys, path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && p.fallback != nil {
+			return p.fallback.GetFlow(flowURI)
+		}
+		return nil, fmt.Errorf("error reading flow with uri '%s', %s", uri, err.Error())
+	}
+
+	var signature []byte
+	if p.verifier != nil {
+		signature, err = fs.ReadFile(p.fsys, path+signatureExt)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("error reading signature of flow with uri '%s', %s", uri, err.Error())
+		}
+	}
+
+	if err := verifyFlow(readBytes, checksum, p.verifier, signature); err != nil {
+		return nil, fmt.Errorf("error verifying flow with uri '%s', %s", uri, err.Error())
+	}
+
+	p.logger.Infof("Loading Embedded Flow: %s\n", uri)
+
+	flow, err := decodeFlow(readBytes, definition.IsYAMLFile(path))
+	if err != nil {
+		return nil, fmt.Errorf("error loading flow with uri '%s', %s", uri, err.Error())
+	}
+
+	return flow, nil
+}
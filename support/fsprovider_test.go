@@ -0,0 +1,113 @@
+//go:build go1.16
+// +build go1.16
+
+package support
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"testing/fstest"
+
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticFlowProvider map[string]*definition.DefinitionRep
+
+func (p staticFlowProvider) GetFlow(flowURI string) (*definition.DefinitionRep, error) {
+	if rep, ok := p[flowURI]; ok {
+		return rep, nil
+	}
+	return nil, assert.AnError
+}
+
+func TestFSFlowProvider(t *testing.T) {
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(`{"name":"Compressed","tasks":[]}`))
+	assert.Nil(t, zw.Close())
+
+	fsys := fstest.MapFS{
+		"flows/orders.json":    {Data: []byte(`{"name":"Orders","tasks":[]}`)},
+		"flows/compressed.gz":  {Data: buf.Bytes()},
+		"flows/not-a-flow.txt": {Data: []byte(`{`)},
+	}
+
+	fallback := staticFlowProvider{
+		"embed://flows/other.json": {Name: "Other"},
+		"file:///flows/file.json":  {Name: "File"},
+	}
+
+	p := NewFSFlowProvider(fsys, fallback)
+
+	rep, err := p.GetFlow("embed://flows/orders.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "Orders", rep.Name)
+
+	rep, err = p.GetFlow("embed:///flows/compressed.gz")
+	assert.Nil(t, err)
+	assert.Equal(t, "Compressed", rep.Name)
+
+	// missing files and other schemes are resolved by the fallback
+	rep, err = p.GetFlow("embed://flows/other.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "Other", rep.Name)
+
+	rep, err = p.GetFlow("file:///flows/file.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "File", rep.Name)
+
+	_, err = p.GetFlow("embed://flows/not-a-flow.txt")
+	assert.NotNil(t, err)
+
+	_, err = p.GetFlow("embed://../secret.json")
+	assert.NotNil(t, err)
+}
+
+func TestFSFlowProvider_Integrity(t *testing.T) {
+
+	flowJSON := []byte(`{"name":"Signed","tasks":[]}`)
+	digest := sha256.Sum256(flowJSON)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Nil(t, err)
+
+	fsys := fstest.MapFS{
+		"flows/signed.json":     {Data: flowJSON},
+		"flows/signed.json.sig": {Data: signature},
+		"flows/unsigned.json":   {Data: flowJSON},
+	}
+
+	p := NewFSFlowProvider(fsys, nil)
+
+	_, err = p.GetFlow("embed://flows/unsigned.json#sha256=" + hex.EncodeToString(digest[:]))
+	assert.Nil(t, err)
+
+	_, err = p.GetFlow("embed://flows/unsigned.json#sha256=" + hex.EncodeToString(make([]byte, 32)))
+	assert.NotNil(t, err)
+
+	verifier, err := NewFlowVerifier(&key.PublicKey)
+	assert.Nil(t, err)
+	assert.Nil(t, p.SetVerifier(verifier))
+
+	rep, err := p.GetFlow("embed://flows/signed.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "Signed", rep.Name)
+
+	_, err = p.GetFlow("embed://flows/unsigned.json")
+	assert.NotNil(t, err)
+
+	// the flows of the fallback must be verified too
+	assert.Nil(t, NewFSFlowProvider(fsys, NewBasicRemoteFlowProvider()).SetVerifier(verifier))
+	assert.NotNil(t, NewFSFlowProvider(fsys, staticFlowProvider{}).SetVerifier(verifier))
+}
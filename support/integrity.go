@@ -0,0 +1,269 @@
+package support
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/qingcloudhx/flow/definition"
+)
+
+const (
+	// EnvFlowPublicKeys is the PEM file of the public keys trusted to sign the flows
+	EnvFlowPublicKeys = "FLOGO_FLOW_PUBLIC_KEYS"
+	// EnvFlowRequireSignature makes the startup fail if the public keys aren't set, if "true"
+	EnvFlowRequireSignature = "FLOGO_FLOW_REQUIRE_SIGNATURE"
+	// EnvFlowIntegrityDir is the directory of the signatures and checksums of the flow resources
+	EnvFlowIntegrityDir = "FLOGO_FLOW_INTEGRITY_DIR"
+
+	signatureExt = ".sig"
+)
+
+// Checksum is the expected digest of a flow
+type Checksum struct {
+	Algorithm string
+	Digest    []byte
+}
+
+var checksumHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// ParseChecksum parses a checksum of the form "<algorithm>=<hex digest>", ex. "sha256=9f86d0..."
+func ParseChecksum(s string) (*Checksum, error) {
+
+	parts := strings.SplitN(strings.TrimSpace(s), "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid checksum '%s'", s)
+	}
+
+	algorithm := strings.ToLower(parts[0])
+	newHash, ok := checksumHashes[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm '%s'", parts[0])
+	}
+
+	digest, err := hex.DecodeString(parts[1])
+	if err != nil || len(digest) != newHash().Size() {
+		return nil, fmt.Errorf("invalid %s checksum '%s'", algorithm, parts[1])
+	}
+
+	return &Checksum{Algorithm: algorithm, Digest: digest}, nil
+}
+
+// SplitChecksum splits the checksum from a flow URI, ex. "http://host/flow.json#sha256=9f86d0...",
+// the checksum is nil if the URI doesn't have one
+func SplitChecksum(flowURI string) (string, *Checksum, error) {
+
+	idx := strings.LastIndex(flowURI, "#")
+	if idx < 0 {
+		return flowURI, nil, nil
+	}
+
+	fragment := flowURI[idx+1:]
+	if eq := strings.Index(fragment, "="); eq < 0 || checksumHashes[strings.ToLower(fragment[:eq])] == nil {
+		// not a checksum
+		return flowURI, nil, nil
+	}
+
+	checksum, err := ParseChecksum(fragment)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid checksum in uri '%s', %s", flowURI, err.Error())
+	}
+
+	return flowURI[:idx], checksum, nil
+}
+
+// Verify checks that the data matches the checksum
+func (c *Checksum) Verify(data []byte) error {
+
+	h := checksumHashes[c.Algorithm]()
+	h.Write(data)
+
+	if actual := h.Sum(nil); !bytes.Equal(actual, c.Digest) {
+		return fmt.Errorf("%s checksum mismatch, expected %x got %x", c.Algorithm, c.Digest, actual)
+	}
+
+	return nil
+}
+
+// VerifyingProvider is a definition.Provider that can verify the signatures of the flows
+type VerifyingProvider interface {
+	definition.Provider
+
+	// SetVerifier sets the verifier of the signatures of the flows, an error is returned if
+	// some flows, ex. the ones of a fallback provider, can't be verified
+	SetVerifier(verifier *FlowVerifier) error
+}
+
+// setFallbackVerifier sets the verifier of the fallback of a provider, if any
+func setFallbackVerifier(fallback definition.Provider, verifier *FlowVerifier) error {
+
+	if fallback == nil {
+		return nil
+	}
+
+	provider, ok := fallback.(VerifyingProvider)
+	if !ok {
+		return fmt.Errorf("fallback flow provider %T can't verify the signatures of the flows", fallback)
+	}
+
+	return provider.SetVerifier(verifier)
+}
+
+// verifyFlow checks the data of the flow against its checksum and, if the verifier is set,
+// its signature
+func verifyFlow(data []byte, checksum *Checksum, verifier *FlowVerifier, signature []byte) error {
+
+	if checksum != nil {
+		if err := checksum.Verify(data); err != nil {
+			return err
+		}
+	}
+
+	if verifier != nil {
+		return verifier.Verify(data, signature)
+	}
+
+	return nil
+}
+
+// FlowVerifier verifies the detached signatures of the flows against a set of trusted public
+// keys.  The signatures are SHA-256 RSA PKCS #1 v1.5 or ECDSA signatures, raw or base64
+// encoded, ex. as produced by "openssl dgst -sha256 -sign key.pem -out flow.json.sig flow.json".
+// The flows that aren't signed are rejected.
+type FlowVerifier struct {
+	keys []crypto.PublicKey
+}
+
+// NewFlowVerifier creates a FlowVerifier trusting the public keys
+func NewFlowVerifier(keys ...crypto.PublicKey) (*FlowVerifier, error) {
+
+	if len(keys) == 0 {
+		return nil, errors.New("no public key to verify the flows")
+	}
+
+	for _, key := range keys {
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", key)
+		}
+	}
+
+	return &FlowVerifier{keys: keys}, nil
+}
+
+// LoadPublicKeys loads the PEM encoded PKIX public keys of the file
+func LoadPublicKeys(path string) ([]crypto.PublicKey, error) {
+
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading public keys '%s', %s", path, err.Error())
+	}
+
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing public key in '%s', %s", path, err.Error())
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public key found in '%s'", path)
+	}
+
+	return keys, nil
+}
+
+// FlowVerifierFromEnv creates the FlowVerifier defined by the environment, it returns nil if
+// no public keys are configured
+func FlowVerifierFromEnv() (*FlowVerifier, error) {
+
+	keysFile := os.Getenv(EnvFlowPublicKeys)
+	if len(keysFile) == 0 {
+		if strings.ToLower(os.Getenv(EnvFlowRequireSignature)) == "true" {
+			return nil, fmt.Errorf("%s requires the public keys to be set using %s", EnvFlowRequireSignature, EnvFlowPublicKeys)
+		}
+		return nil, nil
+	}
+
+	keys, err := LoadPublicKeys(keysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFlowVerifier(keys...)
+}
+
+// Verify checks that the signature of the data was produced by one of the trusted keys, a nil
+// signature is rejected
+func (v *FlowVerifier) Verify(data, signature []byte) error {
+
+	if signature == nil {
+		return errors.New("flow is not signed")
+	}
+
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature))); err == nil {
+		signature = decoded
+	}
+
+	digest := sha256.Sum256(data)
+
+	for _, key := range v.keys {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if verifyECDSA(key, digest[:], signature) {
+				return nil
+			}
+		}
+	}
+
+	return errors.New("flow signature doesn't match any trusted key")
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func verifyECDSA(key *ecdsa.PublicKey, digest, signature []byte) bool {
+
+	var sig ecdsaSignature
+	rest, err := asn1.Unmarshal(signature, &sig)
+	if err != nil || len(rest) != 0 || sig.R == nil || sig.S == nil {
+		return false
+	}
+
+	return ecdsa.Verify(key, digest, sig.R, sig.S)
+}
@@ -0,0 +1,212 @@
+package support
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/qingcloudhx/core/app/resource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitChecksum(t *testing.T) {
+
+	sum := sha256.Sum256([]byte("flow"))
+	digest := hex.EncodeToString(sum[:])
+
+	uri, checksum, err := SplitChecksum("http://host/flow.json#sha256=" + digest)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://host/flow.json", uri)
+	assert.Nil(t, checksum.Verify([]byte("flow")))
+	assert.NotNil(t, checksum.Verify([]byte("tampered")))
+
+	uri, checksum, err = SplitChecksum("http://host/flow.json#section")
+	assert.Nil(t, err)
+	assert.Equal(t, "http://host/flow.json#section", uri)
+	assert.Nil(t, checksum)
+
+	_, _, err = SplitChecksum("http://host/flow.json#sha256=abc")
+	assert.NotNil(t, err)
+}
+
+func TestFlowVerifier(t *testing.T) {
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	keysFile := writePublicKeys(t, &rsaKey.PublicKey, &ecKey.PublicKey)
+	defer os.Remove(keysFile)
+
+	keys, err := LoadPublicKeys(keysFile)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 2)
+
+	verifier, err := NewFlowVerifier(keys...)
+	assert.Nil(t, err)
+
+	data := []byte(`{"name":"Signed"}`)
+	digest := sha256.Sum256(data)
+
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	assert.Nil(t, err)
+	assert.Nil(t, verifier.Verify(data, rsaSig))
+
+	ecSig, err := ecKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Nil(t, err)
+	assert.Nil(t, verifier.Verify(data, []byte(base64.StdEncoding.EncodeToString(ecSig))))
+
+	assert.NotNil(t, verifier.Verify([]byte(`{"name":"Tampered"}`), rsaSig))
+
+	// the flows must be signed once keys are trusted
+	assert.NotNil(t, verifier.Verify(data, nil))
+}
+
+func TestBasicRemoteFlowProvider_Integrity(t *testing.T) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	flowJSON := []byte(`{"name":"Signed","tasks":[]}`)
+	digest := sha256.Sum256(flowJSON)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/signed.json", "/unsigned.json":
+			_, _ = w.Write(flowJSON)
+		case "/signed.json.sig":
+			_, _ = w.Write(signature)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	fp := NewBasicRemoteFlowProvider()
+
+	_, err = fp.GetFlow(server.URL + "/unsigned.json#sha256=" + hex.EncodeToString(digest[:]))
+	assert.Nil(t, err)
+
+	_, err = fp.GetFlow(server.URL + "/unsigned.json#sha256=" + hex.EncodeToString(make([]byte, 32)))
+	assert.NotNil(t, err)
+
+	verifier, err := NewFlowVerifier(&key.PublicKey)
+	assert.Nil(t, err)
+
+	fp, err = NewRemoteFlowProvider(&RemoteConfig{Verifier: verifier})
+	assert.Nil(t, err)
+
+	rep, err := fp.GetFlow(server.URL + "/signed.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "Signed", rep.Name)
+
+	_, err = fp.GetFlow(server.URL + "/unsigned.json")
+	assert.NotNil(t, err)
+}
+
+func TestFlowLoader_Integrity(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "integrity")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	data := json.RawMessage(`{
+		"name": "Reviewed",
+		"tasks": []
+	}`)
+	digest := sha256.Sum256([]byte(`{"name":"Reviewed","tasks":[]}`))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "reviewed.sha256"), []byte(hex.EncodeToString(digest[:])+"  reviewed.json\n"), 0644))
+
+	loader := &FlowLoader{IntegrityDir: dir}
+
+	_, err = loader.LoadResource(&resource.Config{ID: "flow:reviewed", Data: data})
+	assert.Nil(t, err)
+
+	_, err = loader.LoadResource(&resource.Config{ID: "flow:reviewed", Data: json.RawMessage(`{"name":"Changed","tasks":[]}`)})
+	assert.NotNil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	loader.Verifier, err = NewFlowVerifier(&key.PublicKey)
+	assert.Nil(t, err)
+
+	_, err = loader.LoadResource(&resource.Config{ID: "flow:reviewed", Data: data})
+	assert.NotNil(t, err)
+
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "reviewed.sig"), signature, 0644))
+
+	_, err = loader.LoadResource(&resource.Config{ID: "flow:reviewed", Data: data})
+	assert.Nil(t, err)
+}
+
+func TestDirectoryFlowProvider_Integrity(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "flows")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	flowJSON := `{"name":"Signed","tasks":[]}`
+	digest := sha256.Sum256([]byte(flowJSON))
+	writeFlowFile(t, root, "signed.json", flowJSON, false)
+	writeFlowFile(t, root, "unsigned.json", flowJSON, false)
+
+	p, err := NewDirectoryFlowProvider(root, nil)
+	assert.Nil(t, err)
+
+	rep, err := p.GetFlow("flow://unsigned#sha256=" + hex.EncodeToString(digest[:]))
+	assert.Nil(t, err)
+	assert.Equal(t, "Signed", rep.Name)
+
+	_, err = p.GetFlow("flow://unsigned#sha256=" + hex.EncodeToString(make([]byte, 32)))
+	assert.NotNil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "signed.json.sig"), signature, 0644))
+
+	verifier, err := NewFlowVerifier(&key.PublicKey)
+	assert.Nil(t, err)
+	assert.Nil(t, p.SetVerifier(verifier))
+
+	_, err = p.GetFlow("flow://signed")
+	assert.Nil(t, err)
+
+	_, err = p.GetFlow("flow://unsigned")
+	assert.NotNil(t, err)
+}
+
+func writePublicKeys(t *testing.T, keys ...crypto.PublicKey) string {
+
+	f, err := ioutil.TempFile("", "keys*.pem")
+	assert.Nil(t, err)
+	defer f.Close()
+
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		assert.Nil(t, err)
+		assert.Nil(t, pem.Encode(f, &pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+
+	return f.Name()
+}
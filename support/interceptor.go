@@ -1,6 +1,13 @@
 package support
 
-import "github.com/qingcloudhx/core/data"
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/qingcloudhx/core/data"
+	"github.com/qingcloudhx/core/data/expression"
+)
 
 // Interceptor contains a set of task interceptor, this can be used to override
 // runtime data of an instance of the corresponding Flow.  This can be used to
@@ -9,7 +16,7 @@ import "github.com/qingcloudhx/core/data"
 type Interceptor struct {
 	TaskInterceptors []*TaskInterceptor `json:"tasks"`
 
-	taskInterceptorMap map[string]*TaskInterceptor
+	taskInterceptorMap map[string][]*TaskInterceptor
 }
 
 // Init initializes the FlowInterceptor, usually called after deserialization
@@ -18,25 +25,143 @@ func (pi *Interceptor) Init() {
 	numAttrs := len(pi.TaskInterceptors)
 	if numAttrs > 0 {
 
-		pi.taskInterceptorMap = make(map[string]*TaskInterceptor, numAttrs)
+		pi.taskInterceptorMap = make(map[string][]*TaskInterceptor, numAttrs)
 
 		for _, interceptor := range pi.TaskInterceptors {
-			pi.taskInterceptorMap[interceptor.ID] = interceptor
+			pi.taskInterceptorMap[interceptor.ID] = append(pi.taskInterceptorMap[interceptor.ID], interceptor)
 		}
 	}
 }
 
-// GetTaskInterceptor get the TaskInterceptor for the specified task (referred to by ID)
+// GetTaskInterceptor get the TaskInterceptor for the specified task (referred to by ID), if
+// the task has several interceptors the first one is returned
 func (pi *Interceptor) GetTaskInterceptor(taskID string) *TaskInterceptor {
+	interceptors := pi.taskInterceptorMap[taskID]
+	if len(interceptors) == 0 {
+		return nil
+	}
+
+	return interceptors[0]
+}
+
+// GetTaskInterceptors get the TaskInterceptors for the specified task (referred to by ID),
+// in the order in which they should be matched
+func (pi *Interceptor) GetTaskInterceptors(taskID string) []*TaskInterceptor {
 	return pi.taskInterceptorMap[taskID]
 }
 
 // TaskInterceptor contains instance override information for a Task, such has attributes.
 // Also, a 'Skip' flag can be enabled to inform the runtime that the task should not
-// execute.
+// execute and an 'Error' can be specified to make the task fail with that error.
+//
+// A task can have several interceptors, the first one whose 'When' condition matches the
+// task's inputs is applied.  A 'Sequence' can be used to override the outputs or error of
+// successive executions of the task, once it is exhausted its last result is repeated.
 type TaskInterceptor struct {
 	ID      string            `json:"id"`
 	Skip    bool              `json:"skip,omitempty"`
+	Error   *InterceptorError `json:"error,omitempty"`
 	Inputs  []*data.Attribute `json:"inputs,omitempty"`
 	Outputs []*data.Attribute `json:"outputs,omitempty"`
+
+	// When is an expression evaluated against the task's inputs ($.name) and the flow ($flow.name)
+	When string `json:"when,omitempty"`
+	// Sequence contains the results of successive executions of the task
+	Sequence []*InterceptorResult `json:"sequence,omitempty"`
+	// Latency delays the execution of the task (ex. "100ms")
+	Latency string `json:"latency,omitempty"`
+
+	whenOnce sync.Once
+	whenExpr expression.Expr
+	whenErr  error
+}
+
+// Matches determines if the interceptor applies to the task with the specified scope
+func (ti *TaskInterceptor) Matches(scope data.Scope, factory expression.Factory) (bool, error) {
+
+	if ti.When == "" {
+		return true, nil
+	}
+
+	ti.whenOnce.Do(func() {
+		ti.whenExpr, ti.whenErr = factory.NewExpr(ti.When)
+	})
+
+	if ti.whenErr != nil {
+		return false, ti.whenErr
+	}
+
+	result, err := ti.whenExpr.Eval(scope)
+	if err != nil {
+		return false, err
+	}
+
+	matches, _ := result.(bool)
+	return matches, nil
+}
+
+// Result returns the outputs and error of the specified execution (starting at 0) of the task
+func (ti *TaskInterceptor) Result(execution int) ([]*data.Attribute, *InterceptorError) {
+
+	if len(ti.Sequence) == 0 {
+		return ti.Outputs, ti.Error
+	}
+
+	if execution >= len(ti.Sequence) {
+		execution = len(ti.Sequence) - 1
+	}
+
+	result := ti.Sequence[execution]
+	return result.Outputs, result.Error
+}
+
+// Delay returns the latency to add to the execution of the task
+func (ti *TaskInterceptor) Delay() (time.Duration, error) {
+
+	if ti.Latency == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(ti.Latency)
+}
+
+// InterceptorResult contains the outputs or the error of an execution of a task
+type InterceptorResult struct {
+	Outputs []*data.Attribute `json:"outputs,omitempty"`
+	Error   *InterceptorError `json:"error,omitempty"`
+}
+
+// InterceptorError describes the error a task should fail with, it can be specified
+// as an object or simply as a message
+type InterceptorError struct {
+	// Type is the type of the error as seen by the error handlers, defaults to 'activity'
+	Type    string `json:"type,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// UnmarshalJSON overrides the default UnmarshalJSON for InterceptorError
+func (ie *InterceptorError) UnmarshalJSON(d []byte) error {
+
+	var message string
+	if err := json.Unmarshal(d, &message); err == nil {
+		ie.Message = message
+		return nil
+	}
+
+	ser := &struct {
+		Type    string `json:"type,omitempty"`
+		Code    string `json:"code,omitempty"`
+		Message string `json:"message"`
+	}{}
+
+	if err := json.Unmarshal(d, ser); err != nil {
+		return err
+	}
+
+	ie.Type = ser.Type
+	ie.Code = ser.Code
+	ie.Message = ser.Message
+
+	return nil
 }
@@ -3,13 +3,18 @@ package support
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/qingcloudhx/core/support"
 	"github.com/qingcloudhx/core/support/log"
@@ -17,137 +22,288 @@ import (
 )
 
 const (
-	uriSchemeFile = "file://"
-	uriSchemeHttp = "http://"
+	uriSchemeFile  = "file://"
+	uriSchemeHttp  = "http://"
+	uriSchemeHttps = "https://"
 )
 
 type FlowManager struct {
-	//todo switch to cache
-	rfMu         sync.Mutex // protects the flow maps
-	remoteFlows  map[string]*definition.Definition
+	flows        *flowCache
 	flowProvider definition.Provider
 }
 
+// NewFlowManager creates a FlowManager caching the flows using the default CacheConfig
 func NewFlowManager(flowProvider definition.Provider) *FlowManager {
-	manager := &FlowManager{}
+	return NewFlowManagerWithCache(flowProvider, nil)
+}
+
+// NewFlowManagerWithCache creates a FlowManager caching the flows using the CacheConfig
+func NewFlowManagerWithCache(flowProvider definition.Provider, config *CacheConfig) *FlowManager {
+	manager := &FlowManager{flows: newFlowCache(config)}
 
 	if flowProvider != nil {
 		manager.flowProvider = flowProvider
 	} else {
-		//todo which logger should this use?
-		manager.flowProvider = &BasicRemoteFlowProvider{logger: log.RootLogger()}
+		manager.flowProvider = NewBasicRemoteFlowProvider()
 	}
 
 	return manager
 }
 
+// GetFlow gets the flow, the definition can be cleaned up once evicted from the cache so
+// AcquireFlow should be used to run it
 func (fm *FlowManager) GetFlow(uri string) (*definition.Definition, error) {
 
-	fm.rfMu.Lock()
-	defer fm.rfMu.Unlock()
-
-	if fm.remoteFlows == nil {
-		fm.remoteFlows = make(map[string]*definition.Definition)
+	def, release, err := fm.AcquireFlow(uri)
+	if err != nil {
+		return nil, err
 	}
+	release()
 
-	flow, exists := fm.remoteFlows[uri]
+	return def, nil
+}
 
-	if !exists {
+// AcquireFlow gets the flow and acquires it, it isn't cleaned up until the returned function is
+// called
+func (fm *FlowManager) AcquireFlow(uri string) (*definition.Definition, func(), error) {
+
+	return fm.flows.get(uri, func() (*definition.Definition, error) {
 
 		defRep, err := fm.flowProvider.GetFlow(uri)
 		if err != nil {
 			return nil, err
 		}
 
-		flow, err = materializeFlow(defRep)
+		return materializeFlow(defRep)
+	})
+}
+
+// RemoteConfig is the configuration of the loading of the flows over HTTP and HTTPS
+type RemoteConfig struct {
+	// Timeout is the timeout of the requests, DefaultRemoteTimeout if not set
+	Timeout time.Duration
+	// BearerToken is sent in the Authorization header of the requests
+	BearerToken string
+	// Username and Password are sent using basic authentication, if BearerToken is not set
+	Username string
+	Password string
+	// AllowInsecureAuth allows sending the credentials over HTTP, by default they are only
+	// sent over HTTPS
+	AllowInsecureAuth bool
+	// Headers are added to the requests
+	Headers map[string]string
+	// CAFile is a PEM bundle of the certificates of the authorities trusted in addition to the
+	// ones of the system
+	CAFile string
+	// Verifier verifies the detached signatures of the flows, "<uri>.sig", if set
+	Verifier *FlowVerifier
+}
+
+const (
+	// DefaultRemoteTimeout is the default timeout of the requests loading flows
+	DefaultRemoteTimeout = 30 * time.Second
+
+	// EnvRemoteTimeout is the timeout of the requests loading flows (ex. "10s")
+	EnvRemoteTimeout = "FLOGO_FLOW_REMOTE_TIMEOUT"
+	// EnvRemoteToken is the bearer token of the requests loading flows
+	EnvRemoteToken = "FLOGO_FLOW_REMOTE_TOKEN"
+	// EnvRemoteUsername is the basic authentication user of the requests loading flows
+	EnvRemoteUsername = "FLOGO_FLOW_REMOTE_USERNAME"
+	// EnvRemotePassword is the basic authentication password of the requests loading flows
+	EnvRemotePassword = "FLOGO_FLOW_REMOTE_PASSWORD"
+	// EnvRemoteCAFile is the PEM bundle of additional authorities trusted when loading flows
+	EnvRemoteCAFile = "FLOGO_FLOW_REMOTE_CA_FILE"
+	// EnvRemoteAllowInsecureAuth allows sending the credentials when loading flows over HTTP
+	EnvRemoteAllowInsecureAuth = "FLOGO_FLOW_REMOTE_ALLOW_INSECURE_AUTH"
+)
+
+// RemoteConfigFromEnv creates the RemoteConfig defined by the environment
+func RemoteConfigFromEnv() (*RemoteConfig, error) {
+
+	config := &RemoteConfig{
+		BearerToken: os.Getenv(EnvRemoteToken),
+		Username:    os.Getenv(EnvRemoteUsername),
+		Password:    os.Getenv(EnvRemotePassword),
+		CAFile:      os.Getenv(EnvRemoteCAFile),
+	}
+
+	if allow := os.Getenv(EnvRemoteAllowInsecureAuth); len(allow) > 0 {
+		var err error
+		config.AllowInsecureAuth, err = strconv.ParseBool(allow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %v", EnvRemoteAllowInsecureAuth, allow, err)
+		}
+	}
+
+	if timeout := os.Getenv(EnvRemoteTimeout); len(timeout) > 0 {
+		var err error
+		config.Timeout, err = time.ParseDuration(timeout)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid %s '%s': %v", EnvRemoteTimeout, timeout, err)
 		}
+	}
 
-		fm.remoteFlows[uri] = flow
+	verifier, err := FlowVerifierFromEnv()
+	if err != nil {
+		return nil, err
 	}
+	config.Verifier = verifier
 
-	return flow, nil
+	return config, nil
 }
 
 type BasicRemoteFlowProvider struct {
 	logger log.Logger
+	config RemoteConfig
+	client *http.Client
+
+	cacheMu sync.Mutex
+	cache   map[string]*cachedFlow
+}
+
+// cachedFlow is a flow fetched over HTTP, it is revalidated using its ETag or Last-Modified
+type cachedFlow struct {
+	etag         string
+	lastModified string
+	body         []byte
+	compressed   bool
+	contentType  string
+}
+
+// NewBasicRemoteFlowProvider creates a BasicRemoteFlowProvider with the default configuration
+func NewBasicRemoteFlowProvider() *BasicRemoteFlowProvider {
+	fp, _ := NewRemoteFlowProvider(&RemoteConfig{})
+	return fp
+}
+
+// NewRemoteFlowProvider creates a BasicRemoteFlowProvider with the configuration
+func NewRemoteFlowProvider(config *RemoteConfig) (*BasicRemoteFlowProvider, error) {
+
+	//todo which logger should this use?
+	fp := &BasicRemoteFlowProvider{logger: log.RootLogger(), config: *config, cache: make(map[string]*cachedFlow)}
+
+	if fp.config.Timeout <= 0 {
+		fp.config.Timeout = DefaultRemoteTimeout
+	}
+
+	client := &http.Client{Timeout: fp.config.Timeout}
+
+	if config.CAFile != "" {
+		pem, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA bundle '%s', %s", config.CAFile, err.Error())
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA bundle '%s'", config.CAFile)
+		}
+
+		client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+
+	fp.client = client
+
+	return fp, nil
+}
+
+// SetVerifier implements VerifyingProvider.SetVerifier
+func (fp *BasicRemoteFlowProvider) SetVerifier(verifier *FlowVerifier) error {
+	fp.config.Verifier = verifier
+	return nil
 }
 
 func (fp *BasicRemoteFlowProvider) GetFlow(flowURI string) (*definition.DefinitionRep, error) {
 
 	logger := fp.logger
 
-	var flowDefBytes []byte
+	flowURI, checksum, err := SplitChecksum(flowURI)
+	if err != nil {
+		logger.Errorf(err.Error())
+		return nil, err
+	}
+
+	var readBytes, signature []byte
+	var compressed bool
+
+	yamlFormat := definition.IsYAMLFile(flowURI)
 
 	if strings.HasPrefix(flowURI, uriSchemeFile) {
 		// File URI
 		logger.Infof("Loading Local Flow: %s\n", flowURI)
 		flowFilePath, _ := support.URLStringToFilePath(flowURI)
 
-		readBytes, err := ioutil.ReadFile(flowFilePath)
+		readBytes, err = ioutil.ReadFile(flowFilePath)
 		if err != nil {
 			readErr := fmt.Errorf("error reading flow with uri '%s', %s", flowURI, err.Error())
 			logger.Errorf(readErr.Error())
 			return nil, readErr
 		}
-		if readBytes[0] == 0x1f && readBytes[2] == 0x8b {
-			flowDefBytes, err = unzip(readBytes)
-			if err != nil {
-				decompressErr := fmt.Errorf("error uncompressing flow with uri '%s', %s", flowURI, err.Error())
-				logger.Errorf(decompressErr.Error())
-				return nil, decompressErr
-			}
-		} else {
-			flowDefBytes = readBytes
 
+		if fp.config.Verifier != nil {
+			signature, err = ioutil.ReadFile(flowFilePath + signatureExt)
+			if err != nil && !os.IsNotExist(err) {
+				readErr := fmt.Errorf("error reading signature of flow with uri '%s', %s", flowURI, err.Error())
+				logger.Errorf(readErr.Error())
+				return nil, readErr
+			}
 		}
 
-	} else if strings.HasPrefix(flowURI, uriSchemeHttp) {
-		req, err := http.NewRequest("GET", flowURI, nil)
-		client := &http.Client{}
-		resp, err := client.Do(req)
+	} else if strings.HasPrefix(flowURI, uriSchemeHttp) || strings.HasPrefix(flowURI, uriSchemeHttps) {
+		remoteFlow, err := fp.fetch(flowURI)
 		if err != nil {
-			getErr := fmt.Errorf("error getting flow with uri '%s', %s", flowURI, err.Error())
-			logger.Errorf(getErr.Error())
-			return nil, getErr
+			logger.Errorf(err.Error())
+			return nil, err
 		}
-		defer resp.Body.Close()
+		readBytes, compressed = remoteFlow.body, remoteFlow.compressed
+		yamlFormat = yamlFormat || definition.IsYAMLContentType(remoteFlow.contentType)
 
-		logger.Infof("response Status:", resp.Status)
-
-		if resp.StatusCode >= 300 {
-			//not found
-			getErr := fmt.Errorf("error getting flow with uri '%s', status code %d", flowURI, resp.StatusCode)
-			logger.Errorf(getErr.Error())
-			return nil, getErr
+		if fp.config.Verifier != nil {
+			signature, err = fp.fetchSignature(flowURI + signatureExt)
+			if err != nil {
+				logger.Errorf(err.Error())
+				return nil, err
+			}
 		}
+	} else {
+		return nil, fmt.Errorf("unsupport uri %s", flowURI)
+	}
 
-		body, err := ioutil.ReadAll(resp.Body)
+	if err := verifyFlow(readBytes, checksum, fp.config.Verifier, signature); err != nil {
+		verifyErr := fmt.Errorf("error verifying flow with uri '%s', %s", flowURI, err.Error())
+		logger.Errorf(verifyErr.Error())
+		return nil, verifyErr
+	}
+
+	var flowDefBytes []byte
+
+	if compressed {
+		flowDefBytes, err = decodeAndUnzip(string(readBytes))
 		if err != nil {
-			readErr := fmt.Errorf("error reading flow response body with uri '%s', %s", flowURI, err.Error())
-			logger.Errorf(readErr.Error())
-			return nil, readErr
+			decodeErr := fmt.Errorf("error decoding compressed flow with uri '%s', %s", flowURI, err.Error())
+			logger.Errorf(decodeErr.Error())
+			return nil, decodeErr
 		}
-
-		val := resp.Header.Get("flow-compressed")
-		if strings.ToLower(val) == "true" {
-			decodedBytes, err := decodeAndUnzip(string(body))
-			if err != nil {
-				decodeErr := fmt.Errorf("error decoding compressed flow with uri '%s', %s", flowURI, err.Error())
-				logger.Errorf(decodeErr.Error())
-				return nil, decodeErr
-			}
-			flowDefBytes = decodedBytes
-		} else {
-			flowDefBytes = body
+	} else if isGzip(readBytes) {
+		flowDefBytes, err = unzip(readBytes)
+		if err != nil {
+			decompressErr := fmt.Errorf("error uncompressing flow with uri '%s', %s", flowURI, err.Error())
+			logger.Errorf(decompressErr.Error())
+			return nil, decompressErr
 		}
 	} else {
-		return nil, fmt.Errorf("unsupport uri %s", flowURI)
+		flowDefBytes = readBytes
 	}
 
 	var flow *definition.DefinitionRep
-	err := json.Unmarshal(flowDefBytes, &flow)
+	if yamlFormat {
+		flow, err = definition.DecodeYAML(flowDefBytes)
+	} else {
+		err = json.Unmarshal(flowDefBytes, &flow)
+	}
 	if err != nil {
 		logger.Errorf(err.Error())
 		return nil, fmt.Errorf("error marshalling flow with uri '%s', %s", flowURI, err.Error())
@@ -156,6 +312,140 @@ func (fp *BasicRemoteFlowProvider) GetFlow(flowURI string) (*definition.Definiti
 	return flow, nil
 }
 
+// newRequest creates a GET request with the configured headers and credentials
+func (fp *BasicRemoteFlowProvider) newRequest(uri string) (*http.Request, error) {
+
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range fp.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	if fp.config.BearerToken == "" && fp.config.Username == "" {
+		return req, nil
+	}
+
+	// the credentials would be sent in clear text over HTTP
+	if !strings.HasPrefix(uri, uriSchemeHttps) && !fp.config.AllowInsecureAuth {
+		fp.logger.Warnf("Credentials not sent when loading flow '%s' over HTTP, set %s to allow it", uri, EnvRemoteAllowInsecureAuth)
+		return req, nil
+	}
+
+	if fp.config.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+fp.config.BearerToken)
+	} else {
+		req.SetBasicAuth(fp.config.Username, fp.config.Password)
+	}
+
+	return req, nil
+}
+
+func (fp *BasicRemoteFlowProvider) httpClient() *http.Client {
+	if fp.client == nil {
+		return &http.Client{Timeout: DefaultRemoteTimeout}
+	}
+	return fp.client
+}
+
+// fetch gets the flow over HTTP, revalidating the cached flow if any
+func (fp *BasicRemoteFlowProvider) fetch(flowURI string) (*cachedFlow, error) {
+
+	req, err := fp.newRequest(flowURI)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request for flow with uri '%s', %s", flowURI, err.Error())
+	}
+
+	fp.cacheMu.Lock()
+	cached := fp.cache[flowURI]
+	fp.cacheMu.Unlock()
+
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := fp.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting flow with uri '%s', %s", flowURI, err.Error())
+	}
+	defer resp.Body.Close()
+
+	fp.logger.Infof("Loading Remote Flow: %s, response status: %s", flowURI, resp.Status)
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached, nil
+	}
+
+	if resp.StatusCode >= 300 {
+		//not found
+		return nil, fmt.Errorf("error getting flow with uri '%s', status code %d", flowURI, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading flow response body with uri '%s', %s", flowURI, err.Error())
+	}
+
+	remoteFlow := &cachedFlow{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		body:         body,
+		compressed:   strings.ToLower(resp.Header.Get("flow-compressed")) == "true",
+		contentType:  resp.Header.Get("Content-Type"),
+	}
+
+	fp.cacheMu.Lock()
+	if remoteFlow.etag != "" || remoteFlow.lastModified != "" {
+		if fp.cache == nil {
+			fp.cache = make(map[string]*cachedFlow)
+		}
+		fp.cache[flowURI] = remoteFlow
+	} else {
+		delete(fp.cache, flowURI)
+	}
+	fp.cacheMu.Unlock()
+
+	return remoteFlow, nil
+}
+
+// fetchSignature gets the detached signature of a flow over HTTP, it returns nil if the flow
+// isn't signed
+func (fp *BasicRemoteFlowProvider) fetchSignature(sigURI string) ([]byte, error) {
+
+	req, err := fp.newRequest(sigURI)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request for signature with uri '%s', %s", sigURI, err.Error())
+	}
+
+	resp, err := fp.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error getting signature with uri '%s', %s", sigURI, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("error getting signature with uri '%s', status code %d", sigURI, resp.StatusCode)
+	}
+
+	signature, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signature with uri '%s', %s", sigURI, err.Error())
+	}
+
+	return signature, nil
+}
+
 func decodeAndUnzip(encoded string) ([]byte, error) {
 
 	decoded, _ := base64.StdEncoding.DecodeString(encoded)
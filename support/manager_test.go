@@ -0,0 +1,143 @@
+package support
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBasicRemoteFlowProvider_Auth(t *testing.T) {
+
+	var authorization, custom string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		custom = r.Header.Get("X-Custom")
+		_, _ = w.Write([]byte(`{"name":"Remote","tasks":[]}`))
+	})
+
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	fp, err := NewRemoteFlowProvider(&RemoteConfig{BearerToken: "secret", Headers: map[string]string{"X-Custom": "value"}})
+	assert.Nil(t, err)
+	fp.client = server.Client()
+
+	rep, err := fp.GetFlow(server.URL + "/flow.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "Remote", rep.Name)
+	assert.Equal(t, "Bearer secret", authorization)
+	assert.Equal(t, "value", custom)
+
+	fp, err = NewRemoteFlowProvider(&RemoteConfig{Username: "user", Password: "pass"})
+	assert.Nil(t, err)
+	fp.client = server.Client()
+
+	_, err = fp.GetFlow(server.URL + "/flow.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "Basic dXNlcjpwYXNz", authorization)
+
+	// the credentials are only sent over HTTP if explicitly allowed
+	insecureServer := httptest.NewServer(handler)
+	defer insecureServer.Close()
+
+	fp, err = NewRemoteFlowProvider(&RemoteConfig{BearerToken: "secret", Headers: map[string]string{"X-Custom": "value"}})
+	assert.Nil(t, err)
+
+	_, err = fp.GetFlow(insecureServer.URL + "/flow.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "", authorization)
+	assert.Equal(t, "value", custom)
+
+	fp, err = NewRemoteFlowProvider(&RemoteConfig{BearerToken: "secret", AllowInsecureAuth: true})
+	assert.Nil(t, err)
+
+	_, err = fp.GetFlow(insecureServer.URL + "/flow.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer secret", authorization)
+}
+
+func TestBasicRemoteFlowProvider_Cache(t *testing.T) {
+
+	requests, notModified := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(`{"name":"Cached","tasks":[]}`))
+	}))
+	defer server.Close()
+
+	fp := NewBasicRemoteFlowProvider()
+
+	for i := 0; i < 2; i++ {
+		rep, err := fp.GetFlow(server.URL + "/flow.json")
+		assert.Nil(t, err)
+		assert.Equal(t, "Cached", rep.Name)
+	}
+
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, notModified)
+}
+
+func TestBasicRemoteFlowProvider_TLS(t *testing.T) {
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name":"Secure","tasks":[]}`))
+	}))
+	defer server.Close()
+
+	// the certificate of the test server isn't trusted by default
+	_, err := NewBasicRemoteFlowProvider().GetFlow(server.URL + "/flow.json")
+	assert.NotNil(t, err)
+
+	caFile, err := ioutil.TempFile("", "ca*.pem")
+	assert.Nil(t, err)
+	defer os.Remove(caFile.Name())
+
+	assert.Nil(t, pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	assert.Nil(t, caFile.Close())
+
+	fp, err := NewRemoteFlowProvider(&RemoteConfig{CAFile: caFile.Name(), Timeout: 5 * time.Second})
+	assert.Nil(t, err)
+
+	rep, err := fp.GetFlow(server.URL + "/flow.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "Secure", rep.Name)
+
+	_, err = NewRemoteFlowProvider(&RemoteConfig{CAFile: caFile.Name() + ".missing"})
+	assert.NotNil(t, err)
+}
+
+func TestBasicRemoteFlowProvider_YAML(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-yaml")
+		_, _ = w.Write([]byte("name: Remote\ntasks: []\n"))
+	}))
+	defer server.Close()
+
+	rep, err := NewBasicRemoteFlowProvider().GetFlow(server.URL + "/flow")
+	assert.Nil(t, err)
+	assert.Equal(t, "Remote", rep.Name)
+
+	dir, err := ioutil.TempDir("", "flows")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFlowFile(t, dir, "local.yml", "name: Local\ntasks: []\n", false)
+
+	rep, err = NewBasicRemoteFlowProvider().GetFlow("file://" + filepath.ToSlash(filepath.Join(dir, "local.yml")))
+	assert.Nil(t, err)
+	assert.Equal(t, "Local", rep.Name)
+}
@@ -0,0 +1,261 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/qingcloudhx/flow/model"
+)
+
+var (
+	// DurationBuckets are the upper bounds, in seconds, of the buckets of the duration histograms
+	DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// CountBuckets are the upper bounds of the buckets of the step and iteration count histograms
+	CountBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000}
+)
+
+var defaultCollector = NewCollector()
+
+// DefaultCollector returns the default Collector
+func DefaultCollector() *Collector {
+	return defaultCollector
+}
+
+// Collector collects the metrics of the executions of flows, the metrics are
+// aggregated per flow name
+type Collector struct {
+	mu    sync.Mutex
+	flows map[string]*flowMetrics
+}
+
+// NewCollector creates a new Collector
+func NewCollector() *Collector {
+	return &Collector{flows: make(map[string]*flowMetrics)}
+}
+
+type flowMetrics struct {
+	started   uint64
+	resumed   uint64
+	restarted uint64
+	completed uint64
+	failed    uint64
+	cancelled uint64
+
+	duration *histogram
+	steps    *histogram
+	tasks    map[string]*taskMetrics
+}
+
+type taskMetrics struct {
+	duration   *histogram
+	iterations *histogram
+}
+
+func (c *Collector) getFlowMetrics(flowName string) *flowMetrics {
+
+	fm, ok := c.flows[flowName]
+	if !ok {
+		fm = &flowMetrics{duration: newHistogram(DurationBuckets), steps: newHistogram(CountBuckets), tasks: make(map[string]*taskMetrics)}
+		c.flows[flowName] = fm
+	}
+
+	return fm
+}
+
+// FlowStarted records that an instance of the flow started its execution
+func (c *Collector) FlowStarted(flowName string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.getFlowMetrics(flowName).started++
+}
+
+// FlowResumed records that an instance of the flow resumed its execution, it isn't counted
+// as started again
+func (c *Collector) FlowResumed(flowName string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.getFlowMetrics(flowName).resumed++
+}
+
+// FlowRestarted records that an instance of the flow was restarted, it isn't counted as
+// started again
+func (c *Collector) FlowRestarted(flowName string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.getFlowMetrics(flowName).restarted++
+}
+
+// FlowFinished records the end of the execution of an instance of the flow, instances
+// that did not complete, fail or get cancelled are ignored.  The duration and steps of a
+// resumed or restarted instance are the ones of its last execution
+func (c *Collector) FlowFinished(flowName string, status model.FlowStatus, duration time.Duration, steps int) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fm := c.getFlowMetrics(flowName)
+
+	switch status {
+	case model.FlowStatusCompleted:
+		fm.completed++
+	case model.FlowStatusFailed:
+		fm.failed++
+	case model.FlowStatusCancelled:
+		fm.cancelled++
+	default:
+		return
+	}
+
+	fm.duration.observe(duration.Seconds())
+	fm.steps.observe(float64(steps))
+}
+
+// TaskFinished records the duration of the execution of a task of the flow, the number of
+// iterations is only recorded for iterator tasks
+func (c *Collector) TaskFinished(flowName string, taskID string, duration time.Duration, iterator bool, iterations int) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fm := c.getFlowMetrics(flowName)
+
+	tm, ok := fm.tasks[taskID]
+	if !ok {
+		tm = &taskMetrics{duration: newHistogram(DurationBuckets)}
+		fm.tasks[taskID] = tm
+	}
+
+	tm.duration.observe(duration.Seconds())
+
+	if iterator {
+		if tm.iterations == nil {
+			tm.iterations = newHistogram(CountBuckets)
+		}
+		tm.iterations.observe(float64(iterations))
+	}
+}
+
+// Reset discards the collected metrics
+func (c *Collector) Reset() {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flows = make(map[string]*flowMetrics)
+}
+
+// Snapshot is a copy of the metrics collected by a Collector
+type Snapshot struct {
+	Flows map[string]*FlowSnapshot `json:"flows"`
+}
+
+// FlowSnapshot contains the metrics of a flow
+type FlowSnapshot struct {
+	Started   uint64 `json:"started"`
+	Resumed   uint64 `json:"resumed"`
+	Restarted uint64 `json:"restarted"`
+	Completed uint64 `json:"completed"`
+	Failed    uint64 `json:"failed"`
+	Cancelled uint64 `json:"cancelled"`
+
+	// Duration is the histogram of the durations of the instances, in seconds
+	Duration *HistogramSnapshot `json:"duration"`
+	// Steps is the histogram of the number of steps executed by the instances
+	Steps *HistogramSnapshot `json:"steps"`
+
+	Tasks map[string]*TaskSnapshot `json:"tasks,omitempty"`
+}
+
+// TaskSnapshot contains the metrics of a task
+type TaskSnapshot struct {
+	// Duration is the histogram of the durations of the executions of the task, in seconds
+	Duration *HistogramSnapshot `json:"duration"`
+	// Iterations is the histogram of the number of iterations, only set for iterator tasks
+	Iterations *HistogramSnapshot `json:"iterations,omitempty"`
+}
+
+// HistogramSnapshot is a copy of a histogram, the counts of the buckets are cumulative
+type HistogramSnapshot struct {
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+	Buckets []*Bucket `json:"buckets"`
+}
+
+// Bucket is a bucket of a histogram
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// Snapshot returns a copy of the collected metrics
+func (c *Collector) Snapshot() *Snapshot {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snapshot := &Snapshot{Flows: make(map[string]*FlowSnapshot, len(c.flows))}
+
+	for name, fm := range c.flows {
+		fs := &FlowSnapshot{Started: fm.started, Resumed: fm.resumed, Restarted: fm.restarted, Completed: fm.completed,
+			Failed: fm.failed, Cancelled: fm.cancelled, Duration: fm.duration.snapshot(), Steps: fm.steps.snapshot()}
+
+		if len(fm.tasks) > 0 {
+			fs.Tasks = make(map[string]*TaskSnapshot, len(fm.tasks))
+			for id, tm := range fm.tasks {
+				fs.Tasks[id] = &TaskSnapshot{Duration: tm.duration.snapshot(), Iterations: tm.iterations.snapshot()}
+			}
+		}
+
+		snapshot.Flows[name] = fs
+	}
+
+	return snapshot
+}
+
+// histogram counts observations in buckets, it is guarded by the lock of its Collector
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(value float64) {
+
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+
+	h.count++
+	h.sum += value
+}
+
+func (h *histogram) snapshot() *HistogramSnapshot {
+
+	if h == nil {
+		return nil
+	}
+
+	hs := &HistogramSnapshot{Count: h.count, Sum: h.sum, Buckets: make([]*Bucket, len(h.bounds))}
+
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		hs.Buckets[i] = &Bucket{UpperBound: bound, Count: cumulative}
+	}
+
+	return hs
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/qingcloudhx/flow/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector_Snapshot(t *testing.T) {
+
+	c := NewCollector()
+	c.FlowStarted("flow")
+	c.FlowStarted("flow")
+	c.FlowStarted("flow")
+	c.FlowResumed("flow")
+	c.FlowRestarted("flow")
+	c.FlowFinished("flow", model.FlowStatusCompleted, 20*time.Millisecond, 3)
+	c.FlowFinished("flow", model.FlowStatusFailed, 2*time.Second, 30)
+	c.FlowFinished("flow", model.FlowStatusActive, time.Second, 1)
+	c.TaskFinished("flow", "loop", 5*time.Millisecond, true, 4)
+	c.TaskFinished("flow", "log", time.Millisecond, false, 0)
+
+	fs := c.Snapshot().Flows["flow"]
+	assert.Equal(t, uint64(3), fs.Started)
+	assert.Equal(t, uint64(1), fs.Resumed)
+	assert.Equal(t, uint64(1), fs.Restarted)
+	assert.Equal(t, uint64(1), fs.Completed)
+	assert.Equal(t, uint64(1), fs.Failed)
+	assert.Equal(t, uint64(0), fs.Cancelled)
+
+	assert.Equal(t, uint64(2), fs.Duration.Count)
+	assert.InDelta(t, 2.02, fs.Duration.Sum, 0.0001)
+	// .025 is the third bucket, 2.5 the ninth
+	assert.Equal(t, uint64(1), fs.Duration.Buckets[2].Count)
+	assert.Equal(t, uint64(2), fs.Duration.Buckets[8].Count)
+
+	assert.Equal(t, uint64(2), fs.Steps.Count)
+	assert.Equal(t, float64(4), fs.Tasks["loop"].Iterations.Sum)
+	assert.Equal(t, uint64(0), fs.Tasks["loop"].Iterations.Buckets[1].Count)
+	assert.Equal(t, uint64(1), fs.Tasks["loop"].Iterations.Buckets[2].Count)
+	assert.Nil(t, fs.Tasks["log"].Iterations)
+
+	c.Reset()
+	assert.Empty(t, c.Snapshot().Flows)
+}
+
+func TestCollector_WritePrometheus(t *testing.T) {
+
+	c := NewCollector()
+	c.FlowStarted(`my "flow"`)
+	c.FlowFinished(`my "flow"`, model.FlowStatusCompleted, 20*time.Millisecond, 3)
+	c.TaskFinished(`my "flow"`, "log", time.Millisecond, false, 0)
+
+	var buf bytes.Buffer
+	err := c.WritePrometheus(&buf)
+	assert.Nil(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE flogo_flow_instances_started_total counter\n")
+	assert.Contains(t, out, `flogo_flow_instances_started_total{flow="my \"flow\""} 1`)
+	assert.Contains(t, out, `flogo_flow_duration_seconds_bucket{flow="my \"flow\"",le="0.025"} 1`)
+	assert.Contains(t, out, `flogo_flow_duration_seconds_bucket{flow="my \"flow\"",le="+Inf"} 1`)
+	assert.Contains(t, out, `flogo_flow_steps_count{flow="my \"flow\""} 1`)
+	assert.Contains(t, out, `flogo_flow_task_duration_seconds_count{flow="my \"flow\"",task="log"} 1`)
+	assert.NotContains(t, out, "flogo_flow_task_iterations_count")
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ContentTypePrometheus is the content type of the Prometheus text exposition format
+const ContentTypePrometheus = "text/plain; version=0.0.4; charset=utf-8"
+
+const namespace = "flogo_flow_"
+
+// ServeHTTP serves the collected metrics using the Prometheus text exposition format
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", ContentTypePrometheus)
+
+	if err := c.WritePrometheus(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// WritePrometheus writes the collected metrics using the Prometheus text exposition format
+func (c *Collector) WritePrometheus(w io.Writer) error {
+
+	snapshot := c.Snapshot()
+
+	flowNames := make([]string, 0, len(snapshot.Flows))
+	for name := range snapshot.Flows {
+		flowNames = append(flowNames, name)
+	}
+	sort.Strings(flowNames)
+
+	pw := &promWriter{w: bufio.NewWriter(w)}
+
+	counters := []struct {
+		name, help string
+		value      func(fs *FlowSnapshot) uint64
+	}{
+		{"instances_started_total", "Number of flow instances started.", func(fs *FlowSnapshot) uint64 { return fs.Started }},
+		{"instances_resumed_total", "Number of flow instances resumed.", func(fs *FlowSnapshot) uint64 { return fs.Resumed }},
+		{"instances_restarted_total", "Number of flow instances restarted.", func(fs *FlowSnapshot) uint64 { return fs.Restarted }},
+		{"instances_completed_total", "Number of flow instances completed.", func(fs *FlowSnapshot) uint64 { return fs.Completed }},
+		{"instances_failed_total", "Number of flow instances failed.", func(fs *FlowSnapshot) uint64 { return fs.Failed }},
+		{"instances_cancelled_total", "Number of flow instances cancelled.", func(fs *FlowSnapshot) uint64 { return fs.Cancelled }},
+	}
+
+	for _, counter := range counters {
+		pw.header(counter.name, counter.help, "counter")
+		for _, name := range flowNames {
+			pw.sample(counter.name, labels("flow", name), float64(counter.value(snapshot.Flows[name])))
+		}
+	}
+
+	pw.header("duration_seconds", "Duration of the flow instances.", "histogram")
+	for _, name := range flowNames {
+		pw.histogram("duration_seconds", labels("flow", name), snapshot.Flows[name].Duration)
+	}
+
+	pw.header("steps", "Number of steps executed by the flow instances.", "histogram")
+	for _, name := range flowNames {
+		pw.histogram("steps", labels("flow", name), snapshot.Flows[name].Steps)
+	}
+
+	pw.header("task_duration_seconds", "Duration of the executions of the tasks.", "histogram")
+	for _, name := range flowNames {
+		tasks := snapshot.Flows[name].Tasks
+		for _, id := range sortedTaskIDs(tasks) {
+			pw.histogram("task_duration_seconds", labels("flow", name, "task", id), tasks[id].Duration)
+		}
+	}
+
+	pw.header("task_iterations", "Number of iterations of the executions of the iterator tasks.", "histogram")
+	for _, name := range flowNames {
+		tasks := snapshot.Flows[name].Tasks
+		for _, id := range sortedTaskIDs(tasks) {
+			if tasks[id].Iterations != nil {
+				pw.histogram("task_iterations", labels("flow", name, "task", id), tasks[id].Iterations)
+			}
+		}
+	}
+
+	if pw.err != nil {
+		return pw.err
+	}
+
+	return pw.w.Flush()
+}
+
+func sortedTaskIDs(tasks map[string]*TaskSnapshot) []string {
+
+	ids := make([]string, 0, len(tasks))
+	for id := range tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats the label name and value pairs
+func labels(pairs ...string) string {
+
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(labelValueEscaper.Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+
+	return b.String()
+}
+
+// promWriter writes metrics in the Prometheus text format, retaining the first error
+type promWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (pw *promWriter) write(s ...string) {
+	for _, str := range s {
+		if pw.err != nil {
+			return
+		}
+		_, pw.err = pw.w.WriteString(str)
+	}
+}
+
+func (pw *promWriter) header(name, help, metricType string) {
+	pw.write("# HELP ", namespace, name, " ", help, "\n")
+	pw.write("# TYPE ", namespace, name, " ", metricType, "\n")
+}
+
+func (pw *promWriter) sample(name, labels string, value float64) {
+	pw.write(namespace, name, "{", labels, "} ", formatFloat(value), "\n")
+}
+
+func (pw *promWriter) histogram(name, lbls string, hs *HistogramSnapshot) {
+
+	for _, bucket := range hs.Buckets {
+		pw.sample(name+"_bucket", lbls+","+labels("le", formatFloat(bucket.UpperBound)), float64(bucket.Count))
+	}
+
+	pw.sample(name+"_bucket", lbls+","+labels("le", "+Inf"), float64(hs.Count))
+	pw.sample(name+"_sum", lbls, hs.Sum)
+	pw.sample(name+"_count", lbls, float64(hs.Count))
+}
+
+func formatFloat(value float64) string {
+
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
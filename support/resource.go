@@ -1,8 +1,13 @@
 package support
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/qingcloudhx/core/app/resource"
 	"github.com/qingcloudhx/flow/definition"
@@ -10,18 +15,38 @@ import (
 
 const (
 	ResTypeFlow = "flow"
+
+	checksumExt = ".sha256"
 )
 
 type FlowLoader struct {
+	// Verifier verifies the signatures of the flow resources, if set
+	Verifier *FlowVerifier
+	// IntegrityDir is the directory of the detached signatures, <name>.sig, and checksums,
+	// <name>.sha256, of the flow resources "flow:<name>".  They are computed over the compacted
+	// JSON of the flow, or over the YAML of the flow if it is a YAML string.
+	IntegrityDir string
 }
 
-func (*FlowLoader) LoadResource(config *resource.Config) (*resource.Resource, error) {
-	var flowDefBytes []byte
+// LoadResource loads a flow resource, its data is the JSON of the flow or a string containing
+// the YAML of the flow
 
-	flowDefBytes = config.Data
+func (l *FlowLoader) LoadResource(config *resource.Config) (*resource.Resource, error) {
+	flowDefBytes, yamlFormat, err := resourceContent(config.Data)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling flow resource with id '%s', %s", config.ID, err.Error())
+	}
+
+	if err := l.verify(config.ID, flowDefBytes); err != nil {
+		return nil, fmt.Errorf("error verifying flow resource with id '%s', %s", config.ID, err.Error())
+	}
 
 	var defRep *definition.DefinitionRep
-	err := json.Unmarshal(flowDefBytes, &defRep)
+	if yamlFormat {
+		defRep, err = definition.DecodeYAML(flowDefBytes)
+	} else {
+		err = json.Unmarshal(flowDefBytes, &defRep)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling flow resource with id '%s', %s", config.ID, err.Error())
 	}
@@ -33,3 +58,67 @@ func (*FlowLoader) LoadResource(config *resource.Config) (*resource.Resource, er
 
 	return resource.New(ResTypeFlow, flow), nil
 }
+
+// resourceContent returns the compacted JSON of the flow, or its YAML if the data is a string
+func resourceContent(data json.RawMessage) ([]byte, bool, error) {
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '"' {
+		var yamlFlow string
+		if err := json.Unmarshal(trimmed, &yamlFlow); err != nil {
+			return nil, false, err
+		}
+		return []byte(yamlFlow), true, nil
+	}
+
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, trimmed); err != nil {
+		return nil, false, err
+	}
+
+	return compacted.Bytes(), false, nil
+}
+
+// verify checks the checksum and the signature of the flow resource, if any
+func (l *FlowLoader) verify(id string, content []byte) error {
+
+	if l.Verifier == nil && l.IntegrityDir == "" {
+		return nil
+	}
+
+	var signature []byte
+
+	if l.IntegrityDir != "" {
+		name := strings.TrimPrefix(id, ResTypeFlow+":")
+		basePath := filepath.Join(l.IntegrityDir, filepath.FromSlash(name))
+
+		checksum, err := ioutil.ReadFile(basePath + checksumExt)
+		if err == nil {
+			// supports the output of sha256sum, "<hex digest>  <file>"
+			fields := strings.Fields(string(checksum))
+			if len(fields) == 0 {
+				return fmt.Errorf("empty checksum file '%s'", basePath+checksumExt)
+			}
+			c, err := ParseChecksum("sha256=" + fields[0])
+			if err != nil {
+				return err
+			}
+			if err := c.Verify(content); err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		signature, err = ioutil.ReadFile(basePath + signatureExt)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if l.Verifier != nil {
+		return l.Verifier.Verify(content, signature)
+	}
+
+	return nil
+}
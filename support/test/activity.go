@@ -0,0 +1,46 @@
+package test
+
+import (
+	"github.com/qingcloudhx/core/activity"
+)
+
+func init() {
+	_ = activity.Register(&EchoActivity{}, NewEchoActivity)
+}
+
+// EchoActivityRef is the ref of the EchoActivity
+const EchoActivityRef = "github.com/qingcloudhx/flow/support/test"
+
+type Input struct {
+	Message interface{} `md:"message"`
+}
+
+type Output struct {
+	Message interface{} `md:"message"`
+}
+
+var echoActivityMd = activity.ToMetadata(&Input{}, &Output{})
+
+// EchoActivity is a simple test activity that sets its 'message' output to its 'message' input
+type EchoActivity struct {
+}
+
+func NewEchoActivity(ctx activity.InitContext) (activity.Activity, error) {
+	return &EchoActivity{}, nil
+}
+
+// Metadata implements activity.Activity.Metadata
+func (a *EchoActivity) Metadata() *activity.Metadata {
+	return echoActivityMd
+}
+
+// Eval implements activity.Activity.Eval
+func (a *EchoActivity) Eval(ctx activity.Context) (done bool, err error) {
+
+	err = ctx.SetOutput("message", ctx.GetInput("message"))
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
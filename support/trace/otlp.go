@@ -0,0 +1,184 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ScopeName is the name of the instrumentation scope of the spans created by flows
+const ScopeName = "github.com/qingcloudhx/flow"
+
+// FileExporter is an Exporter that writes the spans to a file using the OTLP JSON encoding,
+// each line of the file is an OTLP ExportTraceServiceRequest, like the files written by the
+// file exporter of the OpenTelemetry Collector
+type FileExporter struct {
+	mu          sync.Mutex
+	w           io.Writer
+	closer      io.Closer
+	serviceName string
+}
+
+// NewFileExporter creates a FileExporter that appends the spans to the file
+func NewFileExporter(path string, serviceName string) (*FileExporter, error) {
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileExporter{w: f, closer: f, serviceName: serviceName}, nil
+}
+
+// NewWriterExporter creates a FileExporter that writes the spans to the writer
+func NewWriterExporter(w io.Writer, serviceName string) *FileExporter {
+	return &FileExporter{w: w, serviceName: serviceName}
+}
+
+// ExportSpans implements Exporter.ExportSpans
+func (e *FileExporter) ExportSpans(spans []*Span) error {
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(e.toRequest(spans))
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+// Shutdown implements Exporter.Shutdown
+func (e *FileExporter) Shutdown() error {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+
+	return nil
+}
+
+// The OTLP JSON representation of the spans, see opentelemetry/proto/trace/v1/trace.proto
+
+type otlpRequest struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   *otlpResource     `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope *otlpScope  `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []*otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// spanKindInternal is the OTLP SPAN_KIND_INTERNAL
+const spanKindInternal = 1
+
+func (e *FileExporter) toRequest(spans []*Span) *otlpRequest {
+
+	scopeSpans := &otlpScopeSpans{Scope: &otlpScope{Name: ScopeName}}
+
+	for _, span := range spans {
+		exported := &otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        toKeyValues(span.Attributes),
+			Status:            &otlpStatus{Code: span.Status, Message: span.Message},
+		}
+
+		if span.Parent.IsValid() {
+			exported.ParentSpanID = span.Parent.String()
+		}
+
+		scopeSpans.Spans = append(scopeSpans.Spans, exported)
+	}
+
+	resource := &otlpResource{Attributes: toKeyValues(map[string]interface{}{"service.name": e.serviceName})}
+
+	return &otlpRequest{ResourceSpans: []*otlpResourceSpans{{Resource: resource, ScopeSpans: []*otlpScopeSpans{scopeSpans}}}}
+}
+
+// toKeyValues converts the attributes to OTLP key values, sorted by key
+func toKeyValues(attrs map[string]interface{}) []*otlpKeyValue {
+
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	kvs := make([]*otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		kvs = append(kvs, &otlpKeyValue{Key: key, Value: toAnyValue(attrs[key])})
+	}
+
+	return kvs
+}
+
+func toAnyValue(value interface{}) map[string]interface{} {
+
+	switch v := value.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprintf("%v", v)}
+	}
+}
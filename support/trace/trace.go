@@ -0,0 +1,229 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+// String returns the hex encoding of the TraceID
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid indicates if the TraceID is not all zeros
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID identifies a span of a trace
+type SpanID [8]byte
+
+// String returns the hex encoding of the SpanID
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid indicates if the SpanID is not all zeros
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext identifies a span and the trace it belongs to, it is what is propagated
+// to the spans created by a flow
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+// IsValid indicates if both the TraceID and the SpanID are valid
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// TraceParent returns the W3C Trace Context 'traceparent' header value of the span context
+func (sc SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// ParseTraceParent parses a W3C Trace Context 'traceparent' header value
+func ParseTraceParent(value string) (SpanContext, error) {
+
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, errors.New("invalid traceparent: " + value)
+	}
+
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return sc, errors.New("invalid trace id in traceparent: " + value)
+	}
+
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return sc, errors.New("invalid span id in traceparent: " + value)
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+
+	if !sc.IsValid() {
+		return sc, errors.New("invalid traceparent: " + value)
+	}
+
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext returns a copy of the context that carries the span context, it is
+// used by triggers to propagate the trace of a request to the flows it starts
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context carried by the context
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// StatusCode is the status of a span, its values match the OTLP status codes
+type StatusCode int
+
+const (
+	// StatusUnset is the default status
+	StatusUnset StatusCode = 0
+	// StatusOK indicates that the operation completed successfully
+	StatusOK StatusCode = 1
+	// StatusError indicates that the operation failed
+	StatusError StatusCode = 2
+)
+
+// Span is a timed operation of a trace
+type Span struct {
+	tracer *Tracer
+
+	mu sync.Mutex
+
+	Name       string
+	Context    SpanContext
+	Parent     SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Status     StatusCode
+	Message    string
+}
+
+// SetAttribute sets an attribute of the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{})
+	}
+	s.Attributes[key] = value
+}
+
+// SetStatus sets the status of the span
+func (s *Span) SetStatus(code StatusCode, message string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Status = code
+	s.Message = message
+}
+
+// Finish ends the span and exports it, only the first call has an effect
+func (s *Span) Finish() {
+
+	s.mu.Lock()
+	if !s.End.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.End = time.Now()
+	s.mu.Unlock()
+
+	s.tracer.export(s)
+}
+
+// Ended indicates if the span was finished
+func (s *Span) Ended() bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return !s.End.IsZero()
+}
+
+// Exporter exports finished spans
+type Exporter interface {
+	// ExportSpans exports the spans
+	ExportSpans(spans []*Span) error
+	// Shutdown flushes the pending spans and releases the resources of the exporter
+	Shutdown() error
+}
+
+// ErrorHandler handles the errors of an Exporter
+type ErrorHandler func(err error)
+
+// Tracer creates spans and exports them when they are finished
+type Tracer struct {
+	exporter Exporter
+	onError  ErrorHandler
+}
+
+// NewTracer creates a Tracer that exports its spans using the exporter, the errors
+// of the exporter are passed to onError which can be nil
+func NewTracer(exporter Exporter, onError ErrorHandler) *Tracer {
+	return &Tracer{exporter: exporter, onError: onError}
+}
+
+// StartSpan starts a span, it is the root of a new trace if the parent is not valid
+func (t *Tracer) StartSpan(name string, parent SpanContext) *Span {
+
+	span := &Span{tracer: t, Name: name, Start: time.Now()}
+
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Parent = parent.SpanID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+	}
+
+	rand.Read(span.Context.SpanID[:])
+
+	return span
+}
+
+// Shutdown shuts down the exporter of the tracer
+func (t *Tracer) Shutdown() error {
+	return t.exporter.Shutdown()
+}
+
+func (t *Tracer) export(span *Span) {
+
+	if err := t.exporter.ExportSpans([]*Span{span}); err != nil && t.onError != nil {
+		t.onError(err)
+	}
+}
@@ -0,0 +1,67 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+
+	sc, err := ParseTraceParent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	assert.Nil(t, err)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", sc.TraceID.String())
+	assert.Equal(t, "b7ad6b7169203331", sc.SpanID.String())
+	assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", sc.TraceParent())
+
+	for _, invalid := range []string{"", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01", "00-0af7651916cd43dd-b7ad6b7169203331-01"} {
+		_, err = ParseTraceParent(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestSpanContextFromContext(t *testing.T) {
+
+	_, ok := SpanContextFromContext(context.Background())
+	assert.False(t, ok)
+
+	sc, _ := ParseTraceParent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	actual, ok := SpanContextFromContext(ContextWithSpanContext(context.Background(), sc))
+	assert.True(t, ok)
+	assert.Equal(t, sc, actual)
+}
+
+func TestFileExporter(t *testing.T) {
+
+	var buf bytes.Buffer
+	tracer := NewTracer(NewWriterExporter(&buf, "test"), nil)
+
+	root := tracer.StartSpan("root", SpanContext{})
+	child := tracer.StartSpan("child", root.Context)
+	child.SetAttribute("task.id", "log")
+	child.SetAttribute("task.iterations", 2)
+	child.SetStatus(StatusError, "failed")
+	child.Finish()
+	child.Finish()
+	root.Finish()
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+
+	var req map[string]interface{}
+	err := json.Unmarshal(lines[0], &req)
+	assert.Nil(t, err)
+
+	rs := req["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	span := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+
+	assert.Equal(t, "child", span["name"])
+	assert.Equal(t, root.Context.TraceID.String(), span["traceId"])
+	assert.Equal(t, root.Context.SpanID.String(), span["parentSpanId"])
+	assert.Equal(t, map[string]interface{}{"code": float64(2), "message": "failed"}, span["status"])
+	assert.Contains(t, span["attributes"], map[string]interface{}{"key": "task.iterations", "value": map[string]interface{}{"intValue": "2"}})
+}
@@ -0,0 +1,50 @@
+package tester
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// GetCoverage returns the task and link coverage of the flows executed by the tester
+// (GET "/coverage"), the 'format' query parameter selects json (default), dot or html.
+//
+// To get the coverage as a DOT graph, try this at a shell:
+// $ curl http://localhost:8080/coverage?format=dot
+func (et *RestEngineTester) GetCoverage(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	report := et.coverage.Report()
+
+	var err error
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		err = report.WriteJSON(w)
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		err = report.WriteDOT(w)
+	case "html":
+		w.Header().Set("Content-Type", "text/html")
+		err = report.WriteHTML(w)
+	default:
+		http.Error(w, "unsupported coverage format: "+format, http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		et.logger.Errorf("Unable to write coverage report: %v", err)
+	}
+}
+
+// ResetCoverage discards the coverage collected by the tester (DELETE "/coverage").
+func (et *RestEngineTester) ResetCoverage(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	et.coverage.Reset()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,263 @@
+package coverage
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/instance"
+	"github.com/qingcloudhx/flow/model"
+)
+
+// Collector is an instance.ExecListener that collects the coverage of the tasks and links of
+// the flows executed by instances, the coverage is aggregated per flow name so that it
+// survives the reloading of the flow definitions
+type Collector struct {
+	mu    sync.Mutex
+	flows map[string]*flowCoverage
+}
+
+// NewCollector creates a new Collector
+func NewCollector() *Collector {
+	return &Collector{flows: make(map[string]*flowCoverage)}
+}
+
+type flowCoverage struct {
+	def   *definition.Definition
+	runs  int
+	tasks map[string]*TaskCoverage
+	links map[int]*LinkCoverage
+
+	// active are the instances executing the flow, they are dropped when done
+	active map[string]bool
+}
+
+// TaskStatusChanged implements instance.ExecListener.TaskStatusChanged
+func (c *Collector) TaskStatusChanged(inst *instance.IndependentInstance, taskInst *instance.TaskInst) {
+
+	task := taskInst.Task()
+	if task.Definition() == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fc := c.getFlowCoverage(task.Definition())
+	fc.addInstance(inst.ID())
+
+	tc, ok := fc.tasks[task.ID()]
+	if !ok {
+		return
+	}
+
+	switch taskInst.Status() {
+	case model.TaskStatusDone:
+		tc.Done++
+	case model.TaskStatusSkipped:
+		tc.Skipped++
+	case model.TaskStatusFailed:
+		tc.Failed++
+	}
+}
+
+// LinkStatusChanged implements instance.ExecListener.LinkStatusChanged
+func (c *Collector) LinkStatusChanged(inst *instance.IndependentInstance, linkInst *instance.LinkInst) {
+
+	link := linkInst.Link()
+	if link.FromTask() == nil || link.FromTask().Definition() == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fc := c.getFlowCoverage(link.FromTask().Definition())
+	fc.addInstance(inst.ID())
+
+	lc, ok := fc.links[link.ID()]
+	if !ok {
+		return
+	}
+
+	switch linkInst.Status() {
+	case model.LinkStatusTrue:
+		lc.True++
+	case model.LinkStatusFalse:
+		lc.False++
+	case model.LinkStatusSkipped:
+		lc.Skipped++
+	}
+}
+
+// InstanceDone implements instance.DoneListener.InstanceDone
+func (c *Collector) InstanceDone(inst *instance.IndependentInstance) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, fc := range c.flows {
+		delete(fc.active, inst.ID())
+	}
+}
+
+// Reset discards the collected coverage
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	c.flows = make(map[string]*flowCoverage)
+	c.mu.Unlock()
+}
+
+// Report creates a report of the collected coverage
+func (c *Collector) Report() *Report {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	report := &Report{Flows: make([]*FlowCoverage, 0, len(c.flows))}
+
+	for _, fc := range c.flows {
+		report.Flows = append(report.Flows, fc.toFlowCoverage())
+	}
+
+	sort.Slice(report.Flows, func(i, j int) bool {
+		return report.Flows[i].Name < report.Flows[j].Name
+	})
+
+	return report
+}
+
+// getFlowCoverage gets the coverage of the flow of the definition, c.mu must be held
+func (c *Collector) getFlowCoverage(def *definition.Definition) *flowCoverage {
+
+	fc, ok := c.flows[def.Name()]
+	if ok {
+		if fc.def != def {
+			// the flow was reloaded, the coverage of its existing tasks and links is kept
+			fc.addDefinition(def)
+		}
+		return fc
+	}
+
+	fc = &flowCoverage{active: make(map[string]bool), tasks: make(map[string]*TaskCoverage),
+		links: make(map[int]*LinkCoverage)}
+	fc.addDefinition(def)
+
+	c.flows[def.Name()] = fc
+
+	return fc
+}
+
+// addDefinition adds the tasks and links of the definition that aren't covered yet
+func (fc *flowCoverage) addDefinition(def *definition.Definition) {
+
+	fc.def = def
+
+	for _, task := range def.Tasks() {
+		if _, exists := fc.tasks[task.ID()]; !exists {
+			fc.tasks[task.ID()] = &TaskCoverage{ID: task.ID(), Name: task.Name()}
+		}
+	}
+	for _, link := range def.Links() {
+		if _, exists := fc.links[link.ID()]; !exists {
+			fc.links[link.ID()] = newLinkCoverage(link, false)
+		}
+	}
+
+	if eh := def.GetErrorHandler(); eh != nil {
+		for _, task := range eh.Tasks() {
+			if _, exists := fc.tasks[task.ID()]; !exists {
+				fc.tasks[task.ID()] = &TaskCoverage{ID: task.ID(), Name: task.Name(), ErrorHandler: true}
+			}
+		}
+		for _, link := range eh.Links() {
+			if _, exists := fc.links[link.ID()]; !exists {
+				fc.links[link.ID()] = newLinkCoverage(link, true)
+			}
+		}
+	}
+}
+
+// addInstance counts the run of an instance the first time it is seen
+func (fc *flowCoverage) addInstance(id string) {
+	if !fc.active[id] {
+		fc.active[id] = true
+		fc.runs++
+	}
+}
+
+func newLinkCoverage(link *definition.Link, errorHandler bool) *LinkCoverage {
+
+	lc := &LinkCoverage{ID: link.ID(), Type: linkTypeName(link.Type()), ErrorHandler: errorHandler}
+
+	if link.FromTask() != nil {
+		lc.From = link.FromTask().ID()
+	}
+	if link.ToTask() != nil {
+		lc.To = link.ToTask().ID()
+	}
+	if link.Type() == definition.LtExpression {
+		lc.Expr = link.Value()
+	}
+
+	return lc
+}
+
+func (fc *flowCoverage) toFlowCoverage() *FlowCoverage {
+
+	coverage := &FlowCoverage{Name: fc.def.Name(), Runs: fc.runs}
+
+	executed, branches, covered := 0, 0, 0
+
+	for _, tc := range fc.tasks {
+		cp := *tc
+		coverage.Tasks = append(coverage.Tasks, &cp)
+
+		if tc.Executed() {
+			executed++
+		}
+	}
+
+	for _, lc := range fc.links {
+		cp := *lc
+		coverage.Links = append(coverage.Links, &cp)
+
+		branches += lc.branches()
+		covered += lc.coveredBranches()
+	}
+
+	sort.Slice(coverage.Tasks, func(i, j int) bool {
+		return coverage.Tasks[i].ID < coverage.Tasks[j].ID
+	})
+	sort.Slice(coverage.Links, func(i, j int) bool {
+		return coverage.Links[i].ID < coverage.Links[j].ID
+	})
+
+	coverage.TaskCoverage = ratio(executed, len(fc.tasks))
+	coverage.LinkCoverage = ratio(covered, branches)
+
+	return coverage
+}
+
+func ratio(n, total int) float64 {
+	if total == 0 {
+		return 1
+	}
+
+	return float64(n) / float64(total)
+}
+
+func linkTypeName(linkType definition.LinkType) string {
+	switch linkType {
+	case definition.LtDependency:
+		return "dependency"
+	case definition.LtExpression:
+		return "expression"
+	case definition.LtLabel:
+		return "label"
+	case definition.LtError:
+		return "error"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,139 @@
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/qingcloudhx/core/data/expression"
+	_ "github.com/qingcloudhx/core/data/expression/script"
+	_ "github.com/qingcloudhx/core/support/test"
+	"github.com/qingcloudhx/flow/definition"
+	_ "github.com/qingcloudhx/flow/support/test"
+	"github.com/qingcloudhx/flow/tester/suite"
+	"github.com/stretchr/testify/assert"
+)
+
+const coverageDefJSON = `
+{
+  "name": "coverage-flow",
+  "model": "test",
+  "metadata": {
+    "input": [ { "name": "in", "type": "string" } ]
+  },
+  "tasks": [
+    { "id": "log1", "activity": { "ref": "testlog", "input": { "message": "=$flow.in" } } },
+    { "id": "log2", "activity": { "ref": "testlog", "input": { "message": "a" } } },
+    { "id": "log3", "activity": { "ref": "testlog", "input": { "message": "b" } } }
+  ],
+  "links": [
+    { "from": "log1", "to": "log2", "type": "expression", "value": "$flow.in == 'a'" },
+    { "from": "log1", "to": "log3", "type": "expression", "value": "$flow.in == 'b'" }
+  ]
+}
+`
+
+func init() {
+	definition.SetExprFactory(expression.NewFactory(definition.GetDataResolver()))
+}
+
+func runCoverageCases(t *testing.T, collector *Collector, inputs ...string) {
+
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(coverageDefJSON), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	runner := suite.NewRunner()
+	runner.Listener = collector
+	runner.Resolver = func(flowURI string) (*definition.Definition, error) {
+		return def, nil
+	}
+
+	s := &suite.Suite{Name: "coverage", FlowURI: "res://flow:coverage"}
+	for _, in := range inputs {
+		s.Cases = append(s.Cases, &suite.Case{Name: in, Inputs: map[string]interface{}{"in": in}})
+	}
+
+	report := runner.Run(s)
+	assert.True(t, report.Success())
+}
+
+func TestCollector_Report(t *testing.T) {
+
+	collector := NewCollector()
+	runCoverageCases(t, collector, "a", "a")
+
+	report := collector.Report()
+	assert.Len(t, report.Flows, 1)
+
+	fc := report.Flows[0]
+	assert.Equal(t, "coverage-flow", fc.Name)
+	assert.Equal(t, 2, fc.Runs)
+
+	assert.Equal(t, 2, fc.Tasks[0].Done)
+	assert.Equal(t, 2, fc.Tasks[1].Done)
+	assert.False(t, fc.Tasks[2].Executed())
+	assert.InDelta(t, 2.0/3.0, fc.TaskCoverage, 0.001)
+
+	assert.Equal(t, 2, fc.Links[0].True)
+	assert.Equal(t, 2, fc.Links[1].False)
+	assert.InDelta(t, 0.5, fc.LinkCoverage, 0.001)
+}
+
+func TestCollector_Aggregate(t *testing.T) {
+
+	collector := NewCollector()
+	runCoverageCases(t, collector, "a", "b")
+
+	fc := collector.Report().Flows[0]
+	assert.Equal(t, 1.0, fc.TaskCoverage)
+	assert.Equal(t, 1.0, fc.LinkCoverage)
+
+	collector.Reset()
+	assert.Len(t, collector.Report().Flows, 0)
+}
+
+func TestCollector_ReloadedFlow(t *testing.T) {
+
+	collector := NewCollector()
+
+	// each call creates a new definition of the flow, like a reload by the flow cache
+	runCoverageCases(t, collector, "a")
+	runCoverageCases(t, collector, "b")
+
+	report := collector.Report()
+	if assert.Len(t, report.Flows, 1) {
+		assert.Equal(t, 2, report.Flows[0].Runs)
+		assert.Equal(t, 1.0, report.Flows[0].TaskCoverage)
+	}
+
+	// the instances are dropped once done
+	collector.mu.Lock()
+	for _, fc := range collector.flows {
+		assert.Empty(t, fc.active)
+	}
+	collector.mu.Unlock()
+}
+
+func TestReport_Write(t *testing.T) {
+
+	collector := NewCollector()
+	runCoverageCases(t, collector, "a")
+	report := collector.Report()
+
+	var b bytes.Buffer
+	assert.Nil(t, report.WriteDOT(&b))
+	assert.Contains(t, b.String(), `"0_log1" -> "0_log3"`)
+	assert.Contains(t, b.String(), "style=dashed")
+
+	b.Reset()
+	assert.Nil(t, report.WriteHTML(&b))
+	assert.Contains(t, b.String(), "coverage-flow")
+
+	b.Reset()
+	assert.Nil(t, report.WriteJSON(&b))
+	assert.Contains(t, b.String(), `"taskCoverage"`)
+}
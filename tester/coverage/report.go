@@ -0,0 +1,185 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// Report contains the coverage of the flows
+type Report struct {
+	Flows []*FlowCoverage `json:"flows"`
+}
+
+// FlowCoverage contains the coverage of the tasks and links of a flow
+type FlowCoverage struct {
+	Name string `json:"name"`
+	// Runs is the number of instances that executed the flow
+	Runs int `json:"runs"`
+	// TaskCoverage is the ratio of tasks that were executed
+	TaskCoverage float64 `json:"taskCoverage"`
+	// LinkCoverage is the ratio of link outcomes that were observed, expression links
+	// have two outcomes (true and false) while other links only have one (true)
+	LinkCoverage float64 `json:"linkCoverage"`
+
+	Tasks []*TaskCoverage `json:"tasks"`
+	Links []*LinkCoverage `json:"links"`
+}
+
+// TaskCoverage contains the number of times a task completed, was skipped or failed
+type TaskCoverage struct {
+	ID           string `json:"id"`
+	Name         string `json:"name,omitempty"`
+	ErrorHandler bool   `json:"errorHandler,omitempty"`
+	Done         int    `json:"done"`
+	Skipped      int    `json:"skipped"`
+	Failed       int    `json:"failed"`
+}
+
+// Executed indicates if the task was executed
+func (tc *TaskCoverage) Executed() bool {
+	return tc.Done > 0 || tc.Failed > 0
+}
+
+// LinkCoverage contains the number of times a link evaluated to true or false or was skipped
+type LinkCoverage struct {
+	ID           int    `json:"id"`
+	From         string `json:"from"`
+	To           string `json:"to"`
+	Type         string `json:"type"`
+	Expr         string `json:"expr,omitempty"`
+	ErrorHandler bool   `json:"errorHandler,omitempty"`
+	True         int    `json:"true"`
+	False        int    `json:"false"`
+	Skipped      int    `json:"skipped"`
+}
+
+func (lc *LinkCoverage) branches() int {
+	if lc.Expr != "" {
+		return 2
+	}
+	return 1
+}
+
+func (lc *LinkCoverage) coveredBranches() int {
+	covered := 0
+	if lc.True > 0 {
+		covered++
+	}
+	if lc.Expr != "" && lc.False > 0 {
+		covered++
+	}
+	return covered
+}
+
+// WriteJSON writes the report as JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteDOT writes the report as a DOT graph, each flow is a cluster in which the tasks that were
+// never executed are grey, the tasks that only failed are red and the links label their outcomes
+func (r *Report) WriteDOT(w io.Writer) error {
+
+	var b strings.Builder
+
+	b.WriteString("digraph coverage {\n")
+	b.WriteString("  node [shape=box, style=filled];\n")
+
+	for i, fc := range r.Flows {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%q;\n", fmt.Sprintf("%s (runs: %d, tasks: %.0f%%, links: %.0f%%)",
+			fc.Name, fc.Runs, fc.TaskCoverage*100, fc.LinkCoverage*100))
+
+		for _, tc := range fc.Tasks {
+			fmt.Fprintf(&b, "    %q [label=%q, fillcolor=%q];\n", nodeID(i, tc.ID),
+				fmt.Sprintf("%s\\ndone: %d skipped: %d failed: %d", tc.ID, tc.Done, tc.Skipped, tc.Failed), taskColor(tc))
+		}
+
+		for _, lc := range fc.Links {
+			if lc.From == "" || lc.To == "" {
+				continue
+			}
+
+			label := fmt.Sprintf("true: %d false: %d", lc.True, lc.False)
+			if lc.Expr != "" {
+				label = lc.Expr + "\\n" + label
+			}
+
+			style := "solid"
+			if lc.coveredBranches() < lc.branches() {
+				style = "dashed"
+			}
+
+			fmt.Fprintf(&b, "    %q -> %q [label=%q, style=%s];\n", nodeID(i, lc.From), nodeID(i, lc.To), label, style)
+		}
+
+		b.WriteString("  }\n")
+	}
+
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteHTML writes the report as an HTML page
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, r)
+}
+
+func nodeID(flowIdx int, taskID string) string {
+	return fmt.Sprintf("%d_%s", flowIdx, taskID)
+}
+
+func taskColor(tc *TaskCoverage) string {
+	switch {
+	case tc.Done > 0:
+		return "palegreen"
+	case tc.Failed > 0:
+		return "salmon"
+	}
+	return "lightgrey"
+}
+
+var htmlTemplate = template.Must(template.New("coverage").Funcs(template.FuncMap{
+	"percent": func(ratio float64) string { return fmt.Sprintf("%.0f%%", ratio*100) },
+	"color":   taskColor,
+	"linkColor": func(lc *LinkCoverage) string {
+		if lc.coveredBranches() < lc.branches() {
+			return "salmon"
+		}
+		return "palegreen"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Flow Coverage</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+</style>
+</head>
+<body>
+<h1>Flow Coverage</h1>
+{{range .Flows}}
+<h2>{{.Name}}</h2>
+<p>Runs: {{.Runs}} &middot; Tasks: {{percent .TaskCoverage}} &middot; Links: {{percent .LinkCoverage}}</p>
+<table>
+<tr><th>Task</th><th>Done</th><th>Skipped</th><th>Failed</th></tr>
+{{range .Tasks}}<tr style="background-color: {{color .}}"><td>{{.ID}}{{if .ErrorHandler}} (error handler){{end}}</td><td>{{.Done}}</td><td>{{.Skipped}}</td><td>{{.Failed}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Link</th><th>Expression</th><th>True</th><th>False</th><th>Skipped</th></tr>
+{{range .Links}}<tr style="background-color: {{linkColor .}}"><td>{{.From}} &rarr; {{.To}}</td><td>{{.Expr}}</td><td>{{.True}}</td><td>{{.False}}</td><td>{{.Skipped}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,140 @@
+package tester
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/qingcloudhx/flow/instance"
+)
+
+// BreakpointsRequest describes a request to set the breakpoints of an instance
+type BreakpointsRequest struct {
+	Breakpoints []string `json:"breakpoints"`
+}
+
+// GetDebugState returns the debug state of a live instance (GET "/instances/:id/debug").
+//
+// To get the debug state, try this at a shell:
+// $ curl http://localhost:8080/instances/<id>/debug
+func (et *RestEngineTester) GetDebugState(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	h, ok := getInstanceHandle(w, p)
+	if !ok {
+		return
+	}
+
+	et.writeJSON(w, h.DebugState())
+}
+
+// SetBreakpoints replaces the breakpoints of a live instance (PUT "/instances/:id/debug/breakpoints").
+//
+// To set the breakpoints, try this at a shell:
+// $ curl -H "Content-Type: application/json" -X PUT -d '{"breakpoints":["log_1"]}' http://localhost:8080/instances/<id>/debug/breakpoints
+func (et *RestEngineTester) SetBreakpoints(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	h, ok := getInstanceHandle(w, p)
+	if !ok {
+		return
+	}
+
+	req := &BreakpointsRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.SetBreakpoints(req.Breakpoints)
+
+	et.writeJSON(w, h.DebugState())
+}
+
+// PauseInstance pauses a live instance before its next step (POST "/instances/:id/debug/pause").
+func (et *RestEngineTester) PauseInstance(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	h, ok := getInstanceHandle(w, p)
+	if !ok {
+		return
+	}
+
+	h.Pause()
+
+	et.writeJSON(w, h.Info())
+}
+
+// ContinueInstance continues a paused instance until its next breakpoint (POST "/instances/:id/debug/continue").
+func (et *RestEngineTester) ContinueInstance(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	h, ok := getInstanceHandle(w, p)
+	if !ok {
+		return
+	}
+
+	h.Resume()
+
+	et.writeJSON(w, h.Info())
+}
+
+// StepInstance steps over the next task of a paused instance (POST "/instances/:id/debug/step").
+func (et *RestEngineTester) StepInstance(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	h, ok := getInstanceHandle(w, p)
+	if !ok {
+		return
+	}
+
+	h.StepOver()
+
+	et.writeJSON(w, h.Info())
+}
+
+// InspectTask returns the inputs, outputs and working data of a task of a live instance
+// (GET "/instances/:id/debug/tasks/:taskId").
+func (et *RestEngineTester) InspectTask(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	h, ok := getInstanceHandle(w, p)
+	if !ok {
+		return
+	}
+
+	taskInspection, found := h.InspectTask(p.ByName("taskId"))
+	if !found {
+		http.Error(w, "task not active: "+p.ByName("taskId"), http.StatusNotFound)
+		return
+	}
+
+	et.writeJSON(w, taskInspection)
+}
+
+func getInstanceHandle(w http.ResponseWriter, p httprouter.Params) (*instance.Handle, bool) {
+
+	h, ok := instance.GetDefaultRegistry().Get(p.ByName("id"))
+	if !ok {
+		http.Error(w, instance.ErrInstanceNotFound.Error(), http.StatusNotFound)
+	}
+
+	return h, ok
+}
+
+func (et *RestEngineTester) writeJSON(w http.ResponseWriter, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		et.logger.Errorf("Unable to encode response: %v", err)
+	}
+}
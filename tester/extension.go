@@ -6,6 +6,7 @@ import (
 
 	"github.com/qingcloudhx/core/data/expression"
 	"github.com/qingcloudhx/core/support"
+	"github.com/qingcloudhx/core/support/log"
 	"github.com/qingcloudhx/flow/definition"
 	"github.com/qingcloudhx/flow/instance"
 	"github.com/qingcloudhx/flow/model"
@@ -17,6 +18,15 @@ const (
 	EnvEnabled       = "TESTER_ENABLED"
 	EnvSettingPort   = "TESTER_PORT"
 	EnvSettingSrHost = "TESTER_SR_SERVER"
+
+	// EnvSettingSrCodec selects the codec used to send snapshots and steps to the state recorder
+	// server (json or binary) and EnvSettingSrCompression the compression of the binary codec (gzip),
+	// binary snapshots aren't migrated and can't be restored by a version of the flow library with
+	// a different instance.StateVersion
+	EnvSettingSrCodec       = "TESTER_SR_CODEC"
+	EnvSettingSrCompression = "TESTER_SR_COMPRESSION"
+
+	EnvSettingMaxInstances = "TESTER_MAX_INSTANCES"
 )
 
 //ExtensionProvider is the extension provider for the flow action
@@ -33,7 +43,7 @@ func NewExtensionProvider() *TesterProvider {
 
 func (fp *TesterProvider) GetFlowProvider() definition.Provider {
 	if fp.flowProvider == nil {
-		fp.flowProvider = &flowsupport.BasicRemoteFlowProvider{}
+		fp.flowProvider = flowsupport.NewBasicRemoteFlowProvider()
 	}
 
 	return fp.flowProvider
@@ -50,7 +60,7 @@ func (fp *TesterProvider) GetDefaultFlowModel() *model.FlowModel {
 func (fp *TesterProvider) GetStateRecorder() instance.StateRecorder {
 
 	if fp.stateRecorder == nil {
-		config := &support.ServiceConfig{Enabled: true}
+		recorders := multiStateRecorder{fp.GetFlowTester().InstanceStore()}
 
 		server := os.Getenv(EnvSettingSrHost)
 
@@ -65,15 +75,22 @@ func (fp *TesterProvider) GetStateRecorder() instance.StateRecorder {
 			}
 
 			settings := map[string]string{
-				"host": host,
-				"port": port,
+				"host":        host,
+				"port":        port,
+				"codec":       os.Getenv(EnvSettingSrCodec),
+				"compression": os.Getenv(EnvSettingSrCompression),
+			}
+			config := &support.ServiceConfig{Enabled: true, Settings: settings}
+
+			remoteRecorder, err := instance.NewRemoteStateRecorder(config)
+			if err != nil {
+				log.RootLogger().Errorf("Remote state recorder disabled: %v", err)
+			} else {
+				recorders = append(recorders, remoteRecorder)
 			}
-			config.Settings = settings
-		} else {
-			config.Enabled = false
 		}
 
-		fp.stateRecorder = instance.NewRemoteStateRecorder(config)
+		fp.stateRecorder = recorders
 	}
 
 	return fp.stateRecorder
@@ -85,11 +102,16 @@ func (fp *TesterProvider) GetScriptExprFactory() expression.Factory {
 
 func (fp *TesterProvider) GetFlowTester() *RestEngineTester {
 
-	config := &support.ServiceConfig{Enabled: true}
+	if fp.flowTester == nil {
+		config := &support.ServiceConfig{Enabled: true}
 
-	settings := map[string]string{
-		"port": os.Getenv(EnvSettingPort),
+		settings := map[string]string{
+			"port":         os.Getenv(EnvSettingPort),
+			"maxInstances": os.Getenv(EnvSettingMaxInstances),
+		}
+		config.Settings = settings
+		fp.flowTester = NewRestEngineTester(config)
 	}
-	config.Settings = settings
-	return NewRestEngineTester(config)
+
+	return fp.flowTester
 }
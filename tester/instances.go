@@ -0,0 +1,142 @@
+package tester
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/qingcloudhx/flow/instance"
+)
+
+// InstanceResult describes the result of an instance
+type InstanceResult struct {
+	ID      string                 `json:"id"`
+	Status  int                    `json:"status"`
+	Done    bool                   `json:"done"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// GetInstance returns the status of an instance (GET "/instances/:id").
+//
+// To get the status of an instance, try this at a shell:
+// $ curl http://localhost:8080/instances/<id>
+func (et *RestEngineTester) GetInstance(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	record, ok := et.getInstanceRecord(w, p)
+	if !ok {
+		return
+	}
+
+	et.writeJSON(w, record)
+}
+
+// GetInstanceResult returns the outputs or the error of an instance (GET "/instances/:id/result"),
+// if the instance is still executing only its status is returned with a 202.
+//
+// To get the result of an instance, try this at a shell:
+// $ curl http://localhost:8080/instances/<id>/result
+func (et *RestEngineTester) GetInstanceResult(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	record, ok := et.getInstanceRecord(w, p)
+	if !ok {
+		return
+	}
+
+	result := &InstanceResult{ID: record.ID, Status: int(record.Status), Done: record.Done()}
+
+	if !result.Done {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		result.Outputs = record.Outputs
+		result.Error = record.Error
+	}
+
+	et.writeJSON(w, result)
+}
+
+// GetInstanceSteps returns the changes recorded for each step of an instance (GET "/instances/:id/steps").
+//
+// To get the steps of an instance, try this at a shell:
+// $ curl http://localhost:8080/instances/<id>/steps
+func (et *RestEngineTester) GetInstanceSteps(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	steps, ok := et.store.Steps(p.ByName("id"))
+	if !ok {
+		if _, live := instance.GetDefaultRegistry().Get(p.ByName("id")); !live {
+			http.Error(w, instance.ErrInstanceNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		steps = []*StepRecord{}
+	}
+
+	et.writeJSON(w, steps)
+}
+
+// ReplayInstance replays a completed instance and reports the first step where the replay diverges
+// from the recorded steps (POST "/instances/:id/replay"), the body can specify the flow to use.
+//
+// To replay an instance with a modified flow, try this at a shell:
+// $ curl -H "Content-Type: application/json" -X POST -d '{"flowUri":"res://flow:modified"}' http://localhost:8080/instances/<id>/replay
+func (et *RestEngineTester) ReplayInstance(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	req := &ReplayRequest{}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(req)
+		if err != nil && err != io.EOF {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	record, ok := et.store.Get(p.ByName("id"))
+	if !ok {
+		http.Error(w, instance.ErrInstanceNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	if !record.Done() {
+		http.Error(w, "instance has not completed", http.StatusConflict)
+		return
+	}
+
+	rec, _ := et.store.Recording(record.ID)
+
+	result, err := et.reqProcessor.ReplayInstance(rec, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	et.writeJSON(w, result)
+}
+
+// getInstanceRecord returns the recorded instance, falling back to the registry for
+// live instances that have not recorded a step yet
+func (et *RestEngineTester) getInstanceRecord(w http.ResponseWriter, p httprouter.Params) (*InstanceRecord, bool) {
+
+	id := p.ByName("id")
+
+	if record, ok := et.store.Get(id); ok {
+		return record, true
+	}
+
+	if h, ok := instance.GetDefaultRegistry().Get(id); ok {
+		info := h.Info()
+		return &InstanceRecord{ID: info.ID, FlowURI: info.FlowURI, FlowName: info.FlowName, Status: info.Status,
+			StepID: info.StepID, StartTime: info.StartTime}, true
+	}
+
+	http.Error(w, instance.ErrInstanceNotFound.Error(), http.StatusNotFound)
+	return nil, false
+}
@@ -0,0 +1,33 @@
+package tester
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/qingcloudhx/flow/support/metrics"
+)
+
+// GetMetrics returns the metrics of the flows executed by the tester (GET "/metrics"), the
+// 'format' query parameter selects prometheus (default) or json.
+//
+// To get a snapshot of the metrics as JSON, try this at a shell:
+// $ curl http://localhost:8080/metrics?format=json
+func (et *RestEngineTester) GetMetrics(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	collector := metrics.DefaultCollector()
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "prometheus":
+		collector.ServeHTTP(w, r)
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(collector.Snapshot()); err != nil {
+			et.logger.Errorf("Unable to write metrics: %v", err)
+		}
+	default:
+		http.Error(w, "unsupported metrics format: "+format, http.StatusBadRequest)
+	}
+}
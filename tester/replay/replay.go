@@ -0,0 +1,297 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/qingcloudhx/core/data"
+	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/instance"
+	"github.com/qingcloudhx/flow/model"
+	"github.com/qingcloudhx/flow/support"
+)
+
+// ErrSubFlows is returned when replaying a recording of an instance that executed sub-flows
+var ErrSubFlows = errors.New("replay of instances that execute sub-flows is not supported")
+
+var instanceCtr int64
+
+// Recording is the recorded execution of an instance of a flow
+type Recording struct {
+	FlowURI string                 `json:"flowUri"`
+	Inputs  map[string]interface{} `json:"inputs,omitempty"`
+	Steps   []*Step                `json:"steps"`
+}
+
+// Step contains the changes recorded for a step of the instance, as serialized
+// by the instance.InstanceChangeTracker
+type Step struct {
+	ID      int             `json:"id"`
+	Changes json.RawMessage `json:"changes"`
+}
+
+// Result is the outcome of the replay of a Recording
+type Result struct {
+	// Steps is the number of steps that were replayed
+	Steps  int              `json:"steps"`
+	Status model.FlowStatus `json:"status"`
+	// Divergence is the first step where the replay diverged from the recording
+	Divergence *Divergence `json:"divergence,omitempty"`
+}
+
+// Diverged indicates if the replay diverged from the recording
+func (r *Result) Diverged() bool {
+	return r.Divergence != nil
+}
+
+// Divergence describes the differences between a recorded and a replayed step
+type Divergence struct {
+	Step   int    `json:"step"`
+	Reason string `json:"reason"`
+	// Recorded are the recorded changes that were not replayed
+	Recorded []string `json:"recorded,omitempty"`
+	// Replayed are the replayed changes that were not recorded
+	Replayed []string `json:"replayed,omitempty"`
+}
+
+// Replay re-executes the recorded instance using the specified flow definition.  The
+// activities are not evaluated, each execution of a task produces the outputs or the
+// error recorded for it instead.  The replay stops at the first step whose task, link
+// or flow status changes differ from the ones of the recording.
+func Replay(def *definition.Definition, rec *Recording) (*Result, error) {
+
+	recorded := make([]*stepChanges, len(rec.Steps))
+	for i, step := range rec.Steps {
+		changes, err := parseChanges(step.Changes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid changes for step %d: %v", step.ID, err)
+		}
+		recorded[i] = changes
+	}
+
+	interceptor, err := newInterceptor(def, recorded)
+	if err != nil {
+		return nil, err
+	}
+
+	id := fmt.Sprintf("replay-%d", atomic.AddInt64(&instanceCtr, 1))
+
+	inst, err := instance.NewIndependentInstance(id, rec.FlowURI, def, log.ChildLogger(log.RootLogger(), "replay"))
+	if err != nil {
+		return nil, err
+	}
+
+	instance.ApplyExecOptions(inst, &instance.ExecOptions{Interceptor: interceptor})
+
+	inputs := make(map[string]interface{}, len(rec.Inputs))
+	for name, value := range rec.Inputs {
+		inputs[name] = value
+	}
+
+	inst.Start(inputs)
+
+	result := &Result{}
+
+	hasWork := true
+	for hasWork && inst.Status() < model.FlowStatusCompleted {
+
+		if result.Steps == len(recorded) {
+			result.Divergence = &Divergence{Step: inst.StepID() + 1, Reason: "the replay executes more steps than the recording"}
+			break
+		}
+
+		hasWork = inst.DoStep()
+
+		b, err := json.Marshal(inst.ChangeTracker)
+		if err != nil {
+			return nil, err
+		}
+
+		replayed, err := parseChanges(b)
+		if err != nil {
+			return nil, err
+		}
+
+		stepID := rec.Steps[result.Steps].ID
+		result.Steps++
+
+		if divergence := compare(recorded[result.Steps-1], replayed); divergence != nil {
+			divergence.Step = stepID
+			result.Divergence = divergence
+			break
+		}
+	}
+
+	if result.Divergence == nil && result.Steps < len(recorded) {
+		result.Divergence = &Divergence{Step: rec.Steps[result.Steps].ID, Reason: "the replay completed before the end of the recording"}
+	}
+
+	result.Status = inst.Status()
+
+	return result, nil
+}
+
+// newInterceptor creates the interceptor that replaces the executions of the tasks of the
+// definition by their recorded results, executions that were not recorded fail
+func newInterceptor(def *definition.Definition, recorded []*stepChanges) (*support.Interceptor, error) {
+
+	executions := make(map[string][]*support.InterceptorResult)
+
+	for _, changes := range recorded {
+		for _, ic := range changes.InstChanges {
+			if ic.FlowID != 0 || ic.SubFlow != nil {
+				return nil, ErrSubFlows
+			}
+
+			for _, tc := range ic.Tasks {
+				if tc.Task == nil {
+					continue
+				}
+
+				switch model.TaskStatus(tc.Task.Status) {
+				case model.TaskStatusDone:
+					result := &support.InterceptorResult{}
+					for name, value := range tc.Outputs {
+						result.Outputs = append(result.Outputs, data.NewAttribute(name, data.TypeAny, value))
+					}
+					executions[tc.ID] = append(executions[tc.ID], result)
+				case model.TaskStatusFailed:
+					err := &support.InterceptorError{Message: fmt.Sprintf("recorded failure of task '%s'", tc.ID)}
+					executions[tc.ID] = append(executions[tc.ID], &support.InterceptorResult{Error: err})
+				}
+			}
+		}
+	}
+
+	tasks := def.Tasks()
+	if eh := def.GetErrorHandler(); eh != nil {
+		tasks = append(tasks, eh.Tasks()...)
+	}
+
+	interceptor := &support.Interceptor{}
+
+	for _, task := range tasks {
+		if task.ActivityConfig() == nil {
+			continue
+		}
+
+		notRecorded := &support.InterceptorResult{Error: &support.InterceptorError{
+			Message: fmt.Sprintf("execution of task '%s' was not recorded", task.ID())}}
+
+		sequence := append(executions[task.ID()], notRecorded)
+		interceptor.TaskInterceptors = append(interceptor.TaskInterceptors, &support.TaskInterceptor{ID: task.ID(), Skip: true, Sequence: sequence})
+	}
+
+	return interceptor, nil
+}
+
+// stepChanges is the part of the serialized changes of a step used by the replay
+type stepChanges struct {
+	InstChanges []*instChange `json:"instChanges"`
+}
+
+type instChange struct {
+	FlowID  int              `json:"flowId"`
+	Status  model.FlowStatus `json:"status"`
+	Tasks   []*taskChange    `json:"tasks"`
+	Links   []*linkChange    `json:"links"`
+	SubFlow *json.RawMessage `json:"subFlow"`
+}
+
+type taskChange struct {
+	ChgType instance.ChgType `json:"ct"`
+	ID      string           `json:"id"`
+	Task    *struct {
+		Status int `json:"status"`
+	} `json:"task"`
+	Outputs map[string]interface{} `json:"outputs"`
+}
+
+type linkChange struct {
+	ChgType instance.ChgType `json:"ct"`
+	ID      int              `json:"id"`
+	Link    *struct {
+		Status int `json:"status"`
+	} `json:"link"`
+}
+
+func parseChanges(b []byte) (*stepChanges, error) {
+
+	changes := &stepChanges{}
+	if len(b) == 0 {
+		return changes, nil
+	}
+
+	err := json.Unmarshal(b, changes)
+	return changes, err
+}
+
+// describe returns a sorted description of each of the status changes of the step
+func (sc *stepChanges) describe() []string {
+
+	var descs []string
+
+	for _, ic := range sc.InstChanges {
+		if ic.Status != model.FlowStatusNotStarted {
+			descs = append(descs, fmt.Sprintf("flow %d status %d", ic.FlowID, ic.Status))
+		}
+
+		for _, tc := range ic.Tasks {
+			if tc.Task == nil {
+				descs = append(descs, fmt.Sprintf("task '%s' removed", tc.ID))
+			} else if tc.ChgType == instance.CtDel {
+				descs = append(descs, fmt.Sprintf("task '%s' removed, status %d", tc.ID, tc.Task.Status))
+			} else {
+				descs = append(descs, fmt.Sprintf("task '%s' status %d", tc.ID, tc.Task.Status))
+			}
+		}
+
+		for _, lc := range ic.Links {
+			if lc.ChgType == instance.CtDel || lc.Link == nil {
+				descs = append(descs, fmt.Sprintf("link %d removed", lc.ID))
+			} else {
+				descs = append(descs, fmt.Sprintf("link %d status %d", lc.ID, lc.Link.Status))
+			}
+		}
+	}
+
+	sort.Strings(descs)
+	return descs
+}
+
+// compare compares the recorded and the replayed changes of a step
+func compare(recorded, replayed *stepChanges) *Divergence {
+
+	missing := difference(recorded.describe(), replayed.describe())
+	unexpected := difference(replayed.describe(), recorded.describe())
+
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return nil
+	}
+
+	return &Divergence{Reason: "the changes of the step differ from the recording", Recorded: missing, Replayed: unexpected}
+}
+
+// difference returns the elements of a that are not in b
+func difference(a, b []string) []string {
+
+	in := make(map[string]int, len(b))
+	for _, s := range b {
+		in[s]++
+	}
+
+	var diff []string
+	for _, s := range a {
+		if in[s] > 0 {
+			in[s]--
+			continue
+		}
+		diff = append(diff, s)
+	}
+
+	return diff
+}
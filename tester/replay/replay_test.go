@@ -0,0 +1,121 @@
+package replay
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/qingcloudhx/core/data/expression"
+	_ "github.com/qingcloudhx/core/data/expression/script"
+	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/instance"
+	"github.com/qingcloudhx/flow/model"
+	_ "github.com/qingcloudhx/flow/support/test"
+	"github.com/stretchr/testify/assert"
+)
+
+const replayDefJSON = `
+{
+  "name": "replay-flow",
+  "model": "test",
+  "metadata": {
+    "input": [ { "name": "in", "type": "string" } ],
+    "output": [ { "name": "out", "type": "any" } ]
+  },
+  "tasks": [
+    { "id": "echo1", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "=$flow.in" }, "output": { "out": "=$.message" } } },
+    { "id": "echo2", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "a" } } },
+    { "id": "echo3", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "b" } } }
+  ],
+  "links": [
+    { "from": "echo1", "to": "echo2", "type": "expression", "value": "$flow.out == 'a'" },
+    { "from": "echo1", "to": "echo3", "type": "expression", "value": "$flow.out != 'a'" }
+  ]
+}
+`
+
+func init() {
+	definition.SetExprFactory(expression.NewFactory(definition.GetDataResolver()))
+}
+
+func newReplayDefinition(t *testing.T, replacer *strings.Replacer) *definition.Definition {
+
+	defJSON := replayDefJSON
+	if replacer != nil {
+		defJSON = replacer.Replace(defJSON)
+	}
+
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(defJSON), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	return def
+}
+
+// record executes the flow and records the changes of each of its steps
+func record(t *testing.T, def *definition.Definition, inputs map[string]interface{}) *Recording {
+
+	inst, err := instance.NewIndependentInstance("recorded", "res://flow:replay", def, log.RootLogger())
+	assert.Nil(t, err)
+
+	rec := &Recording{FlowURI: "res://flow:replay", Inputs: inputs}
+
+	inst.Start(map[string]interface{}{"in": inputs["in"]})
+
+	hasWork := true
+	for hasWork && inst.Status() < model.FlowStatusCompleted {
+		hasWork = inst.DoStep()
+
+		changes, err := json.Marshal(inst.ChangeTracker)
+		assert.Nil(t, err)
+		rec.Steps = append(rec.Steps, &Step{ID: inst.StepID(), Changes: changes})
+	}
+
+	assert.Equal(t, model.FlowStatusCompleted, inst.Status())
+
+	return rec
+}
+
+func TestReplay_Identical(t *testing.T) {
+
+	def := newReplayDefinition(t, nil)
+	rec := record(t, def, map[string]interface{}{"in": "a"})
+
+	result, err := Replay(newReplayDefinition(t, nil), rec)
+	assert.Nil(t, err)
+	assert.False(t, result.Diverged())
+	assert.Equal(t, len(rec.Steps), result.Steps)
+	assert.Equal(t, model.FlowStatusCompleted, result.Status)
+}
+
+func TestReplay_Diverged(t *testing.T) {
+
+	rec := record(t, newReplayDefinition(t, nil), map[string]interface{}{"in": "a"})
+
+	modified := newReplayDefinition(t, strings.NewReplacer("$flow.out == 'a'", "$flow.out == 'b'", "$flow.out != 'a'", "$flow.out != 'b'"))
+
+	result, err := Replay(modified, rec)
+	assert.Nil(t, err)
+	assert.True(t, result.Diverged())
+	assert.Equal(t, rec.Steps[0].ID, result.Divergence.Step)
+	assert.Contains(t, result.Divergence.Recorded, "task 'echo2' status 20")
+	assert.Contains(t, result.Divergence.Replayed, "task 'echo3' status 20")
+}
+
+func TestReplay_RecordedOutputs(t *testing.T) {
+
+	rec := record(t, newReplayDefinition(t, nil), map[string]interface{}{"in": "a"})
+
+	// the replay uses the recorded outputs of echo1 rather than evaluating it
+	assert.Contains(t, string(rec.Steps[0].Changes), `"outputs":{"message":"a"}`)
+	rec.Steps[0].Changes = json.RawMessage(strings.Replace(string(rec.Steps[0].Changes), `"outputs":{"message":"a"}`, `"outputs":{"message":"b"}`, -1))
+
+	result, err := Replay(newReplayDefinition(t, nil), rec)
+	assert.Nil(t, err)
+	assert.True(t, result.Diverged())
+	assert.Equal(t, rec.Steps[0].ID, result.Divergence.Step)
+}
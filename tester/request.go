@@ -2,23 +2,36 @@ package tester
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/qingcloudhx/core/action"
 	"github.com/qingcloudhx/core/engine/runner"
 	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/definition"
 	"github.com/qingcloudhx/flow/instance"
+	"github.com/qingcloudhx/flow/model"
 	"github.com/qingcloudhx/flow/support"
+	"github.com/qingcloudhx/flow/tester/coverage"
+	"github.com/qingcloudhx/flow/tester/replay"
 )
 
 const (
 	RefFlow = "github.com/qingcloudhx/flow"
+
+	// DefaultRunTimeout is the time a RunRequest waits for the instance to complete
+	DefaultRunTimeout = time.Minute
 )
 
 // RequestProcessor processes request objects and invokes the corresponding
 // flow Manager methods
 type RequestProcessor struct {
-	runner action.Runner
-	logger log.Logger
+	runner   action.Runner
+	store    *InstanceStore
+	coverage *coverage.Collector
+	logger   log.Logger
 }
 
 // NewRequestProcessor creates a new RequestProcessor
@@ -40,14 +53,34 @@ func (rp *RequestProcessor) StartFlow(startRequest *StartRequest) (results map[s
 
 	logger.Debugf("Tester starting flow")
 
-	factory := action.GetFactory(RefFlow)
-	act, _ := factory.New(&action.Config{})
+	act, err := newFlowAction(startRequest.FlowURI)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := rp.startInputs(startRequest)
+
+	execOptions := &instance.ExecOptions{Interceptor: startRequest.Interceptor, Patch: startRequest.Patch, Debug: startRequest.Debug,
+		Faults: startRequest.Faults, Listener: rp.execListener()}
+	ro := &instance.RunOptions{Op: instance.OpStart, ReturnID: true, FlowURI: startRequest.FlowURI, ExecOptions: execOptions}
+	//attr, _ := data.NewAttribute("_run_options", data.TypeAny, ro)
+	inputs["_run_options"] = ro
+
+	return rp.startAction(act, inputs)
+}
+
+// startInputs creates the flow inputs of a StartRequest
+func (rp *RequestProcessor) startInputs(startRequest *StartRequest) map[string]interface{} {
+
+	logger := rp.logger
 
 	var inputs map[string]interface{}
 
 	if len(startRequest.Attrs) > 0 {
 
-		logger.Debugf("Starting with flow attrs: %#v", startRequest.Attrs)
+		if logger.DebugEnabled() {
+			logger.Debugf("Starting with flow attrs: %#v", maskFlowValues(nil, startRequest.FlowURI, startRequest.Attrs))
+		}
 
 		inputs = make(map[string]interface{}, len(startRequest.Attrs)+1)
 		for name, value := range startRequest.Attrs {
@@ -55,7 +88,9 @@ func (rp *RequestProcessor) StartFlow(startRequest *StartRequest) (results map[s
 		}
 	} else if len(startRequest.Data) > 0 {
 
-		logger.Debugf("Starting with flow attrs: %#v", startRequest.Data)
+		if logger.DebugEnabled() {
+			logger.Debugf("Starting with flow attrs: %#v", maskFlowValues(nil, startRequest.FlowURI, startRequest.Data))
+		}
 
 		inputs = make(map[string]interface{}, len(startRequest.Data)+1)
 
@@ -71,12 +106,114 @@ func (rp *RequestProcessor) StartFlow(startRequest *StartRequest) (results map[s
 		inputs = make(map[string]interface{}, 1)
 	}
 
-	execOptions := &instance.ExecOptions{Interceptor: startRequest.Interceptor, Patch: startRequest.Patch}
-	ro := &instance.RunOptions{Op: instance.OpStart, ReturnID: true, FlowURI: startRequest.FlowURI, ExecOptions: execOptions}
-	//attr, _ := data.NewAttribute("_run_options", data.TypeAny, ro)
+	return inputs
+}
+
+// RunFlow handles a RunRequest for a FlowInstance.  This will start the FlowInstance
+// and wait for it to complete or for the timeout to expire.
+func (rp *RequestProcessor) RunFlow(runRequest *RunRequest) (*RunResult, error) {
+
+	logger := rp.logger
+
+	logger.Debugf("Tester running flow")
+
+	timeout := DefaultRunTimeout
+	if runRequest.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(runRequest.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout '%s': %v", runRequest.Timeout, err)
+		}
+	}
+
+	act, err := newFlowAction(runRequest.FlowURI)
+	if err != nil {
+		return nil, err
+	}
+
+	asyncAct, ok := act.(action.AsyncAction)
+	if !ok {
+		return nil, errors.New("flow action is not asynchronous")
+	}
+
+	inputs := rp.startInputs(&runRequest.StartRequest)
+
+	listener := &runListener{listener: rp.execListener()}
+
+	execOptions := &instance.ExecOptions{Interceptor: runRequest.Interceptor, Patch: runRequest.Patch, Debug: runRequest.Debug,
+		Faults: runRequest.Faults, Listener: listener}
+	ro := &instance.RunOptions{Op: instance.OpStart, ReturnID: true, FlowURI: runRequest.FlowURI, ExecOptions: execOptions}
 	inputs["_run_options"] = ro
 
-	return rp.runner.RunAction(context.Background(), act, inputs)
+	handler := newStartResultHandler()
+
+	err = asyncAct.Run(context.Background(), inputs, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := handler.FirstResult()
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := results["id"].(string)
+	result := &RunResult{ID: id}
+
+	if runRequest.Faults != nil {
+		result.FaultSeed = runRequest.Faults.Seed
+	}
+
+	last, done := handler.Wait(timeout)
+
+	if !done {
+		if h, ok := instance.GetDefaultRegistry().Get(id); ok {
+			result.Status = h.Info().Status
+		}
+	} else {
+		result.Done = true
+
+		// the final state is taken from the instance, the results of the action don't
+		// distinguish a cancelled instance or one stopped by the step limit
+		inst := listener.instance()
+		if inst != nil {
+			result.Status = inst.Status()
+		} else if rp.store != nil {
+			if record, ok := rp.store.Get(id); ok {
+				result.Status = record.Status
+			}
+		}
+
+		switch {
+		case inst == nil:
+			// the instance didn't execute any task, use its last results
+			if last != nil && last.err != nil {
+				result.Status = model.FlowStatusFailed
+				result.Error = last.err.Error()
+			}
+		case result.Status == model.FlowStatusCompleted:
+			outputs, err := inst.GetReturnData()
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Outputs = inst.FlowDefinition().MaskValues(outputs)
+			}
+		case result.Status == model.FlowStatusFailed:
+			if err := inst.GetError(); err != nil {
+				result.Error = err.Error()
+			}
+		case result.Status == model.FlowStatusCancelled:
+			result.Error = fmt.Sprintf("flow instance [%s] cancelled", id)
+		default:
+			result.Error = fmt.Sprintf("flow instance [%s] stopped before completing, the step limit may have been reached", id)
+		}
+	}
+
+	if rp.store != nil && runRequest.Trace {
+		result.Steps, _ = rp.store.Steps(id)
+	}
+
+	return result, nil
 }
 
 // RestartFlow handles a RestartRequest for a FlowInstance.  This will
@@ -87,14 +224,19 @@ func (rp *RequestProcessor) RestartFlow(restartRequest *RestartRequest) (results
 
 	logger.Debugf("Tester restarting flow")
 
-	factory := action.GetFactory(RefFlow)
-	act, _ := factory.New(&action.Config{})
+	act, err := newFlowAction(restartRequest.InitialState.FlowURI())
+	if err != nil {
+		return nil, err
+	}
 
 	inputs := make(map[string]interface{}, len(restartRequest.Data)+1)
 
 	if restartRequest.Data != nil {
 
-		logger.Debugf("Updating flow attrs: %v", restartRequest.Data)
+		if logger.DebugEnabled() {
+			state := restartRequest.InitialState
+			logger.Debugf("Updating flow attrs: %v", maskFlowValues(state.FlowDefinition(), state.FlowURI(), restartRequest.Data))
+		}
 
 		for k, v := range restartRequest.Data {
 			//attr, _ := data.NewAttribute(k, data.TypeAny, v)
@@ -102,12 +244,17 @@ func (rp *RequestProcessor) RestartFlow(restartRequest *RestartRequest) (results
 		}
 	}
 
-	execOptions := &instance.ExecOptions{Interceptor: restartRequest.Interceptor, Patch: restartRequest.Patch}
+	execOptions := &instance.ExecOptions{Interceptor: restartRequest.Interceptor, Patch: restartRequest.Patch, Listener: rp.execListener()}
 	ro := &instance.RunOptions{Op: instance.OpRestart, ReturnID: true, FlowURI: restartRequest.InitialState.FlowURI(), InitialState: restartRequest.InitialState, ExecOptions: execOptions}
+
+	if restartRequest.FromTask != "" {
+		ro.RestartFrom = &instance.RestartFrom{TaskID: restartRequest.FromTask, Inputs: restartRequest.TaskInputs}
+	}
+
 	//attr, _ := data.NewAttribute("_run_options", data.TypeAny, ro)
 	inputs["_run_options"] = ro
 
-	return rp.runner.RunAction(context.Background(), act, inputs)
+	return rp.startAction(act, inputs)
 }
 
 // ResumeFlow handles a ResumeRequest for a FlowInstance.  This will
@@ -118,14 +265,19 @@ func (rp *RequestProcessor) ResumeFlow(resumeRequest *ResumeRequest) (results ma
 
 	logger.Debugf("Tester resuming flow")
 
-	factory := action.GetFactory(RefFlow)
-	act, _ := factory.New(&action.Config{})
+	act, err := newFlowAction(resumeRequest.State.FlowURI())
+	if err != nil {
+		return nil, err
+	}
 
 	inputs := make(map[string]interface{}, len(resumeRequest.Data)+1)
 
 	if resumeRequest.Data != nil {
 
-		logger.Debugf("Updating flow attrs: %v", resumeRequest.Data)
+		if logger.DebugEnabled() {
+			state := resumeRequest.State
+			logger.Debugf("Updating flow attrs: %v", maskFlowValues(state.FlowDefinition(), state.FlowURI(), resumeRequest.Data))
+		}
 
 		for k, v := range resumeRequest.Data {
 			//attr, _ := data.NewAttribute(k, data.TypeAny, v)
@@ -133,22 +285,266 @@ func (rp *RequestProcessor) ResumeFlow(resumeRequest *ResumeRequest) (results ma
 		}
 	}
 
-	execOptions := &instance.ExecOptions{Interceptor: resumeRequest.Interceptor, Patch: resumeRequest.Patch}
+	execOptions := &instance.ExecOptions{Interceptor: resumeRequest.Interceptor, Patch: resumeRequest.Patch, Listener: rp.execListener()}
 	ro := &instance.RunOptions{Op: instance.OpResume, ReturnID: true, FlowURI: resumeRequest.State.FlowURI(), InitialState: resumeRequest.State, ExecOptions: execOptions}
 	//attr, _ := data.NewAttribute("_run_options", data.TypeAny, ro)
 	//inputs[attr.Name()] = attr
 	inputs["_run_options"] = ro
-	return rp.runner.RunAction(context.Background(), act, inputs)
+	return rp.startAction(act, inputs)
+}
+
+// ReplayInstance handles a ReplayRequest for a recorded FlowInstance.  The instance is
+// re-executed with the requested flow definition using the recorded activity outputs.
+func (rp *RequestProcessor) ReplayInstance(rec *replay.Recording, replayRequest *ReplayRequest) (*replay.Result, error) {
+
+	logger := rp.logger
+
+	var def *definition.Definition
+	var err error
+
+	if replayRequest.Flow != nil {
+		def, err = definition.NewDefinition(replayRequest.Flow)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		flowURI := replayRequest.FlowURI
+		if flowURI == "" {
+			flowURI = rec.FlowURI
+		}
+
+		def, _, err = support.GetDefinition(flowURI)
+		if err != nil {
+			return nil, err
+		}
+
+		if def == nil {
+			return nil, errors.New("flow not found for URI: " + flowURI)
+		}
+	}
+
+	logger.Debugf("Tester replaying instance of flow '%s' using flow '%s'", rec.FlowURI, def.Name())
+
+	return replay.Replay(def, rec)
+}
+
+// execListener returns the listener of the instances started by the processor
+func (rp *RequestProcessor) execListener() instance.ExecListener {
+	if rp.coverage == nil {
+		return nil
+	}
+
+	return rp.coverage
+}
+
+// startAction runs the action and returns as soon as its first results are available,
+// the action continues to execute in the background
+func (rp *RequestProcessor) startAction(act action.Action, inputs map[string]interface{}) (map[string]interface{}, error) {
+
+	asyncAct, ok := act.(action.AsyncAction)
+	if !ok {
+		return rp.runner.RunAction(context.Background(), act, inputs)
+	}
+
+	handler := newStartResultHandler()
+
+	err := asyncAct.Run(context.Background(), inputs, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	return handler.FirstResult()
+}
+
+// maskFlowValues masks the values of the sensitive inputs and outputs of the flow, the
+// definition is only resolved if it isn't provided and all the values are masked if it
+// can't be resolved
+func maskFlowValues(def *definition.Definition, flowURI string, values map[string]interface{}) map[string]interface{} {
+
+	if def == nil {
+		def, _, _ = support.GetDefinition(flowURI)
+	}
+
+	if def == nil {
+		masked := make(map[string]interface{}, len(values))
+		for name := range values {
+			masked[name] = definition.MaskedValue
+		}
+		return masked
+	}
+
+	return def.MaskValues(values)
+}
+
+func newFlowAction(flowURI string) (action.Action, error) {
+
+	factory := action.GetFactory(RefFlow)
+	if factory == nil {
+		return nil, errors.New("flow action not registered")
+	}
+
+	return factory.New(&action.Config{Settings: map[string]interface{}{"flowURI": flowURI}})
+}
+
+// startResultHandler is an action.ResultHandler that makes the first results of an action
+// available while it continues to execute
+type startResultHandler struct {
+	first chan *actionResult
+	done  chan struct{}
+	once  sync.Once
+
+	mu   sync.Mutex
+	last *actionResult
+}
+
+type actionResult struct {
+	results map[string]interface{}
+	err     error
+}
+
+func newStartResultHandler() *startResultHandler {
+	return &startResultHandler{first: make(chan *actionResult, 1), done: make(chan struct{})}
+}
+
+// HandleResult implements action.ResultHandler.HandleResult
+func (rh *startResultHandler) HandleResult(results map[string]interface{}, err error) {
+	r := &actionResult{results: results, err: err}
+
+	rh.mu.Lock()
+	rh.last = r
+	rh.mu.Unlock()
+
+	select {
+	case rh.first <- r:
+	default:
+	}
+}
+
+// Done implements action.ResultHandler.Done
+func (rh *startResultHandler) Done() {
+	rh.once.Do(func() { close(rh.done) })
+}
+
+// FirstResult waits for the first results of the action, nil results are returned if
+// the action completed without any
+func (rh *startResultHandler) FirstResult() (map[string]interface{}, error) {
+	select {
+	case r := <-rh.first:
+		return r.results, r.err
+	case <-rh.done:
+		select {
+		case r := <-rh.first:
+			return r.results, r.err
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// Wait waits for the action to be done or for the timeout to expire, it returns the
+// last results of the action and whether it is done
+func (rh *startResultHandler) Wait(timeout time.Duration) (*actionResult, bool) {
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-rh.done:
+		rh.mu.Lock()
+		defer rh.mu.Unlock()
+		return rh.last, true
+	case <-timer.C:
+		return nil, false
+	}
+}
+
+// runListener keeps the instance of a RunRequest and forwards the notifications to the
+// listener of the processor
+type runListener struct {
+	listener instance.ExecListener
+
+	mu   sync.Mutex
+	inst *instance.IndependentInstance
+}
+
+// TaskStatusChanged implements instance.ExecListener.TaskStatusChanged
+func (l *runListener) TaskStatusChanged(inst *instance.IndependentInstance, taskInst *instance.TaskInst) {
+	l.setInstance(inst)
+	if l.listener != nil {
+		l.listener.TaskStatusChanged(inst, taskInst)
+	}
+}
+
+// LinkStatusChanged implements instance.ExecListener.LinkStatusChanged
+func (l *runListener) LinkStatusChanged(inst *instance.IndependentInstance, linkInst *instance.LinkInst) {
+	l.setInstance(inst)
+	if l.listener != nil {
+		l.listener.LinkStatusChanged(inst, linkInst)
+	}
+}
+
+// InstanceDone implements instance.DoneListener.InstanceDone
+func (l *runListener) InstanceDone(inst *instance.IndependentInstance) {
+	if listener, ok := l.listener.(instance.DoneListener); ok {
+		listener.InstanceDone(inst)
+	}
+}
+
+func (l *runListener) setInstance(inst *instance.IndependentInstance) {
+	l.mu.Lock()
+	if l.inst == nil {
+		l.inst = inst
+	}
+	l.mu.Unlock()
+}
+
+func (l *runListener) instance() *instance.IndependentInstance {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inst
 }
 
 // StartRequest describes a request for starting a FlowInstance
 type StartRequest struct {
-	FlowURI     string                 `json:"flowUri"`
-	Data        map[string]interface{} `json:"data"`
-	Attrs       map[string]interface{} `json:"attrs"`
-	Interceptor *support.Interceptor   `json:"interceptor"`
-	Patch       *support.Patch         `json:"patch"`
-	ReplyTo     string                 `json:"replyTo"`
+	FlowURI     string                  `json:"flowUri"`
+	Data        map[string]interface{}  `json:"data"`
+	Attrs       map[string]interface{}  `json:"attrs"`
+	Interceptor *support.Interceptor    `json:"interceptor"`
+	Patch       *support.Patch          `json:"patch"`
+	ReplyTo     string                  `json:"replyTo"`
+	Debug       *instance.DebugOptions  `json:"debug"`
+	Faults      *support.FaultInjection `json:"faults"`
+}
+
+// RunRequest describes a request for running a FlowInstance to completion
+type RunRequest struct {
+	StartRequest
+	// Timeout is the maximum time to wait for the instance to complete (ex. "30s")
+	Timeout string `json:"timeout"`
+	// Trace includes the changes of each step in the result
+	Trace bool `json:"trace"`
+}
+
+// RunResult describes the outcome of a RunRequest
+type RunResult struct {
+	ID      string                 `json:"id"`
+	Status  model.FlowStatus       `json:"status"`
+	Done    bool                   `json:"done"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+	Steps   []*StepRecord          `json:"steps,omitempty"`
+
+	// FaultSeed is the seed used to inject the faults of the request
+	FaultSeed int64 `json:"faultSeed,omitempty"`
+}
+
+// ReplayRequest describes a request for replaying a recorded FlowInstance, by default
+// the instance is replayed with the current definition of its flow
+type ReplayRequest struct {
+	// FlowURI is the flow used to replay the instance
+	FlowURI string `json:"flowUri,omitempty"`
+	// Flow is the definition used to replay the instance, it takes precedence over FlowURI
+	Flow *definition.DefinitionRep `json:"flow,omitempty"`
 }
 
 // RestartRequest describes a request for restarting a FlowInstance
@@ -158,6 +554,11 @@ type RestartRequest struct {
 	Data         map[string]interface{}        `json:"data"`
 	Interceptor  *support.Interceptor          `json:"interceptor"`
 	Patch        *support.Patch                `json:"patch"`
+
+	// FromTask restarts the instance from the specified task instead of its saved state
+	FromTask string `json:"fromTask,omitempty"`
+	// TaskInputs override the inputs of the task the instance is restarted from
+	TaskInputs map[string]interface{} `json:"taskInputs,omitempty"`
 }
 
 // ResumeRequest describes a request for resuming a FlowInstance
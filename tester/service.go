@@ -3,17 +3,21 @@ package tester
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/qingcloudhx/core/support"
 	"github.com/qingcloudhx/core/support/log"
 	"github.com/qingcloudhx/flow/instance"
 	"github.com/qingcloudhx/flow/service"
+	"github.com/qingcloudhx/flow/tester/coverage"
 )
 
 // RestEngineTester is default REST implementation of the EngineTester
 type RestEngineTester struct {
 	reqProcessor *RequestProcessor
+	store        *InstanceStore
+	coverage     *coverage.Collector
 	server       *Server
 	enabled      bool
 	logger       log.Logger
@@ -24,6 +28,8 @@ func NewRestEngineTester(config *support.ServiceConfig) *RestEngineTester {
 	et := &RestEngineTester{enabled: config.Enabled}
 	et.init(config.Settings)
 	et.reqProcessor = NewRequestProcessor()
+	et.reqProcessor.store = et.store
+	et.reqProcessor.coverage = et.coverage
 
 	//todo what logger should this use?
 	et.logger = log.RootLogger()
@@ -31,6 +37,11 @@ func NewRestEngineTester(config *support.ServiceConfig) *RestEngineTester {
 	return et
 }
 
+// InstanceStore returns the store that records the instances executed by the tester
+func (et *RestEngineTester) InstanceStore() *InstanceStore {
+	return et.store
+}
+
 func (et *RestEngineTester) Name() string {
 	return service.ServiceEngineTester
 }
@@ -56,6 +67,9 @@ func (et *RestEngineTester) init(settings map[string]string) {
 	router.OPTIONS("/flow/start", handleOption)
 	router.POST("/flow/start", et.StartFlow)
 
+	router.OPTIONS("/flow/run", handleOption)
+	router.POST("/flow/run", et.RunFlow)
+
 	router.OPTIONS("/flow/restart", handleOption)
 	router.POST("/flow/restart", et.RestartFlow)
 
@@ -65,6 +79,33 @@ func (et *RestEngineTester) init(settings map[string]string) {
 	router.OPTIONS("/status", handleOption)
 	router.GET("/status", et.Status)
 
+	router.GET("/coverage", et.GetCoverage)
+	router.OPTIONS("/coverage", handleOption)
+	router.DELETE("/coverage", et.ResetCoverage)
+
+	router.GET("/metrics", et.GetMetrics)
+
+	router.GET("/instances/:id", et.GetInstance)
+	router.GET("/instances/:id/result", et.GetInstanceResult)
+	router.GET("/instances/:id/steps", et.GetInstanceSteps)
+	router.OPTIONS("/instances/:id/replay", handleOption)
+	router.POST("/instances/:id/replay", et.ReplayInstance)
+
+	router.GET("/instances/:id/debug", et.GetDebugState)
+	router.OPTIONS("/instances/:id/debug/breakpoints", handleOption)
+	router.PUT("/instances/:id/debug/breakpoints", et.SetBreakpoints)
+	router.OPTIONS("/instances/:id/debug/pause", handleOption)
+	router.POST("/instances/:id/debug/pause", et.PauseInstance)
+	router.OPTIONS("/instances/:id/debug/continue", handleOption)
+	router.POST("/instances/:id/debug/continue", et.ContinueInstance)
+	router.OPTIONS("/instances/:id/debug/step", handleOption)
+	router.POST("/instances/:id/debug/step", et.StepInstance)
+	router.GET("/instances/:id/debug/tasks/:taskId", et.InspectTask)
+
+	maxInstances, _ := strconv.Atoi(settings["maxInstances"])
+	et.store = NewInstanceStore(maxInstances)
+	et.coverage = coverage.NewCollector()
+
 	addr := ":" + settings["port"]
 	et.server = NewServer(addr, router)
 }
@@ -128,6 +169,37 @@ func (et *RestEngineTester) StartFlow(w http.ResponseWriter, r *http.Request, _
 	}
 }
 
+// RunFlow runs a Flow Instance to completion (POST "/flow/run"), the response contains the
+// outputs, final status and error of the instance.  If the timeout expires first, the
+// response only contains the current status of the instance.
+//
+// To post a run flow, try this at a shell:
+// $ curl -H "Content-Type: application/json" -X POST -d '{"flowUri":"base","timeout":"30s","trace":true}' http://localhost:8080/flow/run
+func (et *RestEngineTester) RunFlow(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	logger := et.logger
+
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+
+	req := &RunRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := et.reqProcessor.RunFlow(req)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger.Debugf("Ran Instance [ID:%s] for %s - Status: %d", result.ID, req.FlowURI, result.Status)
+
+	et.writeJSON(w, result)
+}
+
 // RestartFlow restarts a Flow Instance (POST "/flow/restart").
 //
 // To post a restart flow, try this at a shell:
@@ -0,0 +1,219 @@
+package tester
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/qingcloudhx/flow/instance"
+	"github.com/qingcloudhx/flow/model"
+	"github.com/qingcloudhx/flow/tester/replay"
+)
+
+const defaultMaxInstances = 100
+
+// InstanceRecord is the recorded history of an instance
+type InstanceRecord struct {
+	ID        string           `json:"id"`
+	FlowURI   string           `json:"flowUri"`
+	FlowName  string           `json:"flowName"`
+	Status    model.FlowStatus `json:"status"`
+	StepID    int              `json:"stepId"`
+	StartTime time.Time        `json:"startTime"`
+	EndTime   *time.Time       `json:"endTime,omitempty"`
+
+	// Inputs and Outputs have the sensitive values masked
+	Inputs  map[string]interface{} `json:"inputs,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+
+	Steps []*StepRecord `json:"-"`
+
+	// inputs are the clear inputs used to replay the instance
+	inputs map[string]interface{}
+}
+
+// Done indicates if the instance has finished executing
+func (r *InstanceRecord) Done() bool {
+	return r.Status >= model.FlowStatusCompleted
+}
+
+// StepRecord contains the changes of a step of an instance, Changes has the sensitive values
+// masked
+type StepRecord struct {
+	ID      int              `json:"id"`
+	Status  model.FlowStatus `json:"status"`
+	Changes json.RawMessage  `json:"changes"`
+
+	// changes are the clear changes used to replay the instance
+	changes json.RawMessage
+}
+
+// InstanceStore is an in-memory instance.StateRecorder that keeps the status, step
+// changes and results of the most recent instances
+type InstanceStore struct {
+	mu           sync.RWMutex
+	maxInstances int
+	records      map[string]*InstanceRecord
+	order        []string
+}
+
+// NewInstanceStore creates a new InstanceStore that retains at most maxInstances
+func NewInstanceStore(maxInstances int) *InstanceStore {
+
+	if maxInstances <= 0 {
+		maxInstances = defaultMaxInstances
+	}
+
+	return &InstanceStore{maxInstances: maxInstances, records: make(map[string]*InstanceRecord)}
+}
+
+// RecordSnapshot implements instance.StateRecorder.RecordSnapshot
+func (s *InstanceStore) RecordSnapshot(inst *instance.IndependentInstance) {
+
+	s.mu.Lock()
+	s.update(inst)
+	s.mu.Unlock()
+}
+
+// RecordStep implements instance.StateRecorder.RecordStep
+func (s *InstanceStore) RecordStep(inst *instance.IndependentInstance) {
+
+	changes, err := json.Marshal(inst.ChangeTracker)
+	if err != nil {
+		changes = nil
+	}
+
+	masked, err := inst.MaskedChanges()
+	if err != nil {
+		masked = nil
+	}
+
+	s.mu.Lock()
+	record := s.update(inst)
+	record.Steps = append(record.Steps, &StepRecord{ID: inst.StepID(), Status: inst.Status(), Changes: masked, changes: changes})
+	s.mu.Unlock()
+}
+
+// Get returns a copy of the record of the specified instance
+func (s *InstanceStore) Get(id string) (*InstanceRecord, bool) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return nil, false
+	}
+
+	cp := *record
+	return &cp, true
+}
+
+// Steps returns the recorded steps of the specified instance
+func (s *InstanceStore) Steps(id string) ([]*StepRecord, bool) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return nil, false
+	}
+
+	steps := make([]*StepRecord, len(record.Steps))
+	copy(steps, record.Steps)
+
+	return steps, true
+}
+
+// Recording returns the recording of the specified instance, which can be replayed
+func (s *InstanceStore) Recording(id string) (*replay.Recording, bool) {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return nil, false
+	}
+
+	rec := &replay.Recording{FlowURI: record.FlowURI, Inputs: record.inputs, Steps: make([]*replay.Step, len(record.Steps))}
+	for i, step := range record.Steps {
+		rec.Steps[i] = &replay.Step{ID: step.ID, Changes: step.changes}
+	}
+
+	return rec, true
+}
+
+// update creates or updates the record of the instance, s.mu must be held
+func (s *InstanceStore) update(inst *instance.IndependentInstance) *InstanceRecord {
+
+	record, ok := s.records[inst.ID()]
+	if !ok {
+		record = &InstanceRecord{ID: inst.ID(), FlowURI: inst.FlowURI(), FlowName: inst.Name(), StartTime: time.Now()}
+
+		if md := inst.FlowDefinition().Metadata(); md != nil && len(md.Input) > 0 {
+			record.inputs = make(map[string]interface{}, len(md.Input))
+			for name := range md.Input {
+				record.inputs[name], _ = inst.GetValue(name)
+			}
+			record.Inputs = inst.FlowDefinition().MaskValues(record.inputs)
+		}
+		s.add(record)
+	}
+
+	if record.Done() {
+		return record
+	}
+
+	record.Status = inst.Status()
+	record.StepID = inst.StepID()
+
+	if record.Done() {
+		endTime := time.Now()
+		record.EndTime = &endTime
+
+		switch record.Status {
+		case model.FlowStatusCompleted:
+			outputs, _ := inst.GetReturnData()
+			record.Outputs = inst.FlowDefinition().MaskValues(outputs)
+		case model.FlowStatusFailed:
+			if err := inst.GetError(); err != nil {
+				record.Error = err.Error()
+			}
+		case model.FlowStatusCancelled:
+			record.Error = "cancelled"
+		}
+	}
+
+	return record
+}
+
+// add adds the record, evicting the oldest ones if needed, s.mu must be held
+func (s *InstanceStore) add(record *InstanceRecord) {
+
+	for len(s.order) >= s.maxInstances {
+		delete(s.records, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	s.records[record.ID] = record
+	s.order = append(s.order, record.ID)
+}
+
+type multiStateRecorder []instance.StateRecorder
+
+// RecordSnapshot implements instance.StateRecorder.RecordSnapshot
+func (m multiStateRecorder) RecordSnapshot(inst *instance.IndependentInstance) {
+	for _, sr := range m {
+		sr.RecordSnapshot(inst)
+	}
+}
+
+// RecordStep implements instance.StateRecorder.RecordStep
+func (m multiStateRecorder) RecordStep(inst *instance.IndependentInstance) {
+	for _, sr := range m {
+		sr.RecordStep(inst)
+	}
+}
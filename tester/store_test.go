@@ -0,0 +1,132 @@
+package tester
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/qingcloudhx/core/support/log"
+	_ "github.com/qingcloudhx/core/support/test"
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/instance"
+	"github.com/qingcloudhx/flow/model"
+	_ "github.com/qingcloudhx/flow/support/test"
+	"github.com/stretchr/testify/assert"
+)
+
+const storeDefJSON = `
+{
+  "name": "store-flow",
+  "model": "test",
+  "tasks": [
+    { "id": "log1", "activity": { "ref": "testlog", "input": { "message": "first" } } },
+    { "id": "log2", "activity": { "ref": "testlog", "input": { "message": "second" } } }
+  ],
+  "links": [
+    { "from": "log1", "to": "log2" }
+  ]
+}
+`
+
+func newStoreTestInstance(t *testing.T, id string) *instance.IndependentInstance {
+
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(storeDefJSON), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	inst, err := instance.NewIndependentInstance(id, "res://flow:store", def, log.RootLogger())
+	assert.Nil(t, err)
+
+	return inst
+}
+
+func TestInstanceStore_RecordStep(t *testing.T) {
+
+	store := NewInstanceStore(10)
+
+	inst := newStoreTestInstance(t, "1")
+	inst.Start(nil)
+	store.RecordSnapshot(inst)
+
+	record, ok := store.Get("1")
+	assert.True(t, ok)
+	assert.Equal(t, "store-flow", record.FlowName)
+	assert.False(t, record.Done())
+
+	for inst.DoStep() {
+		store.RecordStep(inst)
+	}
+	store.RecordStep(inst)
+
+	record, ok = store.Get("1")
+	assert.True(t, ok)
+	assert.Equal(t, model.FlowStatusCompleted, record.Status)
+	assert.True(t, record.Done())
+	assert.NotNil(t, record.EndTime)
+
+	steps, ok := store.Steps("1")
+	assert.True(t, ok)
+	assert.True(t, len(steps) >= 2)
+	assert.NotEmpty(t, steps[0].Changes)
+}
+
+func TestInstanceStore_Retention(t *testing.T) {
+
+	store := NewInstanceStore(2)
+
+	for _, id := range []string{"1", "2", "3"} {
+		inst := newStoreTestInstance(t, id)
+		inst.Start(nil)
+		store.RecordSnapshot(inst)
+	}
+
+	_, ok := store.Get("1")
+	assert.False(t, ok)
+
+	_, ok = store.Get("3")
+	assert.True(t, ok)
+}
+
+func TestInstanceStore_MasksSensitive(t *testing.T) {
+
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(`{
+	  "name": "sensitive-flow",
+	  "model": "test",
+	  "metadata": { "input": [ { "name": "password", "type": "string" } ] },
+	  "sensitive": [ "password" ],
+	  "tasks": [ { "id": "log1", "activity": { "ref": "testlog", "input": { "message": "first" } } } ]
+	}`), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	inst, err := instance.NewIndependentInstance("1", "res://flow:sensitive", def, log.RootLogger())
+	assert.Nil(t, err)
+
+	store := NewInstanceStore(10)
+
+	inst.Start(map[string]interface{}{"password": "secret"})
+	store.RecordSnapshot(inst)
+	for inst.DoStep() {
+		store.RecordStep(inst)
+	}
+	assert.Nil(t, inst.SetValue("password", "changed"))
+	store.RecordStep(inst)
+
+	record, _ := store.Get("1")
+	assert.Equal(t, definition.MaskedValue, record.Inputs["password"])
+
+	steps, _ := store.Steps("1")
+	for _, step := range steps {
+		assert.NotContains(t, string(step.Changes), "changed")
+	}
+
+	// the recording keeps the clear values to replay the instance
+	rec, _ := store.Recording("1")
+	assert.Equal(t, "secret", rec.Inputs["password"])
+	assert.Contains(t, string(rec.Steps[len(rec.Steps)-1].Changes), "changed")
+}
@@ -0,0 +1,168 @@
+package suite
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/qingcloudhx/flow/model"
+)
+
+// Report is the outcome of the execution of a Suite
+type Report struct {
+	Suite  string        `json:"suite"`
+	Passed int           `json:"passed"`
+	Failed int           `json:"failed"`
+	Cases  []*CaseReport `json:"cases"`
+}
+
+// Success indicates if all the cases of the suite passed
+func (r *Report) Success() bool {
+	return r.Failed == 0
+}
+
+// CaseReport is the outcome of the execution of a Case
+type CaseReport struct {
+	Name     string        `json:"name"`
+	Passed   bool          `json:"passed"`
+	Duration time.Duration `json:"duration"`
+
+	Status  string                 `json:"status"`
+	Error   string                 `json:"error,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Path    []string               `json:"path"`
+	Tasks   map[string]string      `json:"tasks"`
+
+	// FaultSeed is the seed used to inject the faults of the case
+	FaultSeed int64 `json:"faultSeed,omitempty"`
+
+	// Failures are the descriptions of the expectations that were not met
+	Failures []string `json:"failures,omitempty"`
+
+	taskInputs map[string]map[string]interface{}
+}
+
+func (cr *CaseReport) failf(format string, args ...interface{}) {
+	cr.Failures = append(cr.Failures, fmt.Sprintf(format, args...))
+}
+
+// check checks the expectations against the outcome of the case
+func (cr *CaseReport) check(expect *Expect) {
+
+	if expect == nil {
+		cr.Passed = len(cr.Failures) == 0
+		return
+	}
+
+	if expect.Status != "" && !strings.EqualFold(expect.Status, cr.Status) {
+		cr.failf("expected flow status '%s', got '%s'", expect.Status, cr.Status)
+	}
+
+	if expect.Error != "" && !strings.Contains(cr.Error, expect.Error) {
+		cr.failf("expected flow error containing '%s', got '%s'", expect.Error, cr.Error)
+	}
+
+	for name, expected := range expect.Outputs {
+		actual, ok := cr.Outputs[name]
+		if !ok {
+			cr.failf("expected flow output '%s' not set", name)
+		} else if !valuesEqual(expected, actual) {
+			cr.failf("expected flow output '%s' to be %v, got %v", name, expected, actual)
+		}
+	}
+
+	for taskID, expected := range expect.Tasks {
+		actual, ok := cr.Tasks[taskID]
+		if !ok {
+			actual = taskStatusName(model.TaskStatusNotStarted)
+		}
+		if !strings.EqualFold(expected, actual) {
+			cr.failf("expected task '%s' to be '%s', got '%s'", taskID, expected, actual)
+		}
+	}
+
+	if expect.Path != nil && !reflect.DeepEqual(expect.Path, cr.Path) {
+		cr.failf("expected path %v, got %v", expect.Path, cr.Path)
+	}
+
+	for taskID, inputs := range expect.TaskInputs {
+		actualInputs, ok := cr.taskInputs[taskID]
+		if !ok {
+			cr.failf("expected inputs for task '%s', task was not executed", taskID)
+			continue
+		}
+
+		for name, expected := range inputs {
+			actual, ok := actualInputs[name]
+			if !ok {
+				cr.failf("expected input '%s' of task '%s' not set", name, taskID)
+			} else if !valuesEqual(expected, actual) {
+				cr.failf("expected input '%s' of task '%s' to be %v, got %v", name, taskID, expected, actual)
+			}
+		}
+	}
+
+	cr.Passed = len(cr.Failures) == 0
+}
+
+// valuesEqual compares the values using their JSON representation, so that values
+// from a JSON suite can be compared to the typed values of the flow
+func valuesEqual(expected, actual interface{}) bool {
+	return reflect.DeepEqual(normalize(expected), normalize(actual))
+}
+
+func normalize(value interface{}) interface{} {
+
+	b, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+
+	var normalized interface{}
+	err = json.Unmarshal(b, &normalized)
+	if err != nil {
+		return value
+	}
+
+	return normalized
+}
+
+func flowStatusName(status model.FlowStatus) string {
+	switch status {
+	case model.FlowStatusNotStarted:
+		return "notStarted"
+	case model.FlowStatusActive:
+		return "active"
+	case model.FlowStatusCompleted:
+		return "completed"
+	case model.FlowStatusCancelled:
+		return "cancelled"
+	case model.FlowStatusFailed:
+		return "failed"
+	}
+
+	return fmt.Sprintf("%d", status)
+}
+
+func taskStatusName(status model.TaskStatus) string {
+	switch status {
+	case model.TaskStatusNotStarted:
+		return "notStarted"
+	case model.TaskStatusEntered:
+		return "entered"
+	case model.TaskStatusReady:
+		return "ready"
+	case model.TaskStatusWaiting:
+		return "waiting"
+	case model.TaskStatusDone:
+		return "done"
+	case model.TaskStatusSkipped:
+		return "skipped"
+	case model.TaskStatusFailed:
+		return "failed"
+	}
+
+	return fmt.Sprintf("%d", status)
+}
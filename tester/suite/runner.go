@@ -0,0 +1,247 @@
+package suite
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qingcloudhx/core/support/log"
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/instance"
+	"github.com/qingcloudhx/flow/model"
+	"github.com/qingcloudhx/flow/support"
+)
+
+const defaultMaxSteps = 10000
+
+var instanceCtr int64
+
+// Runner executes the cases of a Suite in process
+type Runner struct {
+	// Resolver resolves the definition of a flow, by default support.GetDefinition is used
+	Resolver func(flowURI string) (*definition.Definition, error)
+	// MaxSteps is the maximum number of steps a case can execute
+	MaxSteps int
+	// Listener is also notified during the execution of the cases
+	Listener instance.ExecListener
+
+	logger log.Logger
+}
+
+// NewRunner creates a new Runner
+func NewRunner() *Runner {
+	return &Runner{MaxSteps: defaultMaxSteps, logger: log.ChildLogger(log.RootLogger(), "suite")}
+}
+
+// Run executes the cases of the suite using a default Runner
+func Run(s *Suite) *Report {
+	return NewRunner().Run(s)
+}
+
+// RunTest executes the cases of the suite as sub-tests of t using a default Runner
+func RunTest(t *testing.T, s *Suite) *Report {
+	return NewRunner().RunTest(t, s)
+}
+
+// Run executes the cases of the suite
+func (r *Runner) Run(s *Suite) *Report {
+
+	report := &Report{Suite: s.Name}
+
+	for _, c := range s.Cases {
+		report.add(r.RunCase(s, c))
+	}
+
+	return report
+}
+
+// RunTest executes the cases of the suite as sub-tests of t, each unmet expectation
+// of a case is reported as an error of its sub-test
+func (r *Runner) RunTest(t *testing.T, s *Suite) *Report {
+
+	report := &Report{Suite: s.Name}
+
+	for _, c := range s.Cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			cr := r.RunCase(s, c)
+			for _, failure := range cr.Failures {
+				t.Error(failure)
+			}
+			report.add(cr)
+		})
+	}
+
+	return report
+}
+
+// RunCase executes a case of the suite
+func (r *Runner) RunCase(s *Suite, c *Case) *CaseReport {
+
+	cr := &CaseReport{Name: c.Name, Path: []string{}, Tasks: make(map[string]string),
+		taskInputs: make(map[string]map[string]interface{})}
+
+	start := time.Now()
+	err := r.execute(s, c, cr)
+	cr.Duration = time.Since(start)
+
+	if err != nil {
+		cr.failf("unable to execute case: %v", err)
+		return cr
+	}
+
+	cr.check(c.Expect)
+
+	return cr
+}
+
+func (r *Runner) execute(s *Suite, c *Case, cr *CaseReport) error {
+
+	flowURI := c.FlowURI
+	if flowURI == "" {
+		flowURI = s.FlowURI
+	}
+
+	def, err := r.resolve(flowURI)
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprintf("suite-%d", atomic.AddInt64(&instanceCtr, 1))
+
+	inst, err := instance.NewIndependentInstance(id, flowURI, def, r.getLogger())
+	if err != nil {
+		return err
+	}
+
+	listener := &caseListener{report: cr, delegate: r.Listener}
+	instance.ApplyExecOptions(inst, &instance.ExecOptions{Interceptor: c.interceptor(), Faults: c.Faults, Listener: listener})
+
+	if c.Faults != nil {
+		cr.FaultSeed = c.Faults.Seed
+	}
+
+	inputs := make(map[string]interface{}, len(c.Inputs))
+	for name, value := range c.Inputs {
+		inputs[name] = value
+	}
+
+	inst.Start(inputs)
+
+	maxSteps := r.MaxSteps
+	if maxSteps <= 0 {
+		maxSteps = defaultMaxSteps
+	}
+
+	stepCount := 0
+	hasWork := true
+	for hasWork && inst.Status() < model.FlowStatusCompleted && stepCount < maxSteps {
+		stepCount++
+		hasWork = inst.DoStep()
+	}
+
+	cr.Status = flowStatusName(inst.Status())
+
+	switch inst.Status() {
+	case model.FlowStatusCompleted:
+		cr.Outputs, err = inst.GetReturnData()
+		if err != nil {
+			return err
+		}
+	case model.FlowStatusFailed:
+		if inst.GetError() != nil {
+			cr.Error = inst.GetError().Error()
+		}
+	default:
+		if stepCount >= maxSteps {
+			return fmt.Errorf("flow did not complete within %d steps", maxSteps)
+		}
+		return fmt.Errorf("flow did not complete, status '%s'", cr.Status)
+	}
+
+	return nil
+}
+
+func (r *Runner) resolve(flowURI string) (*definition.Definition, error) {
+
+	if r.Resolver != nil {
+		return r.Resolver(flowURI)
+	}
+
+	def, _, err := support.GetDefinition(flowURI)
+	if err != nil {
+		return nil, err
+	}
+
+	if def == nil {
+		return nil, errors.New("flow not found for URI: " + flowURI)
+	}
+
+	return def, nil
+}
+
+func (r *Runner) getLogger() log.Logger {
+	if r.logger == nil {
+		r.logger = log.ChildLogger(log.RootLogger(), "suite")
+	}
+
+	return r.logger
+}
+
+func (r *Report) add(cr *CaseReport) {
+	if cr.Passed {
+		r.Passed++
+	} else {
+		r.Failed++
+	}
+	r.Cases = append(r.Cases, cr)
+}
+
+// caseListener records the tasks executed by the top level flow of a case
+type caseListener struct {
+	report   *CaseReport
+	delegate instance.ExecListener
+}
+
+// TaskStatusChanged implements instance.ExecListener.TaskStatusChanged
+func (l *caseListener) TaskStatusChanged(inst *instance.IndependentInstance, taskInst *instance.TaskInst) {
+
+	if l.delegate != nil {
+		l.delegate.TaskStatusChanged(inst, taskInst)
+	}
+
+	task := taskInst.Inspect()
+	if task.SubFlowID != 0 {
+		return
+	}
+
+	status := model.TaskStatus(task.Status)
+
+	l.report.Tasks[task.TaskID] = taskStatusName(status)
+
+	if task.Inputs != nil {
+		l.report.taskInputs[task.TaskID] = task.Inputs
+	}
+
+	if status == model.TaskStatusDone {
+		l.report.Path = append(l.report.Path, task.TaskID)
+	}
+}
+
+// LinkStatusChanged implements instance.ExecListener.LinkStatusChanged
+func (l *caseListener) LinkStatusChanged(inst *instance.IndependentInstance, linkInst *instance.LinkInst) {
+
+	if l.delegate != nil {
+		l.delegate.LinkStatusChanged(inst, linkInst)
+	}
+}
+
+// InstanceDone implements instance.DoneListener.InstanceDone
+func (l *caseListener) InstanceDone(inst *instance.IndependentInstance) {
+
+	if listener, ok := l.delegate.(instance.DoneListener); ok {
+		listener.InstanceDone(inst)
+	}
+}
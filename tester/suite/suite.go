@@ -0,0 +1,110 @@
+package suite
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/qingcloudhx/core/data"
+	"github.com/qingcloudhx/flow/support"
+)
+
+// Suite is a set of test cases for flows
+type Suite struct {
+	Name string `json:"name"`
+	// FlowURI is the flow tested by the cases that do not specify one
+	FlowURI string  `json:"flowUri,omitempty"`
+	Cases   []*Case `json:"cases"`
+}
+
+// Case is a test case that executes a flow with the specified inputs and mocks and
+// checks the expectations against the outcome of the execution
+type Case struct {
+	Name    string                 `json:"name"`
+	FlowURI string                 `json:"flowUri,omitempty"`
+	Inputs  map[string]interface{} `json:"inputs,omitempty"`
+	Mocks   []*Mock                `json:"mocks,omitempty"`
+	Expect  *Expect                `json:"expect,omitempty"`
+
+	// Faults are injected in the tasks of the flow, the seed used is recorded in the report
+	Faults *support.FaultInjection `json:"faults,omitempty"`
+}
+
+// Mock replaces the execution of a task, the task either produces the specified
+// outputs or fails with the specified error
+type Mock struct {
+	Task    string                 `json:"task"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// Expect contains the assertions of a test case, only the specified assertions are checked
+type Expect struct {
+	// Status is the expected status of the flow: completed, failed or cancelled
+	Status string `json:"status,omitempty"`
+	// Error is a substring of the expected error of the flow
+	Error string `json:"error,omitempty"`
+	// Outputs are the expected outputs of the flow, other outputs are ignored
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	// Tasks are the expected final statuses of tasks: done, skipped, failed, ...
+	Tasks map[string]string `json:"tasks,omitempty"`
+	// Path is the expected sequence of tasks that completed in the top level flow
+	Path []string `json:"path,omitempty"`
+	// TaskInputs are the expected inputs of tasks, other inputs are ignored
+	TaskInputs map[string]map[string]interface{} `json:"taskInputs,omitempty"`
+}
+
+// Load loads a Suite from the specified JSON file
+func Load(path string) (*Suite, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(b)
+}
+
+// Parse parses a Suite from its JSON representation
+func Parse(b []byte) (*Suite, error) {
+
+	s := &Suite{}
+	err := json.Unmarshal(b, s)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range s.Cases {
+		if c.FlowURI == "" && s.FlowURI == "" {
+			return nil, fmt.Errorf("case %d (%s) of suite '%s' does not specify a flow", i, c.Name, s.Name)
+		}
+	}
+
+	return s, nil
+}
+
+// interceptor creates the interceptor that implements the mocks of the case
+func (c *Case) interceptor() *support.Interceptor {
+
+	if len(c.Mocks) == 0 {
+		return nil
+	}
+
+	interceptor := &support.Interceptor{}
+
+	for _, mock := range c.Mocks {
+		ti := &support.TaskInterceptor{ID: mock.Task, Skip: true}
+
+		if mock.Error != "" {
+			ti.Error = &support.InterceptorError{Message: mock.Error}
+		}
+
+		for name, value := range mock.Outputs {
+			ti.Outputs = append(ti.Outputs, data.NewAttribute(name, data.TypeAny, value))
+		}
+
+		interceptor.TaskInterceptors = append(interceptor.TaskInterceptors, ti)
+	}
+
+	return interceptor
+}
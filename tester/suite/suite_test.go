@@ -0,0 +1,166 @@
+package suite
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/qingcloudhx/core/data/expression"
+	_ "github.com/qingcloudhx/core/data/expression/script"
+	_ "github.com/qingcloudhx/core/support/test"
+	"github.com/qingcloudhx/flow/definition"
+	"github.com/qingcloudhx/flow/support"
+	_ "github.com/qingcloudhx/flow/support/test"
+	"github.com/stretchr/testify/assert"
+)
+
+const suiteDefJSON = `
+{
+  "name": "suite-flow",
+  "model": "test",
+  "metadata": {
+    "input": [ { "name": "in", "type": "string" } ],
+    "output": [ { "name": "out", "type": "any" } ]
+  },
+  "tasks": [
+    { "id": "log1", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "=$flow.in" }, "output": { "out": "=$.message" } } },
+    { "id": "log2", "activity": { "ref": "testlog", "input": { "message": "a" } } },
+    { "id": "log3", "activity": { "ref": "testlog", "input": { "message": "b" } } }
+  ],
+  "links": [
+    { "from": "log1", "to": "log2", "type": "expression", "value": "$flow.out == 'a'" },
+    { "from": "log1", "to": "log3", "type": "expression", "value": "$flow.out != 'a'" }
+  ]
+}
+`
+
+const suiteJSON = `
+{
+  "name": "branches",
+  "flowUri": "res://flow:suite",
+  "cases": [
+    {
+      "name": "branch a",
+      "inputs": { "in": "a" },
+      "expect": {
+        "status": "completed",
+        "outputs": { "out": "a" },
+        "path": [ "log1", "log2" ],
+        "tasks": { "log3": "skipped" },
+        "taskInputs": { "log1": { "message": "a" } }
+      }
+    },
+    {
+      "name": "mocked branch b",
+      "inputs": { "in": "a" },
+      "mocks": [ { "task": "log1", "outputs": { "message": "b" } } ],
+      "expect": { "outputs": { "out": "b" }, "path": [ "log1", "log3" ] }
+    },
+    {
+      "name": "mocked error",
+      "inputs": { "in": "a" },
+      "mocks": [ { "task": "log1", "error": "boom" } ],
+      "expect": { "status": "failed", "error": "boom", "tasks": { "log1": "failed" } }
+    }
+  ]
+}
+`
+
+func init() {
+	definition.SetExprFactory(expression.NewFactory(definition.GetDataResolver()))
+}
+
+func newSuiteTestRunner(t *testing.T) *Runner {
+
+	defRep := &definition.DefinitionRep{}
+	err := json.Unmarshal([]byte(suiteDefJSON), defRep)
+	assert.Nil(t, err)
+
+	def, err := definition.NewDefinition(defRep)
+	assert.Nil(t, err)
+
+	runner := NewRunner()
+	runner.Resolver = func(flowURI string) (*definition.Definition, error) {
+		return def, nil
+	}
+
+	return runner
+}
+
+func TestRunner_RunTest(t *testing.T) {
+
+	s, err := Parse([]byte(suiteJSON))
+	assert.Nil(t, err)
+
+	report := newSuiteTestRunner(t).RunTest(t, s)
+	assert.True(t, report.Success())
+	assert.Equal(t, 3, report.Passed)
+}
+
+func TestRunner_Failures(t *testing.T) {
+
+	s := &Suite{Name: "failures", FlowURI: "res://flow:suite", Cases: []*Case{
+		{
+			Name:   "wrong expectations",
+			Inputs: map[string]interface{}{"in": "a"},
+			Expect: &Expect{Status: "failed", Outputs: map[string]interface{}{"out": "b"}, Path: []string{"log1", "log3"}},
+		},
+	}}
+
+	report := newSuiteTestRunner(t).Run(s)
+	assert.False(t, report.Success())
+	assert.Equal(t, 1, report.Failed)
+	assert.Len(t, report.Cases[0].Failures, 3)
+}
+
+func TestParse_MissingFlow(t *testing.T) {
+
+	_, err := Parse([]byte(`{"name":"invalid","cases":[{"name":"no flow"}]}`))
+	assert.NotNil(t, err)
+}
+
+func TestRunner_Faults(t *testing.T) {
+
+	s := &Suite{Name: "faults", FlowURI: "res://flow:suite", Cases: []*Case{
+		{
+			Name:   "error",
+			Inputs: map[string]interface{}{"in": "a"},
+			Faults: &support.FaultInjection{Faults: []*support.Fault{{TaskID: "log2", Type: support.FaultError, Message: "injected"}}},
+			Expect: &Expect{Status: "failed", Error: "injected", Tasks: map[string]string{"log2": "failed"}},
+		},
+		{
+			Name:   "panic",
+			Inputs: map[string]interface{}{"in": "a"},
+			Faults: &support.FaultInjection{Faults: []*support.Fault{{ActivityRef: "github.com/qingcloudhx/flow/support/test", Type: support.FaultPanic}}},
+			Expect: &Expect{Status: "failed", Error: "unhandled", Path: []string{}},
+		},
+		{
+			Name:   "corrupt",
+			Inputs: map[string]interface{}{"in": "a"},
+			Faults: &support.FaultInjection{Faults: []*support.Fault{{TaskID: "log1", Type: support.FaultCorrupt, Value: "b"}}},
+			Expect: &Expect{Outputs: map[string]interface{}{"out": "b"}, Path: []string{"log1", "log3"}},
+		},
+	}}
+
+	report := newSuiteTestRunner(t).RunTest(t, s)
+	assert.True(t, report.Success())
+	assert.NotZero(t, report.Cases[0].FaultSeed)
+}
+
+func TestRunner_FaultSeed(t *testing.T) {
+
+	runner := newSuiteTestRunner(t)
+
+	run := func(seed int64) string {
+		c := &Case{Name: "random", Inputs: map[string]interface{}{"in": "a"},
+			Faults: &support.FaultInjection{Seed: seed, Faults: []*support.Fault{(&support.Fault{Type: support.FaultError}).SetProbability(0.5)}}}
+
+		cr := runner.RunCase(&Suite{FlowURI: "res://flow:suite"}, c)
+		assert.Equal(t, seed, cr.FaultSeed)
+		return cr.Status + fmt.Sprint(cr.Path)
+	}
+
+	for seed := int64(1); seed < 10; seed++ {
+		assert.Equal(t, run(seed), run(seed))
+	}
+}
@@ -0,0 +1,122 @@
+package flow
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qingcloudhx/core/action"
+	"github.com/qingcloudhx/core/support/test"
+	"github.com/qingcloudhx/flow/instance"
+	"github.com/qingcloudhx/flow/model"
+	flowSupport "github.com/qingcloudhx/flow/support"
+	_ "github.com/qingcloudhx/flow/support/test"
+	"github.com/qingcloudhx/flow/tester"
+	"github.com/stretchr/testify/assert"
+)
+
+const runFlowJSON = `{
+  "name": "%s",
+  "metadata": {
+    "input": [ { "name": "in", "type": "string" } ],
+    "output": [ { "name": "in", "type": "string" } ]
+  },
+  "tasks": [
+    { "id": "echo1", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "=$flow.in" } } },
+    { "id": "echo2", "activity": { "ref": "github.com/qingcloudhx/flow/support/test", "input": { "message": "=$flow.in" } } }
+  ],
+  "links": [ { "from": "echo1", "to": "echo2" } ]
+}`
+
+func writeRunFlow(t *testing.T, dir, name string) string {
+
+	path := filepath.Join(dir, name+".json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(fmt.Sprintf(runFlowJSON, name)), 0644))
+
+	return "file://" + filepath.ToSlash(path)
+}
+
+func TestRequestProcessor_RunFlow(t *testing.T) {
+
+	f := action.GetFactory(FlowRef)
+	af := f.(*ActionFactory)
+	err := af.Initialize(test.NewActionInitCtx())
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "run")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rp := tester.NewRequestProcessor()
+
+	t.Run("Completed", func(t *testing.T) {
+		req := &tester.RunRequest{StartRequest: tester.StartRequest{FlowURI: writeRunFlow(t, dir, "completed"), Data: map[string]interface{}{"in": "hello"}}}
+
+		result, err := rp.RunFlow(req)
+		assert.Nil(t, err)
+		assert.True(t, result.Done)
+		assert.Equal(t, model.FlowStatusCompleted, result.Status)
+		assert.Contains(t, result.Outputs, "in")
+		assert.Empty(t, result.Error)
+
+		_, live := instance.GetDefaultRegistry().Get(result.ID)
+		assert.False(t, live)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		req := &tester.RunRequest{StartRequest: tester.StartRequest{FlowURI: writeRunFlow(t, dir, "failed"),
+			Faults: &flowSupport.FaultInjection{Faults: []*flowSupport.Fault{{TaskID: "echo1", Type: flowSupport.FaultError, Message: "boom"}}}}}
+
+		result, err := rp.RunFlow(req)
+		assert.Nil(t, err)
+		assert.True(t, result.Done)
+		assert.Equal(t, model.FlowStatusFailed, result.Status)
+		assert.Contains(t, result.Error, "boom")
+		assert.Nil(t, result.Outputs)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		req := &tester.RunRequest{StartRequest: tester.StartRequest{FlowURI: writeRunFlow(t, dir, "cancelled"),
+			Faults: &flowSupport.FaultInjection{Faults: []*flowSupport.Fault{{TaskID: "echo1", Type: flowSupport.FaultDelay, Delay: "200ms"}}}}}
+
+		go func() {
+			registry := instance.GetDefaultRegistry()
+			for i := 0; i < 100; i++ {
+				if infos := registry.List(&instance.Filter{FlowName: "cancelled"}); len(infos) > 0 {
+					_ = registry.Cancel(infos[0].ID)
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}()
+
+		result, err := rp.RunFlow(req)
+		assert.Nil(t, err)
+		assert.True(t, result.Done)
+		assert.Equal(t, model.FlowStatusCancelled, result.Status)
+		assert.Contains(t, result.Error, "cancelled")
+		assert.Nil(t, result.Outputs)
+	})
+
+	t.Run("StepLimit", func(t *testing.T) {
+		defer func(max int) { maxStepCount = max }(maxStepCount)
+		maxStepCount = 1
+
+		req := &tester.RunRequest{StartRequest: tester.StartRequest{FlowURI: writeRunFlow(t, dir, "steplimit"), Data: map[string]interface{}{"in": "hello"}}}
+
+		result, err := rp.RunFlow(req)
+		assert.Nil(t, err)
+		assert.True(t, result.Done)
+		assert.Equal(t, model.FlowStatusActive, result.Status)
+		assert.Contains(t, result.Error, "step limit")
+		assert.Nil(t, result.Outputs)
+
+		// the instance didn't complete, it is still live
+		_, live := instance.GetDefaultRegistry().Get(result.ID)
+		assert.True(t, live)
+		instance.GetDefaultRegistry().Unregister(result.ID)
+	})
+}